@@ -3,10 +3,8 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"github.com/nireo/upfi/lib"
 	"mime/multipart"
 	"net/http"
-	"os"
 	"testing"
 
 	"github.com/nireo/upfi/models"
@@ -33,10 +31,6 @@ func TestNewTestUser(t *testing.T) {
 		t.Error("Could not find user, err: ", err)
 	}
 
-	if _, err := os.Stat(lib.AddRootToPath("files/") + user.UUID); os.IsNotExist(err) {
-		t.Error("A file folder wasn't created for the user", err)
-	}
-
 	if err := user.Delete(); err != nil {
 		t.Error("Could not remove user, err: ", err)
 	}
@@ -109,12 +103,6 @@ func TestRegisterComprehensive(t *testing.T) {
 		return
 	}
 
-	// check that a folder has been created
-	if _, err := os.Stat(lib.AddRootToPath("files/") + user.UUID); os.IsNotExist(err) {
-		t.Error("A file folder wasn't created for the user", err)
-		return
-	}
-
 	// after all this remove the user
 	if err := user.Delete(); err != nil {
 		t.Error("Could not remove user, err: ", err)
@@ -5,7 +5,8 @@ import (
 	"crypto/cipher"
 	"crypto/md5"
 	"encoding/hex"
-	"io/ioutil"
+	"io"
+	"os"
 )
 
 func createHash(key string) string {
@@ -15,6 +16,8 @@ func createHash(key string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// decrypt decrypts data in the older whole-file format, in which the data is a nonce followed by the
+// sealed file.
 func decrypt(data []byte, passphrase string) ([]byte, error) {
 	key := []byte(createHash(passphrase))
 	block, err := aes.NewCipher(key)
@@ -40,19 +43,27 @@ func decrypt(data []byte, passphrase string) ([]byte, error) {
 // DecryptToDst takes in a file path destination, a source file path and a decryption key.
 // The file from src is decrypted using the key and then the decrypted file is placed into dst.
 func DecryptToDst(dst, src, key string) error {
-	data, err := ioutil.ReadFile(src)
+	file, err := os.Open(src)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	decryptedData, err := decrypt(data, key)
+	dec, err := NewReader(file, key)
 	if err != nil {
 		return err
 	}
 
-	if err := ioutil.WriteFile(dst, decryptedData, 0666); err != nil {
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dstFile, dec); err != nil {
+		dstFile.Close()
+		os.Remove(dst)
 		return err
 	}
 
-	return nil
+	return dstFile.Close()
 }
@@ -0,0 +1,58 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// Every encrypted file has its own random data key, which is used to encrypt the file's contents. The
+// data key is then wrapped (encrypted) with a key derived from the user's master password. This way
+// changing the master password only requires wrapping the small data keys again, instead of encrypting
+// all of the files again.
+
+// GenerateDataKey creates a new random 256-bit data key.
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// WrapKey encrypts the data key using the key encryption key. The result contains a random nonce
+// followed by the sealed data key.
+func WrapKey(kek, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// UnwrapKey decrypts a data key wrapped with WrapKey. If the key encryption key is wrong, the
+// ErrInvalidCiphertext error is returned.
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return dataKey, nil
+}
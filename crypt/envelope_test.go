@@ -0,0 +1,100 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWrapKey(t *testing.T) {
+	derivation, err := NewKeyDerivation()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kek, err := DeriveKey("master", derivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := WrapKey(kek, dataKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	unwrapped, err := UnwrapKey(kek, wrapped)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Error("the unwrapped key doesn't match the data key")
+		return
+	}
+
+	wrongKEK, err := DeriveKey("wrong", derivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnwrapKey(wrongKEK, wrapped); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error with the wrong master, got: %v", err)
+	}
+}
+
+func TestDataKeyStream(t *testing.T) {
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, chunkSize+100)
+	rand.Read(data)
+
+	encrypted, err := ioutil.ReadAll(EncryptReaderWithKey(bytes.NewReader(data), dataKey))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	info, _, err := Inspect(bytes.NewReader(encrypted))
+	if err != nil || !info.DataKey || info.Version != CurrentVersion {
+		t.Errorf("wrong info for a file encrypted with a data key: %+v, err: %v", info, err)
+		return
+	}
+
+	dec, err := NewKeyReader(bytes.NewReader(encrypted), dataKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	decrypted, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(decrypted, data) {
+		t.Error("decrypted data doesn't match")
+		return
+	}
+
+	// The file cannot be decrypted with a passphrase and a passphrase file cannot be decrypted with a key.
+	if _, err := NewReader(bytes.NewReader(encrypted), "secret"); err != ErrKeyMismatch {
+		t.Errorf("expected a key mismatch error, got: %v", err)
+	}
+
+	passphraseFile := encryptBytes(t, data, "secret")
+	if _, err := NewKeyReader(bytes.NewReader(passphraseFile), dataKey); err != ErrKeyMismatch {
+		t.Errorf("expected a key mismatch error, got: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// The key derivation functions which can be recorded in a key derivation record.
+const (
+	// kdfNone means that the key isn't derived from a passphrase, but given by the caller.
+	kdfNone   = 0
+	kdfScrypt = 1
+)
+
+// KDFParams contains the scrypt parameters used to derive the encryption key from the passphrase.
+// The parameters are stored along with the salt, so that they can be changed without breaking the
+// existing files.
+type KDFParams struct {
+	LogN uint8 // The CPU and memory cost is 2^LogN.
+	R    uint32
+	P    uint32
+}
+
+// DefaultKDFParams are the parameters used when deriving new keys. These use 32 MB of memory.
+var DefaultKDFParams = KDFParams{LogN: 15, R: 8, P: 1}
+
+// maxKDFMemory limits the memory used by the parameters read from a header, so that a modified header
+// cannot make the server use huge amounts of memory.
+const maxKDFMemory = 1 << 30
+
+const (
+	saltSize = 16
+	keySize  = 32
+
+	// derivationSize is the size of a key derivation record: the kdf (1 byte), the scrypt log2(N)
+	// (1 byte), r (4 bytes) and p (4 bytes) parameters and a random salt (16 bytes).
+	derivationSize = 1 + 1 + 4 + 4 + saltSize
+)
+
+var errInvalidParams = errors.New("crypt: invalid key derivation parameters")
+
+func (p KDFParams) valid() bool {
+	if p.LogN < 1 || p.LogN > 30 || p.R == 0 || p.P == 0 || p.P > 16 {
+		return false
+	}
+
+	// scrypt uses 128 * r * N bytes of memory.
+	return 128*uint64(p.R)*(uint64(1)<<p.LogN) <= maxKDFMemory
+}
+
+// NewKeyDerivation creates a key derivation record with the default parameters and a random salt. The
+// record can be stored, and later given to DeriveKey along with the passphrase to get the same key.
+func NewKeyDerivation() ([]byte, error) {
+	params := DefaultKDFParams
+	record := make([]byte, derivationSize)
+
+	record[0] = kdfScrypt
+	record[1] = params.LogN
+	binary.BigEndian.PutUint32(record[2:], params.R)
+	binary.BigEndian.PutUint32(record[6:], params.P)
+
+	if _, err := io.ReadFull(rand.Reader, record[10:]); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// DeriveKey derives a 256-bit key from the passphrase using the parameters and the salt in the key
+// derivation record.
+func DeriveKey(passphrase string, derivation []byte) ([]byte, error) {
+	if len(derivation) != derivationSize || derivation[0] != kdfScrypt {
+		return nil, errInvalidParams
+	}
+
+	params := KDFParams{
+		LogN: derivation[1],
+		R:    binary.BigEndian.Uint32(derivation[2:]),
+		P:    binary.BigEndian.Uint32(derivation[6:]),
+	}
+
+	if !params.valid() {
+		return nil, errInvalidParams
+	}
+
+	return scrypt.Key([]byte(passphrase), derivation[10:], 1<<params.LogN, int(params.R), int(params.P), keySize)
+}
+
+// legacyKey returns the key used by the older formats, which is the hex encoded md5 hash of the
+// passphrase. This is only used to read the older files.
+func legacyKey(passphrase string) []byte {
+	return []byte(createHash(passphrase))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,92 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The data keys of the shared files are sealed to the public key of the recipient. A new ephemeral key
+// pair is created for every sealed key, and the shared secret of the ephemeral private key and the
+// recipient's public key is expanded with HKDF into a key encryption key. Only the recipient can derive
+// the same key using their private key and the ephemeral public key stored with the sealed key.
+
+// sealInfo separates the keys derived for sealing data keys from any other use of the key pairs.
+const sealInfo = "upfi sealed data key"
+
+// GenerateKeyPair creates a new X25519 key pair.
+func GenerateKeyPair() (publicKey, privateKey []byte, err error) {
+	privateKey = make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, privateKey); err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, err = curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return publicKey, privateKey, nil
+}
+
+// SealKey encrypts the data key so that only the owner of the private key matching the public key can
+// decrypt it. The result contains the ephemeral public key followed by the wrapped data key.
+func SealKey(publicKey, dataKey []byte) ([]byte, error) {
+	ephemeralPublic, ephemeralPrivate, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := sealingKey(ephemeralPrivate, publicKey, ephemeralPublic, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := WrapKey(kek, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ephemeralPublic, wrapped...), nil
+}
+
+// OpenKey decrypts a data key sealed with SealKey using the recipient's private key. If the private key
+// is wrong or the sealed key has been modified, ErrInvalidCiphertext is returned.
+func OpenKey(privateKey, sealed []byte) ([]byte, error) {
+	if len(sealed) < curve25519.PointSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic := sealed[:curve25519.PointSize]
+	kek, err := sealingKey(privateKey, ephemeralPublic, ephemeralPublic, publicKey)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return UnwrapKey(kek, sealed[curve25519.PointSize:])
+}
+
+// sealingKey derives the key encryption key from the shared secret of the private and the public key.
+// Both of the public keys are used as the salt, so that the key is bound to this pair of keys.
+func sealingKey(privateKey, publicKey, ephemeralPublic, recipientPublic []byte) ([]byte, error) {
+	secret, err := curve25519.X25519(privateKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append(append([]byte(nil), ephemeralPublic...), recipientPublic...)
+	kek := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(sealInfo)), kek); err != nil {
+		return nil, err
+	}
+
+	return kek, nil
+}
@@ -0,0 +1,71 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealKey(t *testing.T) {
+	publicKey, privateKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := SealKey(publicKey, dataKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	opened, err := OpenKey(privateKey, sealed)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(opened, dataKey) {
+		t.Error("the opened key doesn't match the data key")
+		return
+	}
+
+	// Sealing the same key twice uses a different ephemeral key.
+	again, err := SealKey(publicKey, dataKey)
+	if err != nil || bytes.Equal(again, sealed) {
+		t.Errorf("the same key was sealed twice in the same way, err: %v", err)
+	}
+}
+
+func TestOpenKeyWrongKey(t *testing.T) {
+	publicKey, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherPrivate, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := SealKey(publicKey, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenKey(otherPrivate, sealed); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error with the wrong private key, got: %v", err)
+	}
+
+	if _, err := OpenKey(otherPrivate, sealed[:10]); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error for a truncated key, got: %v", err)
+	}
+}
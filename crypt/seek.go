@@ -0,0 +1,154 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// sealedChunkSize is the size of a full chunk in the encrypted data.
+const sealedChunkSize = chunkSize + tagSize
+
+type readSeeker struct {
+	src    io.ReadSeeker
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunks int64
+	size   int64 // the size of the decrypted data
+	pos    int64
+	srcPos int64 // the position of src, -1 if it's unknown
+
+	chunk int64 // the index of the chunk in plain, -1 if there is no chunk
+	plain []byte
+	buf   []byte
+}
+
+// NewKeyReadSeeker returns a reader which decrypts the data in src using the given data key. Unlike the
+// reader returned by NewKeyReader, it can seek. Only the chunks which contain the data that is read are
+// read from src and decrypted, so parts of a large file can be read without decrypting the data before
+// them. The chunk positions are based on the size of src, so a truncated file is still detected when
+// its last chunk is read.
+func NewKeyReadSeeker(src io.ReadSeeker, dataKey []byte) (io.ReadSeeker, error) {
+	srcSize, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	h, err := readHeader(src)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrInvalidCiphertext
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if h.version != CurrentVersion || h.derivation[0] != kdfNone {
+		return nil, ErrKeyMismatch
+	}
+
+	// Every file has atleast the final chunk, which can be empty. Only the last chunk can be shorter
+	// than a full chunk, but it still needs to contain the authentication tag.
+	body := srcSize - int64(len(h.raw))
+	chunks := (body + sealedChunkSize - 1) / sealedChunkSize
+	if chunks == 0 || chunks > 1<<32 || body-(chunks-1)*sealedChunkSize < tagSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readSeeker{
+		src:    src,
+		aead:   aead,
+		header: h.raw,
+		prefix: h.prefix,
+		chunks: chunks,
+		size:   body - chunks*tagSize,
+		srcPos: int64(len(h.raw)),
+		chunk:  -1,
+		plain:  make([]byte, 0, chunkSize),
+		buf:    make([]byte, sealedChunkSize),
+	}, nil
+}
+
+func (rs *readSeeker) Read(p []byte) (int, error) {
+	if rs.pos >= rs.size {
+		return 0, io.EOF
+	}
+
+	index := rs.pos / chunkSize
+	if index != rs.chunk {
+		if err := rs.open(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, rs.plain[rs.pos-index*chunkSize:])
+	rs.pos += int64(n)
+	return n, nil
+}
+
+// open reads and decrypts the chunk with the given index.
+func (rs *readSeeker) open(index int64) error {
+	rs.chunk = -1
+
+	offset := int64(len(rs.header)) + index*sealedChunkSize
+	if offset != rs.srcPos {
+		if _, err := rs.src.Seek(offset, io.SeekStart); err != nil {
+			rs.srcPos = -1
+			return err
+		}
+		rs.srcPos = offset
+	}
+
+	final := index == rs.chunks-1
+	sealed := rs.buf
+	if final {
+		sealed = rs.buf[:rs.size-index*chunkSize+tagSize]
+	}
+
+	n, err := io.ReadFull(rs.src, sealed)
+	rs.srcPos += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidCiphertext
+	}
+	if err != nil {
+		rs.srcPos = -1
+		return err
+	}
+
+	plain, err := rs.aead.Open(rs.plain[:0], chunkNonce(rs.prefix, uint32(index), final), sealed, rs.header)
+	if err != nil {
+		return ErrInvalidCiphertext
+	}
+
+	rs.plain = plain
+	rs.chunk = index
+	return nil
+}
+
+func (rs *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rs.pos
+	case io.SeekEnd:
+		offset += rs.size
+	default:
+		return 0, errors.New("crypt: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("crypt: negative position")
+	}
+
+	rs.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,110 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func encryptWithKey(t *testing.T, data, dataKey []byte) []byte {
+	encrypted, err := ioutil.ReadAll(EncryptReaderWithKey(bytes.NewReader(data), dataKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted
+}
+
+func TestKeyReadSeeker(t *testing.T) {
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5}
+	for _, size := range sizes {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		rs, err := NewKeyReadSeeker(bytes.NewReader(encryptWithKey(t, data, dataKey)), dataKey)
+		if err != nil {
+			t.Errorf("could not open %d bytes, err: %s", size, err)
+			continue
+		}
+
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err != nil || end != int64(size) {
+			t.Errorf("wrong size. want=%d, got=%d, err: %v", size, end, err)
+			continue
+		}
+
+		// Read ranges which start and end at different places of the chunks.
+		ranges := [][2]int{{0, size}, {size / 2, size}, {size / 3, size / 2}, {size - 1, size}}
+		for _, rng := range ranges {
+			if rng[0] < 0 || rng[0] > rng[1] {
+				continue
+			}
+
+			if _, err := rs.Seek(int64(rng[0]), io.SeekStart); err != nil {
+				t.Error(err)
+				continue
+			}
+
+			got := make([]byte, rng[1]-rng[0])
+			if _, err := io.ReadFull(rs, got); err != nil {
+				t.Errorf("could not read %v of %d bytes, err: %s", rng, size, err)
+				continue
+			}
+
+			if !bytes.Equal(got, data[rng[0]:rng[1]]) {
+				t.Errorf("wrong data in %v of %d bytes", rng, size)
+			}
+		}
+	}
+}
+
+func TestKeyReadSeekerTampering(t *testing.T) {
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 2*chunkSize+100)
+	encrypted := encryptWithKey(t, data, dataKey)
+
+	// Flip a bit in the last chunk and read only that chunk.
+	modified := append([]byte(nil), encrypted...)
+	modified[len(modified)-tagSize-1] ^= 1
+	rs, err := NewKeyReadSeeker(bytes.NewReader(modified), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs.Seek(2*chunkSize, io.SeekStart)
+	if _, err := rs.Read(make([]byte, 10)); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error for a modified chunk, got: %v", err)
+	}
+
+	// Remove the final chunk, such that the second chunk would be the last one.
+	truncated := encrypted[:headerSize+2*sealedChunkSize]
+	rs, err = NewKeyReadSeeker(bytes.NewReader(truncated), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs.Seek(chunkSize, io.SeekStart)
+	if _, err := rs.Read(make([]byte, 10)); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error for a truncated file, got: %v", err)
+	}
+
+	// The tag of the last chunk cannot be partial.
+	if _, err := NewKeyReadSeeker(bytes.NewReader(encrypted[:headerSize+sealedChunkSize+5]), dataKey); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error for a partial chunk, got: %v", err)
+	}
+
+	if _, err := NewKeyReadSeeker(bytes.NewReader(encryptBytes(t, data, "secret")), dataKey); err != ErrKeyMismatch {
+		t.Errorf("expected a key mismatch for a file encrypted with a passphrase, got: %v", err)
+	}
+}
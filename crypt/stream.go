@@ -0,0 +1,466 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// The encrypted files are stored in a segmented format, such that encrypting and decrypting a file
+// doesn't require the whole file to be in memory. The format is:
+//
+//   header: magic (7 bytes) | version (1 byte) | key derivation (26 bytes) | chunk size (4 bytes) | nonce prefix (7 bytes)
+//   chunks: AES-GCM sealed chunks of 'chunk size' plaintext bytes, the last chunk can be shorter.
+//
+// The key derivation part is a key derivation record (see NewKeyDerivation). Files encrypted with a
+// passphrase have their own random salt, so every file is encrypted with a different key. Files
+// encrypted with a data key have a record with the kdf set to none. The first version didn't have the
+// key derivation part and used the md5 hash of the passphrase as the key, those files can still be read.
+//
+// The nonce of each chunk is the nonce prefix followed by a 4 byte chunk counter and a single byte
+// which is 1 for the final chunk and 0 otherwise. The header is used as additional data for every
+// chunk. This way chunks cannot be reordered, removed or moved between files without the decryption
+// failing, and a file that was truncated at a chunk boundary is detected since the final flag is missing.
+
+const (
+	magic           = "upfienc"
+	chunkSize       = 64 * 1024
+	noncePrefixSize = 7
+	tagSize         = 16
+	headerSize      = len(magic) + 1 + derivationSize + 4 + noncePrefixSize
+)
+
+// CurrentVersion is the version of the format used to encrypt new files. Files with an older version
+// should be re-encrypted the next time the passphrase is known.
+const CurrentVersion = 2
+
+var (
+	// ErrInvalidCiphertext is returned when the encrypted data has been modified, truncated or the
+	// decryption key is wrong.
+	ErrInvalidCiphertext = errors.New("crypt: message authentication failed")
+
+	// ErrUnsupportedVersion is returned when the encrypted data has been created with a newer version
+	// of the format.
+	ErrUnsupportedVersion = errors.New("crypt: unsupported format version")
+
+	// ErrKeyMismatch is returned when a file encrypted with a data key is decrypted with a passphrase
+	// or the other way around.
+	ErrKeyMismatch = errors.New("crypt: the file is encrypted with a different kind of key")
+)
+
+// Info describes how a file has been encrypted.
+type Info struct {
+	// Version is the format version. Files encrypted with the older whole-file format have the version 0.
+	Version int
+
+	// DataKey tells if the file is encrypted with a data key rather than a passphrase.
+	DataKey bool
+}
+
+// Inspect reads the start of the encrypted data and returns information about it along with a reader
+// which still contains all of the data.
+func Inspect(src io.Reader) (Info, io.Reader, error) {
+	start := make([]byte, len(magic)+2)
+	n, err := io.ReadFull(src, start)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Info{}, nil, err
+	}
+
+	replay := io.MultiReader(bytes.NewReader(start[:n]), src)
+	if n < len(start) || !bytes.Equal(start[:len(magic)], []byte(magic)) {
+		return Info{Version: 0}, replay, nil
+	}
+
+	info := Info{Version: int(start[len(magic)])}
+	if info.Version >= 2 {
+		info.DataKey = start[len(magic)+1] == kdfNone
+	}
+
+	return info, replay, nil
+}
+
+// header contains the parsed fields of a stream header.
+type header struct {
+	raw        []byte // the whole header, used as the additional data
+	version    int
+	derivation []byte
+	prefix     []byte
+}
+
+// newHeader creates a header in the current version with the given key derivation record and a random
+// nonce prefix.
+func newHeader(derivation []byte) (*header, error) {
+	raw := make([]byte, headerSize)
+
+	copy(raw, magic)
+	raw[len(magic)] = CurrentVersion
+	copy(raw[len(magic)+1:], derivation)
+	binary.BigEndian.PutUint32(raw[len(magic)+1+derivationSize:], chunkSize)
+
+	prefix := raw[headerSize-noncePrefixSize:]
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+
+	return &header{raw: raw, version: CurrentVersion, derivation: derivation, prefix: prefix}, nil
+}
+
+// readHeader reads and parses the header of a file in the chunked format.
+func readHeader(src io.Reader) (*header, error) {
+	start := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(src, start); err != nil {
+		return nil, err
+	}
+
+	version := int(start[len(magic)])
+	var rest []byte
+	switch version {
+	case 1:
+		rest = make([]byte, 4+noncePrefixSize)
+	case CurrentVersion:
+		rest = make([]byte, derivationSize+4+noncePrefixSize)
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+
+	if _, err := io.ReadFull(src, rest); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, ErrInvalidCiphertext
+		}
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(rest[len(rest)-noncePrefixSize-4:]) != chunkSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	h := &header{
+		raw:     append(start, rest...),
+		version: version,
+		prefix:  rest[len(rest)-noncePrefixSize:],
+	}
+
+	if version == CurrentVersion {
+		h.derivation = rest[:derivationSize]
+	}
+
+	return h, nil
+}
+
+// passphraseKey returns the key of a file encrypted with a passphrase.
+func (h *header) passphraseKey(passphrase string) ([]byte, error) {
+	if h.version == 1 {
+		return legacyKey(passphrase), nil
+	}
+
+	if h.derivation[0] == kdfNone {
+		return nil, ErrKeyMismatch
+	}
+
+	return DeriveKey(passphrase, h.derivation)
+}
+
+// chunkNonce builds the nonce for the chunk with the given counter.
+func chunkNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+
+	return nonce
+}
+
+type writer struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewWriter returns a writer which encrypts everything written to it using a key derived from the
+// passphrase and writes the encrypted data into dst. The caller must call Close to write the final
+// chunk. Closing the writer doesn't close dst.
+func NewWriter(dst io.Writer, passphrase string) (io.WriteCloser, error) {
+	derivation, err := NewKeyDerivation()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DeriveKey(passphrase, derivation)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriter(dst, derivation, key)
+}
+
+// NewKeyWriter is like NewWriter, but the data is encrypted using the given 256-bit data key.
+func NewKeyWriter(dst io.Writer, dataKey []byte) (io.WriteCloser, error) {
+	if len(dataKey) != keySize {
+		return nil, errors.New("crypt: invalid data key size")
+	}
+
+	return newWriter(dst, make([]byte, derivationSize), dataKey)
+}
+
+func newWriter(dst io.Writer, derivation, key []byte) (io.WriteCloser, error) {
+	h, err := newHeader(derivation)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dst.Write(h.raw); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		dst:    dst,
+		aead:   aead,
+		header: h.raw,
+		prefix: h.prefix,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("crypt: write to closed writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once we know there is more data, since the last chunk needs to be
+		// marked as the final one.
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *writer) seal(final bool) error {
+	if w.counter == ^uint32(0) {
+		return errors.New("crypt: file is too large")
+	}
+
+	sealed := w.aead.Seal(nil, chunkNonce(w.prefix, w.counter, final), w.buf, w.header)
+	if _, err := w.dst.Write(sealed); err != nil {
+		return err
+	}
+
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the final chunk, which can also be empty.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.seal(true)
+}
+
+type reader struct {
+	src      io.Reader
+	aead     cipher.AEAD
+	header   []byte
+	prefix   []byte
+	buf      []byte // holds the sealed chunk and one byte of the next chunk
+	buffered int
+	plain    []byte
+	counter  uint32
+	done     bool
+	err      error
+}
+
+// NewReader returns a reader which decrypts the data read from src using the passphrase. Every chunk
+// is authenticated before it is returned, so the data that is read can be trusted. All of the older
+// versions of the format are supported, but files in the whole-file format are read into memory.
+func NewReader(src io.Reader, passphrase string) (io.Reader, error) {
+	info, src, err := Inspect(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Version == 0 {
+		return newLegacyReader(src, passphrase)
+	}
+
+	h, err := readHeader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := h.passphraseKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(src, h, key)
+}
+
+// NewKeyReader is like NewReader, but the data is decrypted using the given data key.
+func NewKeyReader(src io.Reader, dataKey []byte) (io.Reader, error) {
+	h, err := readHeader(src)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrInvalidCiphertext
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if h.version != CurrentVersion || h.derivation[0] != kdfNone {
+		return nil, ErrKeyMismatch
+	}
+
+	return newReader(src, h, dataKey)
+}
+
+func newReader(src io.Reader, h *header, key []byte) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{
+		src:    src,
+		aead:   aead,
+		header: h.raw,
+		prefix: h.prefix,
+		buf:    make([]byte, chunkSize+tagSize+1),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		if r.done {
+			return 0, io.EOF
+		}
+
+		r.err = r.open()
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk. One byte of the next chunk is read ahead, so that we know if
+// the current chunk is the final one.
+func (r *reader) open() error {
+	n, err := io.ReadFull(r.src, r.buf[r.buffered:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	n += r.buffered
+
+	final := n <= chunkSize+tagSize
+	sealed := r.buf[:n]
+	if !final {
+		sealed = r.buf[:chunkSize+tagSize]
+	}
+
+	plain, err := r.aead.Open(nil, chunkNonce(r.prefix, r.counter, final), sealed, r.header)
+	if err != nil {
+		return ErrInvalidCiphertext
+	}
+
+	if final {
+		r.done = true
+	} else {
+		// Move the read ahead byte to the start of the buffer.
+		r.buf[0] = r.buf[chunkSize+tagSize]
+		r.buffered = 1
+	}
+
+	r.counter++
+	r.plain = plain
+	return nil
+}
+
+// newLegacyReader decrypts files which were encrypted with the oldest format, in which the whole file
+// was sealed at once.
+func newLegacyReader(src io.Reader, passphrase string) (io.Reader, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data needs to contain atleast the nonce and the authentication tag.
+	if len(data) < 12+tagSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	plaintext, err := decrypt(data, passphrase)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return bytes.NewReader(plaintext), nil
+}
+
+// EncryptReader returns a reader which yields the encrypted form of the data in src. This is useful
+// when the encrypted data needs to be given to something that reads, such as a storage backend. The
+// encryption happens in a separate goroutine, which stops when the returned reader is closed.
+func EncryptReader(src io.Reader, passphrase string) io.ReadCloser {
+	return encryptReader(src, func(w io.Writer) (io.WriteCloser, error) {
+		return NewWriter(w, passphrase)
+	})
+}
+
+// EncryptReaderWithKey is like EncryptReader, but the data is encrypted using the given data key.
+func EncryptReaderWithKey(src io.Reader, dataKey []byte) io.ReadCloser {
+	return encryptReader(src, func(w io.Writer) (io.WriteCloser, error) {
+		return NewKeyWriter(w, dataKey)
+	})
+}
+
+func encryptReader(src io.Reader, open func(io.Writer) (io.WriteCloser, error)) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		enc, err := open(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		if _, err := io.Copy(enc, src); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		pw.CloseWithError(enc.Close())
+	}()
+
+	return pr
+}
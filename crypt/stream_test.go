@@ -0,0 +1,220 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func encryptBytes(t *testing.T, data []byte, passphrase string) []byte {
+	var buf bytes.Buffer
+	enc, err := NewWriter(&buf, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := enc.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decryptBytes(data []byte, passphrase string) ([]byte, error) {
+	dec, err := NewReader(bytes.NewReader(data), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(dec)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5}
+
+	for _, size := range sizes {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		encrypted := encryptBytes(t, data, "secret")
+		// A full last chunk is the final chunk, so an empty chunk is only written for empty files.
+		chunks := (size + chunkSize - 1) / chunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+
+		expectedSize := headerSize + size + chunks*tagSize
+		if len(encrypted) != expectedSize {
+			t.Errorf("wrong encrypted size for %d bytes. want=%d, got=%d", size, expectedSize, len(encrypted))
+			continue
+		}
+
+		decrypted, err := decryptBytes(encrypted, "secret")
+		if err != nil {
+			t.Errorf("could not decrypt %d bytes, err: %s", size, err)
+			continue
+		}
+
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("decrypted data doesn't match for %d bytes", size)
+		}
+	}
+}
+
+func TestStreamWrongKey(t *testing.T) {
+	encrypted := encryptBytes(t, []byte("some secret data"), "secret")
+	if _, err := decryptBytes(encrypted, "wrong"); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error with the wrong key, got: %v", err)
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	data := make([]byte, 2*chunkSize+100)
+	encrypted := encryptBytes(t, data, "secret")
+
+	// Flip a single bit in the second chunk.
+	modified := append([]byte(nil), encrypted...)
+	modified[headerSize+chunkSize+tagSize+10] ^= 1
+	if _, err := decryptBytes(modified, "secret"); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error for a modified chunk, got: %v", err)
+	}
+
+	// Remove the final chunk, such that the file ends on a chunk boundary.
+	truncated := encrypted[:headerSize+2*(chunkSize+tagSize)]
+	if _, err := decryptBytes(truncated, "secret"); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error for a truncated file, got: %v", err)
+	}
+
+	// Swap the first two chunks.
+	swapped := append([]byte(nil), encrypted...)
+	first := headerSize
+	second := headerSize + chunkSize + tagSize
+	copy(swapped[first:second], encrypted[second:second+chunkSize+tagSize])
+	copy(swapped[second:second+chunkSize+tagSize], encrypted[first:second])
+	if _, err := decryptBytes(swapped, "secret"); err != ErrInvalidCiphertext {
+		t.Errorf("expected an authentication error for reordered chunks, got: %v", err)
+	}
+}
+
+// TestLegacyFormat checks that files which were encrypted as a whole before the chunked format are
+// still readable.
+func TestLegacyFormat(t *testing.T) {
+	data := []byte("this file was encrypted with the old format")
+
+	block, err := aes.NewCipher([]byte(createHash("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	legacy := gcm.Seal(nonce, nonce, data, nil)
+
+	decrypted, err := decryptBytes(legacy, "secret")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(decrypted, data) {
+		t.Error("decrypted legacy data doesn't match")
+	}
+}
+
+func TestEncryptReader(t *testing.T) {
+	data := make([]byte, chunkSize*2+7)
+	rand.Read(data)
+
+	encrypted, err := ioutil.ReadAll(EncryptReader(bytes.NewReader(data), "secret"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	decrypted, err := decryptBytes(encrypted, "secret")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(decrypted, data) {
+		t.Error("decrypted data doesn't match")
+	}
+}
+
+// TestFirstVersion checks that files in the first chunked version, which used the md5 hash of the
+// passphrase as the key, are still readable.
+func TestFirstVersion(t *testing.T) {
+	data := make([]byte, chunkSize+10)
+	rand.Read(data)
+
+	header := append([]byte(magic), 1, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7)
+	binary.BigEndian.PutUint32(header[len(magic)+1:], chunkSize)
+
+	aead, err := newGCM(legacyKey("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := header[len(header)-noncePrefixSize:]
+	encrypted := append([]byte(nil), header...)
+	encrypted = aead.Seal(encrypted, chunkNonce(prefix, 0, false), data[:chunkSize], header)
+	encrypted = aead.Seal(encrypted, chunkNonce(prefix, 1, true), data[chunkSize:], header)
+
+	info, _, err := Inspect(bytes.NewReader(encrypted))
+	if err != nil || info.Version != 1 {
+		t.Errorf("wrong version. want=1, got=%d, err: %v", info.Version, err)
+		return
+	}
+
+	decrypted, err := decryptBytes(encrypted, "secret")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(decrypted, data) {
+		t.Error("decrypted data doesn't match")
+	}
+}
+
+// TestPerFileSalt checks that encrypting the same data twice results in different headers and data,
+// since every file gets its own salt.
+func TestPerFileSalt(t *testing.T) {
+	data := []byte("the same data")
+	first := encryptBytes(t, data, "secret")
+	second := encryptBytes(t, data, "secret")
+
+	if bytes.Equal(first[:headerSize], second[:headerSize]) || bytes.Equal(first, second) {
+		t.Error("two files were encrypted with the same salt")
+		return
+	}
+
+	info, _, err := Inspect(bytes.NewReader(first))
+	if err != nil || info.Version != CurrentVersion || info.DataKey {
+		t.Errorf("wrong info. want version %d with a passphrase, got=%+v, err: %v", CurrentVersion, info, err)
+	}
+}
+
+func TestInvalidKDFParams(t *testing.T) {
+	encrypted := encryptBytes(t, []byte("data"), "secret")
+
+	// Make the header ask for a huge amount of memory.
+	encrypted[len(magic)+2] = 30
+	if _, err := decryptBytes(encrypted, "secret"); err != errInvalidParams {
+		t.Errorf("expected an invalid parameters error, got: %v", err)
+	}
+}
@@ -38,6 +38,35 @@ var (
 		Description: "You're not allowed to view the content on this page.",
 	}
 
+	// TooLargeErrorPage is used when the uploaded file is larger than the maximum upload size.
+	TooLargeErrorPage = ErrorPageContent{
+		StatusCode:  fasthttp.StatusRequestEntityTooLarge,
+		MainMessage: fasthttp.StatusMessage(fasthttp.StatusRequestEntityTooLarge),
+		Description: "The file is larger than the maximum upload size.",
+	}
+
+	// QuotaExceededErrorPage is used when the uploaded file doesn't fit into the user's storage quota.
+	QuotaExceededErrorPage = ErrorPageContent{
+		StatusCode:  fasthttp.StatusRequestEntityTooLarge,
+		MainMessage: "Storage quota exceeded",
+		Description: "The file doesn't fit into your storage quota. Remove some files or ask an admin for a larger quota.",
+	}
+
+	// LinkUnavailableErrorPage is used when a share link has expired or reached its download limit.
+	LinkUnavailableErrorPage = ErrorPageContent{
+		StatusCode:  fasthttp.StatusGone,
+		MainMessage: fasthttp.StatusMessage(fasthttp.StatusGone),
+		Description: "The share link has expired or reached its download limit.",
+	}
+
+	// NoPublicKeyErrorPage is used when an encrypted file is shared to a user who doesn't have a key
+	// pair yet.
+	NoPublicKeyErrorPage = ErrorPageContent{
+		StatusCode:  fasthttp.StatusConflict,
+		MainMessage: "Cannot share the file",
+		Description: "The user cannot receive encrypted files until they have used their master password once.",
+	}
+
 	// ConflictErrorPage is used when the user tries to create information into the database that
 	// already exists.
 	ConflictErrorPage = ErrorPageContent{
@@ -2,6 +2,8 @@ package lib
 
 import (
 	"encoding/json"
+	"net/http"
+
 	"github.com/valyala/fasthttp"
 )
 
@@ -15,3 +17,16 @@ func WriteResponseJSON(ctx *fasthttp.RequestCtx, code int, obj interface{}) {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 	}
 }
+
+// WriteJSON is the net/http version of WriteResponseJSON. It sets the status code and the content type
+// of the response and encodes the data from the interface into json format.
+func WriteJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+// WriteErrorJSON writes the error content as a json error response with the content's status code.
+func WriteErrorJSON(w http.ResponseWriter, content ErrorPageContent) {
+	WriteJSON(w, content.StatusCode, JSON{"error": content})
+}
@@ -7,7 +7,8 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
-var jwtKey = []byte("something_very_secret")
+// TokenLifetime is the time a token, and the session it belongs to, is valid for.
+const TokenLifetime = time.Hour * 24
 
 // C is a simple struct to keep the username and the standard claims of a jwt, such as the expiration time
 type C struct {
@@ -15,10 +16,11 @@ type C struct {
 	jwt.StandardClaims
 }
 
-// CreateToken creates a jwt token which stores a username and is valid for 24 hours.
-func CreateToken(username string) (string, error) {
+// CreateToken creates a jwt token which stores a username and the token id of the user's session, and
+// is valid for 24 hours.
+func CreateToken(username, tokenID string) (string, error) {
 	// Set the expiration time of the token to be 24 hours.
-	expirationTime := time.Now().Add(time.Hour * 24)
+	expirationTime := time.Now().Add(TokenLifetime)
 
 	// Construct the jsonwebtoken claims
 	claims := &C{
@@ -26,14 +28,22 @@ func CreateToken(username string) (string, error) {
 		StandardClaims: jwt.StandardClaims{
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: expirationTime.Unix(),
+			Id:        tokenID,
 		},
 	}
 
-	// Declare the token with the algorithm used for signing, and the claims
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	// Declare the token with the algorithm used for signing, and the claims. The id of the key is stored
+	// in the header, so that the key can be found when validating the token.
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.ID
 
 	// Create the JWT string
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := token.SignedString(key.Secret)
 	if err != nil {
 		return "", err
 	}
@@ -44,23 +54,46 @@ func CreateToken(username string) (string, error) {
 // ValidateToken takes a token as an argument and checks if that token is valid.
 // If the token is valid, then the function returns the usernanem stored in the token.
 func ValidateToken(tokenString string) (string, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	// Return the username stored in the token.
+	return claims.Username, nil
+}
+
+// ParseToken checks if the token is valid and returns the claims stored in the token. The token id of
+// the session is in the Id field.
+func ParseToken(tokenString string) (*C, error) {
 	claims := &C{}
 
 	tkn, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
+		// Only accept the algorithm the tokens are signed with.
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+
+		// Find the key using the id in the header. The current key and the previous keys are accepted.
+		kid, _ := token.Header["kid"].(string)
+		key, ok := findSigningKey(kid)
+		if !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+
+		return key.Secret, nil
 	})
 
 	// Check for different errors with the token.
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
-			return "", errors.New("Unauthorized")
+			return nil, errors.New("Unauthorized")
 		}
-		return "", errors.New("bad request")
+		return nil, errors.New("bad request")
 	}
 	if !tkn.Valid {
-		return "", errors.New("token is invalid")
+		return nil, errors.New("token is invalid")
 	}
 
-	// Return the username stored in the token.
-	return claims.Username, nil
+	return claims, nil
 }
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MinSecretLength is the minimum length of a jwt signing secret.
+const MinSecretLength = 32
+
+// weakSecrets contains secrets which have been used as examples or defaults, and which therefore
+// cannot be used.
+var weakSecrets = []string{
+	"something_very_secret",
+	"secret",
+	"changeme",
+}
+
+// SigningKey is a secret used to sign and verify jwt tokens. The id is stored in the 'kid' header of the
+// tokens, so that the correct key can be found when verifying a token.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+var (
+	keysMu sync.RWMutex
+	// signingKeys contains the current key, which signs the new tokens, followed by the previous keys,
+	// which are only used to verify tokens signed before rotating the keys.
+	signingKeys []SigningKey
+)
+
+// SetSigningKeys replaces the jwt signing keys. The first key is used to sign new tokens, and the rest
+// are accepted when verifying tokens, so that the key can be rotated without logging everyone out.
+func SetSigningKeys(keys []SigningKey) error {
+	if len(keys) == 0 {
+		return errors.New("jwt: no signing keys configured")
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.ID == "" {
+			return errors.New("jwt: a signing key is missing its id")
+		}
+
+		if seen[key.ID] {
+			return fmt.Errorf("jwt: duplicate signing key id %q", key.ID)
+		}
+		seen[key.ID] = true
+
+		if err := checkSecret(key.Secret); err != nil {
+			return fmt.Errorf("jwt: signing key %q: %s", key.ID, err)
+		}
+	}
+
+	keysMu.Lock()
+	signingKeys = append([]SigningKey(nil), keys...)
+	keysMu.Unlock()
+
+	return nil
+}
+
+// LoadSigningKeys loads the jwt signing keys from the 'jwt_key_file' file or the 'jwt_keys' environment
+// variable. The keys are given as 'id:secret' pairs, separated by commas in the environment variable and
+// by lines in the file. The first key is the current key. Missing or weak keys are an error.
+func LoadSigningKeys() error {
+	var definition string
+	if path := os.Getenv("jwt_key_file"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("jwt: could not read the key file: %s", err)
+		}
+		definition = string(content)
+	} else {
+		definition = os.Getenv("jwt_keys")
+	}
+
+	keys, err := ParseSigningKeys(definition)
+	if err != nil {
+		return err
+	}
+
+	return SetSigningKeys(keys)
+}
+
+// ParseSigningKeys parses 'id:secret' pairs, which are separated by commas or newlines. Empty lines and
+// lines starting with '#' are skipped.
+func ParseSigningKeys(definition string) ([]SigningKey, error) {
+	var keys []SigningKey
+	for _, line := range strings.FieldsFunc(definition, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("jwt: signing keys need to be given as id:secret")
+		}
+
+		keys = append(keys, SigningKey{
+			ID:     strings.TrimSpace(parts[0]),
+			Secret: []byte(strings.TrimSpace(parts[1])),
+		})
+	}
+
+	return keys, nil
+}
+
+// checkSecret refuses secrets which are too short or well known.
+func checkSecret(secret []byte) error {
+	for _, weak := range weakSecrets {
+		if string(secret) == weak {
+			return errors.New("the secret is a well known default")
+		}
+	}
+
+	if len(secret) < MinSecretLength {
+		return fmt.Errorf("the secret needs to be at least %d characters", MinSecretLength)
+	}
+
+	return nil
+}
+
+// currentSigningKey returns the key used to sign new tokens.
+func currentSigningKey() (SigningKey, error) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	if len(signingKeys) == 0 {
+		return SigningKey{}, errors.New("jwt: no signing keys configured")
+	}
+
+	return signingKeys[0], nil
+}
+
+// findSigningKey returns the key with the given id.
+func findSigningKey(id string) (SigningKey, bool) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	for _, key := range signingKeys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+
+	return SigningKey{}, false
+}
@@ -1,10 +1,22 @@
 package lib
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	currentKey  = SigningKey{ID: "current", Secret: []byte(strings.Repeat("c", MinSecretLength))}
+	previousKey = SigningKey{ID: "previous", Secret: []byte(strings.Repeat("p", MinSecretLength))}
+)
 
 func TestJWTToken(t *testing.T) {
+	if err := SetSigningKeys([]SigningKey{currentKey}); err != nil {
+		t.Fatal(err)
+	}
+
 	username := "user"
-	token, err := CreateToken(username)
+	token, err := CreateToken(username, "session")
 	if err != nil {
 		t.Error("Could not create a token, err: ", err.Error())
 		return
@@ -20,4 +32,81 @@ func TestJWTToken(t *testing.T) {
 		t.Error("The username from the jwt token doesn't match the correct username.")
 		return
 	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Error("Could not parse token, err: ", err.Error())
+		return
+	}
+
+	if claims.Id != "session" {
+		t.Error("The token id from the jwt token doesn't match the session's token id.")
+		return
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	if err := SetSigningKeys([]SigningKey{previousKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := CreateToken("user", "session")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The token signed with the previous key is still valid after rotating the keys.
+	if err := SetSigningKeys([]SigningKey{currentKey, previousKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateToken(token); err != nil {
+		t.Error("A token signed with the previous key was not accepted, err: ", err.Error())
+		return
+	}
+
+	// Once the previous key is removed, the token is not valid anymore.
+	if err := SetSigningKeys([]SigningKey{currentKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("A token signed with a removed key was accepted.")
+		return
+	}
+}
+
+func TestWeakSigningKeys(t *testing.T) {
+	invalid := [][]SigningKey{
+		nil,
+		{{ID: "key", Secret: []byte("something_very_secret")}},
+		{{ID: "key", Secret: []byte("too short")}},
+		{{ID: "", Secret: currentKey.Secret}},
+		{currentKey, currentKey},
+	}
+
+	for _, keys := range invalid {
+		if err := SetSigningKeys(keys); err == nil {
+			t.Errorf("The signing keys %v were accepted.", keys)
+		}
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	keys, err := ParseSigningKeys("# the current key\nnew: " + string(currentKey.Secret) + "\n\nold:a:b,")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(keys) != 2 || keys[0].ID != "new" || string(keys[0].Secret) != string(currentKey.Secret) ||
+		keys[1].ID != "old" || string(keys[1].Secret) != "a:b" {
+		t.Errorf("The keys were parsed incorrectly: %v", keys)
+		return
+	}
+
+	if _, err := ParseSigningKeys("no separator"); err == nil {
+		t.Error("A key without an id was accepted.")
+	}
 }
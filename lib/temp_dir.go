@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ClearTempDir removes everything inside the 'temp' directory. Older versions decrypted the downloaded
+// files into the directory, and the plaintext copies were left there if a download failed. The files
+// are now decrypted straight into the response, so nothing in the directory is needed.
+func ClearTempDir() error {
+	return clearDir(AddRootToPath("temp"))
+}
+
+// clearDir removes all of the entries in the directory, but leaves the directory itself. A directory
+// which doesn't exist is left as it is.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
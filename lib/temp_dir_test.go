@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClearDir(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "left.txt"), []byte("plaintext"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "nested", "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := clearDir(dir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("expected the directory to be empty, got %d entries", len(entries))
+		return
+	}
+
+	if err := clearDir(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("clearing a missing directory should not fail, got: %v", err)
+	}
+}
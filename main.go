@@ -3,7 +3,10 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
 	"github.com/nireo/upfi/web"
 
 	"github.com/joho/godotenv"
@@ -32,9 +35,67 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Load the keys which are used to sign the authentication tokens. The service refuses to start
+	// without proper keys.
+	if err := lib.LoadSigningKeys(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Remove the plaintext files which older versions could leave behind in the temp directory.
+	if err := lib.ClearTempDir(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Setup the storage backend in which the file contents are stored. By default the files are stored
+	// on the local disk, but they can also be stored in a S3 compatible object storage.
+	backend, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	storage.SetBackend(backend)
+
+	// Read the maximum upload size, which is enforced while the uploads are streamed into the storage.
+	if err := web.LoadUploadLimit(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the default storage quota and the users who are allowed to change the quotas of the others.
+	if err := models.LoadDefaultQuota(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := models.LoadAdmins(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Read how many days the files are kept in the trash before they are purged.
+	if err := models.LoadTrashDays(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Remove the file versions which are older than their owners want to keep them, the files which
+	// have been in the trash for too long, the expired shares, the abandoned uploads and the contents of the
+	// removed blobs once a day.
+	go runDaily(models.PruneExpiredVersions)
+	go runDaily(models.PurgeTrash)
+	go runDaily(models.DeleteExpiredShares)
+	go runDaily(models.DeleteStaleUploads)
+	go runDaily(models.CollectOrphanBlobs)
+
 	// Use the optimized version of the api, which uses the fasthttp package to improve performance
 	// Is its own function, since before there was a older implementation which used net/http.
 	serverPort := os.Getenv("port")
 
 	web.StartServer(serverPort)
 }
+
+// runDaily runs the job right away and then once a day. The errors are logged, since the job is tried
+// again the next day.
+func runDaily(job func() error) {
+	for {
+		if err := job(); err != nil {
+			log.Println(err)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
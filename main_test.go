@@ -3,9 +3,12 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/nireo/upfi/lib"
 	"github.com/nireo/upfi/middleware"
+	"github.com/nireo/upfi/storage"
 
 	"github.com/joho/godotenv"
 	"github.com/nireo/upfi/models"
@@ -34,6 +37,14 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
+	// Keep the file contents in memory, so that the tests don't leave files on the disk.
+	storage.SetBackend(storage.NewMemory())
+
+	// Sign the test tokens with a fixed key.
+	if err := lib.SetSigningKeys([]lib.SigningKey{{ID: "test", Secret: []byte(strings.Repeat("k", lib.MinSecretLength))}}); err != nil {
+		log.Fatal(err)
+	}
+
 	// Disable http logging
 	middleware.SetHTTPLogging(false)
 
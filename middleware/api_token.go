@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"gorm.io/gorm"
+)
+
+// CheckAPIToken authenticates the json api requests. Non-browser clients send a personal api token as
+// 'Authorization: Bearer <token>', and the token needs to have the given scope. Other requests are
+// authenticated with the token cookie and the session like in CheckToken. Unauthorized requests get a json error.
+func CheckAPIToken(scope string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// If an api token is given, only the api token is checked.
+		if raw, ok := bearerToken(r); ok {
+			token, err := models.FindAPIToken(raw)
+			if err != nil {
+				lib.WriteErrorJSON(w, *lib.CreateSimpleErrorContent(http.StatusUnauthorized))
+				return
+			}
+
+			if !token.HasScope(scope) {
+				lib.WriteErrorJSON(w, lib.ForbiddenErrorPage)
+				return
+			}
+
+			user, err := models.FindOneUser(&models.User{Model: gorm.Model{ID: token.UserID}})
+			if err != nil {
+				lib.WriteErrorJSON(w, *lib.CreateSimpleErrorContent(http.StatusUnauthorized))
+				return
+			}
+
+			token.MarkUsed()
+			r.Header.Set("username", user.Username)
+			r.Header.Del("session")
+			next(w, r, ps)
+			return
+		}
+
+		user, session, err := authenticateSession(r)
+		if err != nil {
+			lib.WriteErrorJSON(w, *lib.CreateSimpleErrorContent(http.StatusUnauthorized))
+			return
+		}
+
+		r.Header.Set("username", user.Username)
+		r.Header.Set("session", session.TokenID)
+		next(w, r, ps)
+	}
+}
+
+// SkipCSRFForAPITokens lets the api requests, which are authenticated with an api token, through the
+// csrf protection. Browsers never send the Authorization header on their own, so these requests cannot
+// be forged. This needs to wrap the csrf middleware.
+func SkipCSRFForAPITokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerToken(r); ok && strings.HasPrefix(r.URL.Path, "/api/") {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token from the 'Authorization: Bearer <token>' header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len(prefix):]), true
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer  abc ", "abc", true},
+		{"Bearer ", "", false},
+		{"Basic abc", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/files", nil)
+		r.Header.Set("Authorization", test.header)
+
+		if token, ok := bearerToken(r); token != test.token || ok != test.ok {
+			t.Errorf("wrong token for %q. want=(%q, %t), got=(%q, %t)", test.header, test.token, test.ok, token, ok)
+		}
+	}
+}
+
+func TestCheckAPITokenWithoutSession(t *testing.T) {
+	handler := CheckAPIToken(models.ScopeRead, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		t.Error("the handler should not be called without authentication")
+	})
+
+	withoutCookie := httptest.NewRequest(http.MethodGet, "/api/v1/files", nil)
+	// The username given by the client must not be trusted.
+	withoutCookie.Header.Set("username", "admin")
+
+	invalidCookie := httptest.NewRequest(http.MethodGet, "/api/v1/files", nil)
+	invalidCookie.AddCookie(&http.Cookie{Name: "token", Value: "not a token"})
+
+	for _, r := range []*http.Request{withoutCookie, invalidCookie} {
+		rec := httptest.NewRecorder()
+		handler(rec, r, nil)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("wrong status code. want=401, got=%d", rec.Code)
+		}
+
+		var body struct {
+			Error struct {
+				Status int `json:"status"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Status != http.StatusUnauthorized {
+			t.Errorf("wrong json error. status=%d, err: %v", body.Error.Status, err)
+		}
+	}
+}
+
+// checkAPIToken sends a request with the api token to a handler, which needs the scope. The username
+// given to the handler is returned, empty if the handler wasn't called.
+func checkAPIToken(raw, scope string) (*httptest.ResponseRecorder, string) {
+	var username string
+	handler := CheckAPIToken(scope, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		username = r.Header.Get("username")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/files", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+	// The username given by the client must not be trusted.
+	r.Header.Set("username", "admin")
+
+	rec := httptest.NewRecorder()
+	handler(rec, r, nil)
+	return rec, username
+}
+
+func TestCheckAPITokenScopes(t *testing.T) {
+	user := newTestUser(t)
+
+	raw, token, err := models.CreateAPIToken(user, "reader", []string{models.ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, username := checkAPIToken(raw, models.ScopeWrite); rec.Code != http.StatusForbidden || username != "" {
+		t.Errorf("the token should not have the write scope. status=%d", rec.Code)
+	}
+
+	rec, username := checkAPIToken(raw, models.ScopeRead)
+	if rec.Code != http.StatusNoContent || username != user.Username {
+		t.Fatalf("the token should be accepted. status=%d, username=%q", rec.Code, username)
+	}
+
+	var used models.APIToken
+	if err := lib.GetDatabase().First(&used, token.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if used.LastUsedAt == nil || time.Since(*used.LastUsedAt) > time.Minute {
+		t.Errorf("the use of the token should have been recorded, got: %v", used.LastUsedAt)
+	}
+
+	// The revoked tokens are refused.
+	if err := user.RevokeAPIToken(token.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, username := checkAPIToken(raw, models.ScopeRead); rec.Code != http.StatusUnauthorized || username != "" {
+		t.Errorf("the revoked token should be refused. status=%d", rec.Code)
+	}
+}
+
+func TestCheckAPITokenExpired(t *testing.T) {
+	user := newTestUser(t)
+
+	expired := time.Now().Add(-time.Minute)
+	raw, _, err := models.CreateAPIToken(user, "expired", []string{models.ScopeRead}, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, username := checkAPIToken(raw, models.ScopeRead); rec.Code != http.StatusUnauthorized || username != "" {
+		t.Errorf("the expired token should be refused. status=%d", rec.Code)
+	}
+
+	if rec, _ := checkAPIToken("upfi_unknown", models.ScopeRead); rec.Code != http.StatusUnauthorized {
+		t.Errorf("an unknown token should be refused. status=%d", rec.Code)
+	}
+}
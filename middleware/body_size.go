@@ -0,0 +1,16 @@
+package middleware
+
+import "net/http"
+
+// LimitBodySize limits the size of the request bodies to the given number of bytes. This needs to wrap
+// the csrf middleware, which reads the whole form into memory and temporary files when the token is not
+// given in a header.
+func LimitBodySize(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
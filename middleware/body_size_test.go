@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitBodySize(t *testing.T) {
+	var read string
+	var readErr error
+	handler := LimitBodySize(8, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		read, readErr = string(data), err
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("12345678")))
+	if readErr != nil || read != "12345678" {
+		t.Errorf("the body within the limit should be read. body=%q, err: %v", read, readErr)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("123456789")))
+	if readErr == nil {
+		t.Errorf("the body over the limit should not be read, got: %q", read)
+	}
+}
@@ -5,7 +5,9 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
 	"github.com/valyala/fasthttp"
+	"gorm.io/gorm"
 )
 
 // CheckAuthentication looks for a cookie, given by the /register or /login routes. And finds the username
@@ -30,38 +32,59 @@ func CheckAuthentication(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 	}
 }
 
-// CheckAuthentication looks for a cookie, given by the /register or /login routes. And finds the username
-// in that jwt token.
+// CheckToken looks for a cookie, given by the /register or /login routes. And finds the username
+// in that jwt token. The token is accepted only if the session it belongs to hasn't been revoked.
 func CheckToken(next httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
 		w.Header().Set("X-Frame-Options", "deny")
-		// Take the cookie named token from the request headers.
-		cookie, err := r.Cookie("token")
+
+		user, session, err := authenticateSession(r)
 		if err != nil {
 			http.Error(w, "", http.StatusUnauthorized)
 			return
 		}
 
-		// Use a function from the utils that verifies the integrity of a token and returns the
-		// username in that token.
-		username, err := lib.ValidateToken(cookie.Value)
-		if err == nil {
-			// If there was no error, the token is valid and we can move on to the authenticated http handler.
-			r.Header.Add("username", username)
-			next(w, r, httprouter.Params{})
-			return
-		}
+		// If there was no error, the token is valid and we can move on to the authenticated http handler.
+		// The headers are set rather than added, so that the client cannot supply its own values.
+		r.Header.Set("username", user.Username)
+		r.Header.Set("session", session.TokenID)
+		next(w, r, ps)
+	}
+}
 
-		http.Error(w, "", http.StatusUnauthorized)
-		return
+// authenticateSession takes the cookie named token from the request, verifies the integrity of the
+// token and finds the session the token belongs to. The username is read from the session's user
+// rather than the token, so that changing the username doesn't end the sessions.
+func authenticateSession(r *http.Request) (*models.User, *models.Session, error) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return nil, nil, err
 	}
+
+	claims, err := lib.ParseToken(cookie.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := models.FindSession(claims.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := models.FindOneUser(&models.User{Model: gorm.Model{ID: session.UserID}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, session, nil
 }
 
 // SecureHeaders adds some common headers for some security things.
 func SecureHeaders(next httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
 		w.Header().Set("X-Frame-Options", "deny")
+		next(w, r, ps)
 	}
 }
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+)
+
+// checkToken sends a request with the token cookie to a handler behind CheckToken. The username given to
+// the handler is returned, empty if the handler wasn't called.
+func checkToken(token string) (*httptest.ResponseRecorder, string) {
+	var username string
+	handler := CheckToken(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		username = r.Header.Get("username")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/files", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: token})
+
+	rec := httptest.NewRecorder()
+	handler(rec, r, nil)
+	return rec, username
+}
+
+func TestCheckTokenRejectsRevokedSessions(t *testing.T) {
+	user := newTestUser(t)
+
+	session, err := models.CreateSession(user, "test agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := lib.CreateToken(user.Username, session.TokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, username := checkToken(token); rec.Code != http.StatusNoContent || username != user.Username {
+		t.Fatalf("the token should be accepted. status=%d, username=%q", rec.Code, username)
+	}
+
+	if err := models.RevokeSession(session.TokenID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token itself is still valid, but its session has been revoked.
+	if rec, username := checkToken(token); rec.Code != http.StatusUnauthorized || username != "" {
+		t.Errorf("the token of a revoked session should be refused. status=%d", rec.Code)
+	}
+
+	// A token with a made up session id is refused as well.
+	forged, err := lib.CreateToken(user.Username, lib.GenerateUUID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, _ := checkToken(forged); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the token without a session should be refused. status=%d", rec.Code)
+	}
+}
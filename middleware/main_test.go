@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+)
+
+// hasDatabase tells if the test database has been configured. The tests which need the database are
+// skipped without it.
+var hasDatabase bool
+
+// TestMain connects to the test database, if it's configured in the environment or in the .env file at
+// the root of the repository.
+func TestMain(m *testing.M) {
+	godotenv.Load("../.env")
+
+	if os.Getenv("db_host") != "" {
+		if err := models.ConnectToDatabase(&models.DatabaseConfig{
+			User: os.Getenv("db_username"),
+			Port: os.Getenv("db_port"),
+			Host: os.Getenv("db_host"),
+			Name: os.Getenv("db_name"),
+		}); err != nil {
+			log.Fatal(err)
+		}
+		hasDatabase = true
+	}
+
+	// Sign the test tokens with a fixed key.
+	if err := lib.SetSigningKeys([]lib.SigningKey{{ID: "test", Secret: []byte(strings.Repeat("k", lib.MinSecretLength))}}); err != nil {
+		log.Fatal(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// requireDatabase skips the test if the test database has not been configured.
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	if !hasDatabase {
+		t.Skip("the test database is not configured")
+	}
+}
+
+// newTestUser creates a user with a unique username, which is removed after the test.
+func newTestUser(t *testing.T) *models.User {
+	t.Helper()
+	requireDatabase(t)
+
+	user := &models.User{Username: "test-" + lib.GenerateUUID()[:8], UUID: lib.GenerateUUID()}
+	if err := lib.GetDatabase().Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := user.Delete(); err != nil {
+			t.Error("could not remove the test user, err: ", err)
+		}
+	})
+
+	return user
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"os"
+	"strings"
+
+	"github.com/nireo/upfi/lib"
+)
+
+// LoadAdmins gives the admin rights to the users listed in the 'admin_users' environment variable, which
+// contains comma separated usernames. The rights are taken away from the users who are not listed.
+func LoadAdmins() error {
+	var usernames []string
+	for _, username := range strings.Split(os.Getenv("admin_users"), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+
+	db := lib.GetDatabase()
+	if len(usernames) == 0 {
+		return db.Model(&User{}).Where("admin = ?", true).Update("admin", false).Error
+	}
+
+	if err := db.Model(&User{}).Where("admin = ? AND username NOT IN ?", true, usernames).
+		Update("admin", false).Error; err != nil {
+		return err
+	}
+
+	return db.Model(&User{}).Where("username IN ?", usernames).Update("admin", true).Error
+}
+
+// FindUsers returns all of the users ordered by their username.
+func FindUsers() ([]User, error) {
+	db := lib.GetDatabase()
+
+	var users []User
+	if err := db.Order("username").Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// The scopes an api token can be given.
+const (
+	ScopeRead  = "read"  // Listing, viewing and downloading files.
+	ScopeWrite = "write" // Uploading, updating and deleting files and changing the account.
+	ScopeShare = "share" // Sharing files and removing shares.
+)
+
+// Scopes contains all of the valid scopes.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeShare}
+
+// tokenPrefix is added to the start of every api token, so that the tokens are easy to recognize.
+const tokenPrefix = "upfi_"
+
+// ErrTokenExpired is returned when an api token has expired.
+var ErrTokenExpired = errors.New("the token has expired")
+
+// APIToken is a personal access token, which non-browser clients use to access the api by sending it
+// in the Authorization header. Only the sha-256 hash of the token is stored, so the token itself is
+// shown to the user only once.
+type APIToken struct {
+	gorm.Model
+	UserID     uint
+	Name       string
+	TokenHash  string `gorm:"uniqueIndex"`
+	Prefix     string // The start of the token, so that the user can tell the tokens apart.
+	Scopes     string // The scopes as a comma separated list.
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// ValidScope checks if the scope is one of the scopes an api token can have.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScope checks if the token has been given the scope.
+func (token *APIToken) HasScope(scope string) bool {
+	for _, s := range strings.Split(token.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired checks if the token has an expiration time, which has passed.
+func (token *APIToken) Expired() bool {
+	return token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt)
+}
+
+// CreateAPIToken creates a new api token for the user. The returned string is the token itself, which
+// cannot be recovered later.
+func CreateAPIToken(user *User, name string, scopes []string, expiresAt *time.Time) (string, *APIToken, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	raw := tokenPrefix + hex.EncodeToString(random)
+
+	token := &APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: hashAPIToken(raw),
+		Prefix:    raw[:len(tokenPrefix)+8],
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := lib.GetDatabase().Create(token).Error; err != nil {
+		return "", nil, err
+	}
+
+	return raw, token, nil
+}
+
+// FindAPIToken finds the api token matching the raw token, which the client has sent. Expired tokens
+// return ErrTokenExpired.
+func FindAPIToken(raw string) (*APIToken, error) {
+	db := lib.GetDatabase()
+
+	var token APIToken
+	if err := db.Where(&APIToken{TokenHash: hashAPIToken(raw)}).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	if token.Expired() {
+		return nil, ErrTokenExpired
+	}
+
+	return &token, nil
+}
+
+// MarkUsed records the current time as the time the token was last used.
+func (token *APIToken) MarkUsed() error {
+	now := time.Now()
+	token.LastUsedAt = &now
+
+	return lib.GetDatabase().Model(token).Update("last_used_at", now).Error
+}
+
+// FindAPITokens returns all of the user's api tokens.
+func (user *User) FindAPITokens() ([]APIToken, error) {
+	db := lib.GetDatabase()
+
+	var tokens []APIToken
+	if err := db.Where(&APIToken{UserID: user.ID}).Order("created_at desc").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RevokeAPIToken removes one of the user's api tokens for good.
+func (user *User) RevokeAPIToken(id uint) error {
+	db := lib.GetDatabase()
+
+	result := db.Unscoped().Where("id = ? AND user_id = ?", id, user.ID).Delete(&APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// hashAPIToken hashes the token using sha-256. The tokens are long random strings, so a slow password
+// hash is not needed, and a plain hash lets the token be looked up directly.
+func hashAPIToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"path"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// blobPrefix is the prefix of the keys under which the blobs are stored.
+const blobPrefix = "blobs/"
+
+// orphanBlobAge is how old an object under the blob prefix needs to be before it's removed when it
+// doesn't have a database entry. Younger objects can belong to uploads which are still in progress.
+const orphanBlobAge = time.Hour
+
+// Blob is the content of unencrypted files stored once for all of the files with the same contents. The
+// blobs are addressed by the SHA-256 hash of the contents, and they keep count of the files which use
+// them. Once the last file is removed, the blob is removed as well. The rows are removed for good, since
+// a new blob with the same hash can be created later.
+type Blob struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Hash      string `gorm:"uniqueIndex"`
+	Size      int64
+	RefCount  int
+}
+
+// BlobKey returns the key under which the blob with the given hex encoded hash is stored.
+func BlobKey(hash string) string {
+	return blobPrefix + hash[:2] + "/" + hash
+}
+
+// CreateBlobFile creates the database entry of an unencrypted file, whose contents have been stored under
+// the staged key and whose hash has been set. If a blob with the same hash already exists, the file
+// uses it and the staged contents are removed. Otherwise the staged contents are moved to become the
+// new blob. The size of the file is added to the owner's used bytes, and ErrQuotaExceeded is returned if
+// it doesn't fit.
+func CreateBlobFile(file *File, stagedKey string) error {
+	backend := storage.GetBackend()
+	reused := false
+
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if err := chargeQuota(tx, file.UserID, file.Size); err != nil {
+			return err
+		}
+
+		blob, blobReused, err := acquireBlob(tx, file.Hash, file.Size, stagedKey)
+		if err != nil {
+			return err
+		}
+		reused = blobReused
+
+		file.BlobID = &blob.ID
+		return tx.Create(file).Error
+	})
+	if err != nil {
+		file.BlobID = nil
+		return err
+	}
+
+	if reused {
+		backend.Delete(stagedKey)
+	}
+
+	return nil
+}
+
+// acquireBlob adds a reference to the blob with the given hash. If the blob doesn't exist yet, it's
+// created and the staged contents are moved to become its contents. Otherwise reused is true, and the
+// staged contents should be removed after the transaction has been committed.
+func acquireBlob(tx *gorm.DB, hash string, size int64, stagedKey string) (blob *Blob, reused bool, err error) {
+	if err := lockBlobHash(tx, hash); err != nil {
+		return nil, false, err
+	}
+
+	// Either creates the blob or adds a reference to the existing one. If the blob is being released
+	// at the same time, this waits until the release has finished.
+	blob = &Blob{Hash: hash, Size: size, RefCount: 1}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("blobs.ref_count + 1")}),
+	}).Create(blob).Error; err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.First(blob, blob.ID).Error; err != nil {
+		return nil, false, err
+	}
+
+	// The contents are moved while the blob is still locked, so that the other files don't use the
+	// blob before its contents exist.
+	if blob.RefCount > 1 {
+		return blob, true, nil
+	}
+
+	if err := storage.Move(storage.GetBackend(), stagedKey, BlobKey(hash)); err != nil {
+		return nil, false, err
+	}
+
+	return blob, false, nil
+}
+
+// lockBlobHash takes a lock on the hash until the end of the transaction. A row lock cannot be used,
+// since the blob row might not exist yet, so an advisory lock keyed by the hash is used instead. This
+// keeps CollectOrphanBlobs from removing the contents of a blob which is being created.
+func lockBlobHash(tx *gorm.DB, hash string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", hash).Error
+}
+
+// releaseBlob removes a reference to the blob. If it was the last reference, the blob is removed. Its
+// contents are left for CollectOrphanBlobs, so that they are not removed before the transaction has been
+// committed.
+func releaseBlob(tx *gorm.DB, blobID uint) error {
+	var blob Blob
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&blob, blobID).Error; err != nil {
+		return err
+	}
+
+	if blob.RefCount > 1 {
+		return tx.Model(&blob).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	}
+
+	return tx.Delete(&blob).Error
+}
+
+// CollectOrphanBlobs removes the objects under the blob prefix which don't have a database entry. These
+// are left behind when storing a new file fails after its contents have been moved into a blob, and when
+// the last file using a blob is removed.
+func CollectOrphanBlobs() error {
+	backend := storage.GetBackend()
+
+	objects, err := backend.List(blobPrefix)
+	if err != nil {
+		return err
+	}
+
+	db := lib.GetDatabase()
+	for _, obj := range objects {
+		if time.Since(obj.ModTime) < orphanBlobAge {
+			continue
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return deleteOrphanBlob(tx, obj.Key)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteOrphanBlob removes the object of a blob, if the blob doesn't have a database entry. The hash is
+// locked first, so that the entry cannot be created again between the check and the removal.
+func deleteOrphanBlob(tx *gorm.DB, key string) error {
+	hash := path.Base(key)
+	if err := lockBlobHash(tx, hash); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := tx.Model(&Blob{}).Where("hash = ?", hash).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	return storage.GetBackend().Delete(key)
+}
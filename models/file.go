@@ -1,9 +1,12 @@
 package models
 
 import (
-	"os"
+	"errors"
+	"log"
+	"time"
 
 	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
 	"gorm.io/gorm"
 )
 
@@ -16,19 +19,51 @@ type File struct {
 	Size        int64  `json:"size"`
 	SizeHuman   string
 	UserID      uint
+	FolderID    *uint  `gorm:"index"` // nil if the file is at the top level
 	Extension   string `json:"extension"`
 	MIME        string `json:"mime"`
 
+	// Hash is the hex encoded SHA-256 hash of the file's contents. It's used as the ETag when the
+	// file is downloaded. Files uploaded before the hash was stored don't have it. The contents of the
+	// encrypted files are hashed with an HMAC keyed by their data key instead, which is marked with
+	// KeyedHashPrefix, so that the hash cannot be used to confirm a guess of the contents.
+	Hash string `json:"hash"`
+
 	// If this is enabled, the user cannot encrypt the file.
 	ShareableFile bool `json:"shared"`
+
+	// BlobID is the blob which holds the contents of an unencrypted file. The files uploaded before the
+	// blobs were added and the encrypted files have their own copy of the contents.
+	BlobID *uint `gorm:"index"`
+
+	// Trashed files have been moved into the trash, and they are soft deleted until they are restored
+	// or removed for good.
+	Trashed bool
+
+	// ContentKey is the key of the encrypted contents, after they have been replaced by a new version.
+	// The original contents are stored under the file's uuid.
+	ContentKey string
 }
 
+// KeyedHashPrefix is the prefix of the hashes of the encrypted contents, which are keyed with the data
+// key of the file.
+const KeyedHashPrefix = "hmac-sha256:"
+
 // FileShare represents a file share record
 type FileShare struct {
 	gorm.Model
 	SharedByID   uint // the sharer's primary key
 	SharedToID   uint // the primary key of the shared user
 	SharedFileID uint
+
+	// Permission is the permission level of the recipient, and ExpiresAt is the time after which the
+	// share cannot be used anymore. Shares without an expiration time last until they are removed.
+	Permission string     `gorm:"default:download"`
+	ExpiresAt  *time.Time `gorm:"index"`
+
+	// WrappedKey is the data key of an encrypted file sealed to the recipient's public key. The shares
+	// created before the key pairs existed don't have it, so they need the owner's master password.
+	WrappedKey []byte
 }
 
 func (file *File) IsSharedTo(userID uint) bool {
@@ -52,28 +87,139 @@ func (file *File) ShareToUser(shareTo *User) {
 	db.Create(fileShare)
 }
 
-// Serialize serializes the user's data into json format
+// Serialize serializes the file's data into json format. The hash is only included for the unencrypted
+// files, since the hashes of the encrypted files are only meaningful with their data key.
 func (file *File) Serialize() lib.JSON {
-	return lib.JSON{
+	serialized := lib.JSON{
 		"filename":    file.Filename,
 		"created_at":  file.CreatedAt,
+		"updated_at":  file.UpdatedAt,
 		"description": file.Description,
 		"uuid":        file.UUID,
+		"size":        file.Size,
+		"extension":   file.Extension,
+		"mime":        file.MIME,
+		"encrypted":   !file.ShareableFile,
 	}
+
+	if file.ShareableFile {
+		serialized["hash"] = file.Hash
+	}
+
+	return serialized
 }
 
-// Delete removes a given file and it's database entry
-func (file *File) Delete(userID string) error {
-	db := lib.GetDatabase()
+// StorageKey returns the key under which the file's contents are stored in the storage backend. Even
+// though we encrypt the file, we still want to keep the extension, since windows for example does not
+// work without proper file types. The files which use a blob are stored under the blob's key.
+func (file *File) StorageKey(ownerUUID string) string {
+	if file.BlobID != nil {
+		return BlobKey(file.Hash)
+	}
+
+	if file.ContentKey != "" {
+		return file.ContentKey
+	}
 
-	if err := os.Remove(lib.AddRootToPath("files/") + userID + "/" + file.Filename); err != nil {
+	return ownerUUID + "/" + file.UUID + file.Extension
+}
+
+// ETag returns the entity tag of the file's contents, or an empty string if the file doesn't have a
+// hash.
+func (file *File) ETag() string {
+	if file.Hash == "" {
+		return ""
+	}
+
+	return `"` + file.Hash + `"`
+}
+
+// Delete removes a given file, it's versions and database entry for good. The size of the file and the
+// versions is removed from the owner's used bytes.
+func (file *File) Delete(ownerUUID string) error {
+	var keys []string
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		var err error
+		keys, err = deleteFile(tx, file, ownerUUID)
+		return err
+	})
+	if err != nil {
 		return err
 	}
 
-	db.Delete(&file)
+	deleteObjects(keys)
 	return nil
 }
 
+// deleteFile removes the file's database entries. The keys of the contents, which only the file uses,
+// are returned, so that they can be removed once the transaction has been committed. If the transaction
+// fails, the file is left as it was.
+func deleteFile(tx *gorm.DB, file *File, ownerUUID string) ([]string, error) {
+	var versions []FileVersion
+	if err := tx.Where(&FileVersion{FileID: file.ID}).Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	keys, err := deleteVersions(tx, file.UserID, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	// The blob is only removed if no other file uses it, which is checked in releaseBlob.
+	if file.BlobID != nil {
+		if err := releaseBlob(tx, *file.BlobID); err != nil {
+			return nil, err
+		}
+	} else {
+		keys = append(keys, file.StorageKey(ownerUUID))
+	}
+
+	return keys, deleteFileEntries(tx, file)
+}
+
+// deleteFileEntries removes the database entries of the file and the entries which refer to it, and
+// removes the size of the file from the owner's used bytes.
+func deleteFileEntries(tx *gorm.DB, file *File) error {
+	// The data key is not needed anymore, so remove it for good.
+	if err := tx.Unscoped().Where(&FileKey{FileID: file.ID}).Delete(&FileKey{}).Error; err != nil {
+		return err
+	}
+
+	// The file cannot be shared after it has been removed.
+	if err := tx.Where(&FileShare{SharedFileID: file.ID}).Delete(&FileShare{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Where(&ShareLink{FileID: file.ID}).Delete(&ShareLink{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Where("file_id = ?", file.ID).Delete(&GroupShare{}).Error; err != nil {
+		return err
+	}
+
+	if err := deleteFileTags(tx, file); err != nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Delete(file).Error; err != nil {
+		return err
+	}
+
+	return refundQuota(tx, file.UserID, file.Size)
+}
+
+// deleteObjects removes the contents stored under the keys. The database entries have already been
+// removed, so the failures are only logged.
+func deleteObjects(keys []string) {
+	backend := storage.GetBackend()
+	for _, key := range keys {
+		if err := backend.Delete(key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("removing %s: %v", key, err)
+		}
+	}
+}
+
 // FindOneFile takes a query interface{} as a parameter and returns a pointer to a file,
 // if it is found.
 func FindOneFile(condition interface{}) (*File, error) {
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// ErrContentChanged is returned when the contents of a file were replaced while they were being upgraded
+// to use a data key.
+var ErrContentChanged = errors.New("the contents of the file have changed")
+
+// FileKey holds the data key of an encrypted file. Every encrypted file has its own random data key,
+// which is wrapped with a key derived from the owner's master password. This way changing the master
+// password only requires wrapping the data keys again.
+type FileKey struct {
+	gorm.Model
+	FileID     uint `gorm:"uniqueIndex"`
+	WrappedKey []byte
+
+	// PendingWrappedKey holds the data key wrapped with the new master password, while the master
+	// password is being changed.
+	PendingWrappedKey []byte
+}
+
+// MasterKey derives the key encryption key, which is used to wrap the data keys, from the user's
+// master password. The key derivation parameters and salt are created the first time they are needed.
+func (user *User) MasterKey(master string) ([]byte, error) {
+	if len(user.KeyDerivation) == 0 {
+		derivation, err := crypt.NewKeyDerivation()
+		if err != nil {
+			return nil, err
+		}
+
+		db := lib.GetDatabase()
+		if err := db.Model(user).Update("key_derivation", derivation).Error; err != nil {
+			return nil, err
+		}
+		user.KeyDerivation = derivation
+	}
+
+	return crypt.DeriveKey(master, user.KeyDerivation)
+}
+
+// DataKey finds the wrapped data key of the file and unwraps it using the key encryption key. If the
+// key encryption key is wrong, crypt.ErrInvalidCiphertext is returned.
+func (file *File) DataKey(kek []byte) ([]byte, error) {
+	db := lib.GetDatabase()
+
+	var fileKey FileKey
+	if err := db.Where(&FileKey{FileID: file.ID}).First(&fileKey).Error; err != nil {
+		return nil, err
+	}
+
+	return crypt.UnwrapKey(kek, fileKey.WrappedKey)
+}
+
+// HasDataKey checks if the file has a wrapped data key. The files encrypted with the master password
+// itself don't have one until they are upgraded.
+func (file *File) HasDataKey() (bool, error) {
+	var count int64
+	err := lib.GetDatabase().Model(&FileKey{}).Where("file_id = ?", file.ID).Count(&count).Error
+	return count > 0, err
+}
+
+// UpgradeContent takes into use the contents of a file, which was encrypted with the master password,
+// after they have been encrypted with a new data key and stored under the content key. The data key is
+// wrapped using the key encryption key and stored in the same transaction, so a file never has a data
+// key without the contents encrypted with it. A pending key from a master password change is discarded,
+// since it wraps the earlier data key. ErrContentChanged is returned if the contents of the file were
+// replaced meanwhile, in which case nothing is changed.
+func (file *File) UpgradeContent(contentKey, hash string, kek, dataKey []byte) error {
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&File{}).Where("id = ? AND COALESCE(content_key, '') = ?", file.ID, file.ContentKey).
+			UpdateColumns(map[string]interface{}{"content_key": contentKey, "hash": hash})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrContentChanged
+		}
+
+		return setDataKey(tx, file.ID, kek, dataKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	file.ContentKey, file.Hash = contentKey, hash
+	return nil
+}
+
+func setDataKey(db *gorm.DB, fileID uint, kek, dataKey []byte) error {
+	wrapped, err := crypt.WrapKey(kek, dataKey)
+	if err != nil {
+		return err
+	}
+
+	var fileKey FileKey
+	if err := db.Where(&FileKey{FileID: fileID}).FirstOrInit(&fileKey).Error; err != nil {
+		return err
+	}
+	fileKey.WrappedKey = wrapped
+	fileKey.PendingWrappedKey = nil
+
+	return db.Save(&fileKey).Error
+}
+
+// CreateEncryptedFile creates the database entry of an encrypted file along with its wrapped data key.
+// Both are created in a single transaction, so there cannot be an encrypted file without a key. The size
+// of the file is added to the owner's used bytes, and ErrQuotaExceeded is returned if it doesn't fit.
+func CreateEncryptedFile(file *File, kek, dataKey []byte) error {
+	db := lib.GetDatabase()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := chargeQuota(tx, file.UserID, file.Size); err != nil {
+			return err
+		}
+
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+
+		return setDataKey(tx, file.ID, kek, dataKey)
+	})
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// The fields by which the files can be sorted.
+const (
+	SortName    = "name"
+	SortSize    = "size"
+	SortCreated = "created"
+	SortUpdated = "updated"
+)
+
+// sortColumns maps the sort fields to their columns.
+var sortColumns = map[string]string{
+	SortName:    "filename",
+	SortSize:    "size",
+	SortCreated: "created_at",
+	SortUpdated: "updated_at",
+}
+
+// ErrInvalidQuery is returned when the sort field or the page cursor of a file query is not valid.
+var ErrInvalidQuery = errors.New("invalid file query")
+
+// FileQuery filters and sorts a list of files, and splits it into pages. The zero value lists all of the
+// files sorted by their name.
+type FileQuery struct {
+	Search    string // a part of the filename or the description
+	Extension string
+	MIME      string   // a full type or a prefix like "image/"
+	Tags      []string // the files need to have all of the tags
+
+	MinSize *int64
+	MaxSize *int64
+	From    *time.Time // the files created at or after this
+	To      *time.Time // the files created before this
+
+	Sort string // one of the sort fields, the name by default
+	Desc bool
+
+	// Cursor is the position after which the page starts, which is taken from the earlier page. Limit is
+	// the size of a page, zero means that all of the files are listed.
+	Cursor string
+	Limit  int
+}
+
+// FilePage is a page of files. Next is the cursor of the next page, empty if this is the last page.
+type FilePage struct {
+	Files []File
+	Next  string
+}
+
+// pageCursor is the position of the last file on a page. The sort is included, so that a cursor cannot
+// be used with a different sort.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// Filtered checks if the query filters the files, instead of only sorting them.
+func (query *FileQuery) Filtered() bool {
+	return query.Search != "" || query.Extension != "" || query.MIME != "" || len(query.Tags) > 0 ||
+		query.MinSize != nil || query.MaxSize != nil || query.From != nil || query.To != nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// filter adds the conditions of the filters to the query.
+func (query *FileQuery) filter(db *gorm.DB) *gorm.DB {
+	if query.Search != "" {
+		pattern := "%" + likeEscaper.Replace(query.Search) + "%"
+		db = db.Where("filename ILIKE ? OR description ILIKE ?", pattern, pattern)
+	}
+
+	if query.Extension != "" {
+		extension := strings.ToLower(query.Extension)
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		db = db.Where("LOWER(extension) = ?", extension)
+	}
+
+	if query.MIME != "" {
+		mime := strings.ToLower(strings.TrimSuffix(query.MIME, "*"))
+		if strings.HasSuffix(mime, "/") {
+			db = db.Where("LOWER(mime) LIKE ?", likeEscaper.Replace(mime)+"%")
+		} else {
+			db = db.Where("LOWER(mime) = ?", mime)
+		}
+	}
+
+	if len(query.Tags) > 0 {
+		// The names of a file's tags are unique, so the files with every tag match all of the names.
+		db = db.Where("id IN (?)", lib.GetDatabase().Model(&FileTag{}).Select("file_tags.file_id").
+			Joins("JOIN tags ON tags.id = file_tags.tag_id").Where("tags.name IN ?", query.Tags).
+			Group("file_tags.file_id").Having("COUNT(*) = ?", len(query.Tags)))
+	}
+
+	if query.MinSize != nil {
+		db = db.Where("size >= ?", *query.MinSize)
+	}
+
+	if query.MaxSize != nil {
+		db = db.Where("size <= ?", *query.MaxSize)
+	}
+
+	if query.From != nil {
+		db = db.Where("created_at >= ?", *query.From)
+	}
+
+	if query.To != nil {
+		db = db.Where("created_at < ?", *query.To)
+	}
+
+	return db
+}
+
+// sortValue returns the value of the sort field of the file, which is stored in a cursor.
+func sortValue(file *File, sort string) string {
+	switch sort {
+	case SortSize:
+		return strconv.FormatInt(file.Size, 10)
+	case SortCreated:
+		return file.CreatedAt.Format(time.RFC3339Nano)
+	case SortUpdated:
+		return file.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		return file.Filename
+	}
+}
+
+// parseSortValue parses the value stored in a cursor into the type of the sort field.
+func parseSortValue(value, sort string) (interface{}, error) {
+	switch sort {
+	case SortSize:
+		return strconv.ParseInt(value, 10, 64)
+	case SortCreated, SortUpdated:
+		return time.Parse(time.RFC3339Nano, value)
+	default:
+		return value, nil
+	}
+}
+
+// after adds the condition which starts the page after the position of the cursor.
+func (query *FileQuery) after(db *gorm.DB, sort, column string) (*gorm.DB, error) {
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidQuery
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort || cursor.Desc != query.Desc {
+		return nil, ErrInvalidQuery
+	}
+
+	value, err := parseSortValue(cursor.Value, sort)
+	if err != nil {
+		return nil, ErrInvalidQuery
+	}
+
+	// The id breaks the ties between the files with the same value.
+	operator := ">"
+	if query.Desc {
+		operator = "<"
+	}
+
+	return db.Where(column+" "+operator+" ? OR ("+column+" = ? AND id "+operator+" ?)", value, value, cursor.ID), nil
+}
+
+// Find returns the page of the files matching the query. The files are taken from the given query, which
+// has the conditions for the files the user can see.
+func (query *FileQuery) Find(db *gorm.DB) (*FilePage, error) {
+	sort := query.Sort
+	if sort == "" {
+		sort = SortName
+	}
+
+	column, ok := sortColumns[sort]
+	if !ok {
+		return nil, ErrInvalidQuery
+	}
+
+	db = query.filter(db)
+	if query.Cursor != "" {
+		var err error
+		if db, err = query.after(db, sort, column); err != nil {
+			return nil, err
+		}
+	}
+
+	direction := " ASC"
+	if query.Desc {
+		direction = " DESC"
+	}
+	db = db.Order(column + direction).Order("id" + direction)
+
+	// One extra file is fetched to know if there is a next page.
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit + 1)
+	}
+
+	var files []File
+	if err := db.Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	page := &FilePage{Files: files}
+	if query.Limit > 0 && len(files) > query.Limit {
+		page.Files = files[:query.Limit]
+
+		last := &page.Files[len(page.Files)-1]
+		data, err := json.Marshal(pageCursor{Sort: sort, Desc: query.Desc, Value: sortValue(last, sort), ID: last.ID})
+		if err != nil {
+			return nil, err
+		}
+		page.Next = base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	return page, nil
+}
+
+// SearchFiles returns the page of the user's files matching the query, in all of the folders.
+func (user *User) SearchFiles(query *FileQuery) (*FilePage, error) {
+	db := lib.GetDatabase()
+	return query.Find(db.Model(&File{}).Where("user_id = ?", user.ID))
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// encodeCursor encodes the cursor like the pages of a file query.
+func encodeCursor(t *testing.T, cursor pageCursor) string {
+	t.Helper()
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestInvalidCursorsAreRejected(t *testing.T) {
+	cursors := []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		encodeCursor(t, pageCursor{Sort: SortSize, Value: "10", ID: 1}),
+		encodeCursor(t, pageCursor{Sort: SortName, Desc: true, Value: "a.txt", ID: 1}),
+		encodeCursor(t, pageCursor{Sort: SortCreated, Value: "yesterday", ID: 1}),
+	}
+
+	// The cursors are checked before the query is used.
+	for _, cursor := range cursors {
+		query := &FileQuery{Cursor: cursor}
+		if _, err := query.after(nil, SortName, sortColumns[SortName]); err != ErrInvalidQuery {
+			t.Errorf("expected an invalid query for the cursor %q, got: %v", cursor, err)
+		}
+	}
+
+	if _, err := (&FileQuery{Sort: "owner"}).Find(nil); err != ErrInvalidQuery {
+		t.Errorf("expected an invalid query for an unknown sort, got: %v", err)
+	}
+}
+
+func TestSortValueRoundTrip(t *testing.T) {
+	file := &File{Filename: "a.txt", Size: 1234}
+
+	for sort := range sortColumns {
+		value, err := parseSortValue(sortValue(file, sort), sort)
+		if err != nil {
+			t.Errorf("could not parse the %s value, err: %v", sort, err)
+			continue
+		}
+
+		want := map[string]string{SortName: "a.txt", SortSize: "1234"}
+		if got := fmt.Sprint(value); want[sort] != "" && got != want[sort] {
+			t.Errorf("wrong %s value. want=%s, got=%s", sort, want[sort], got)
+		}
+	}
+}
+
+func TestSearchFilesPages(t *testing.T) {
+	user := newTestUser(t)
+
+	// Some of the files have the same size, so the id breaks the ties.
+	sizes := []string{"aa", "b", "cc", "dddd", "e", "ff", "ggg"}
+	for i, contents := range sizes {
+		newTestFile(t, user, fmt.Sprintf("file-%d.txt", i), contents)
+	}
+
+	for _, desc := range []bool{false, true} {
+		query := &FileQuery{Sort: SortSize, Desc: desc, Limit: 3}
+
+		var seen []File
+		for {
+			page, err := user.SearchFiles(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen = append(seen, page.Files...)
+
+			if page.Next == "" {
+				break
+			}
+
+			// The cursor cannot be used with another sort.
+			other := &FileQuery{Sort: SortName, Desc: desc, Cursor: page.Next, Limit: 3}
+			if _, err := user.SearchFiles(other); err != ErrInvalidQuery {
+				t.Errorf("the cursor should not work with another sort, got: %v", err)
+			}
+
+			query.Cursor = page.Next
+		}
+
+		if len(seen) != len(sizes) {
+			t.Fatalf("wrong number of files across the pages. want=%d, got=%d", len(sizes), len(seen))
+		}
+
+		ids := make(map[uint]bool)
+		for i, file := range seen {
+			if ids[file.ID] {
+				t.Errorf("the file %s was listed twice", file.Filename)
+			}
+			ids[file.ID] = true
+
+			if i == 0 {
+				continue
+			}
+
+			prev := seen[i-1]
+			ordered := prev.Size < file.Size || prev.Size == file.Size && prev.ID < file.ID
+			if desc {
+				ordered = prev.Size > file.Size || prev.Size == file.Size && prev.ID > file.ID
+			}
+
+			if !ordered {
+				t.Errorf("the files are not in order (desc=%t): %s before %s", desc, prev.Filename, file.Filename)
+			}
+		}
+	}
+
+	// The filters are applied to the pages.
+	page, err := user.SearchFiles(&FileQuery{Search: "file-3", Extension: "TXT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Files) != 1 || page.Files[0].Filename != "file-3.txt" || page.Next != "" {
+		t.Errorf("wrong files for the search: %+v", page)
+	}
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// The permission levels of a share. Every level includes the permissions of the levels before it.
+const (
+	PermissionView     = "view"     // Viewing the file's information.
+	PermissionDownload = "download" // Downloading the file.
+	PermissionEdit     = "edit"     // Changing the filename and the description.
+	PermissionUpload   = "upload"   // Uploading new versions of the file.
+)
+
+// Permissions contains all of the permission levels from the lowest to the highest.
+var Permissions = []string{PermissionView, PermissionDownload, PermissionEdit, PermissionUpload}
+
+// permissionLevel returns the position of the permission in the levels, or -1 if the permission is not
+// valid.
+func permissionLevel(permission string) int {
+	for i, p := range Permissions {
+		if p == permission {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ValidPermission checks if the permission is one of the permission levels of a share.
+func ValidPermission(permission string) bool {
+	return permissionLevel(permission) != -1
+}
+
+// PermissionAllows checks if the permission level includes the wanted permission.
+func PermissionAllows(level, want string) bool {
+	wanted := permissionLevel(want)
+	return wanted != -1 && permissionLevel(level) >= wanted
+}
+
+// Allows checks if the share's permission level includes the permission.
+func (share *FileShare) Allows(permission string) bool {
+	return PermissionAllows(share.Permission, permission)
+}
+
+// Expired checks if the share has an expiration time, which has passed.
+func (share *FileShare) Expired() bool {
+	return share.ExpiresAt != nil && time.Now().After(*share.ExpiresAt)
+}
+
+// activeShares limits the query to the shares which haven't expired.
+func activeShares(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+// CreateFileShare stores the share, replacing the earlier shares of the same file to the same user. This
+// way the permission level and the expiration time can be changed by sharing the file again.
+func CreateFileShare(share *FileShare) error {
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where(&FileShare{SharedFileID: share.SharedFileID, SharedToID: share.SharedToID}).
+			Delete(&FileShare{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(share).Error
+	})
+}
+
+// FindFileShare finds the share record of the file shared to the user. The expired shares are not found.
+func FindFileShare(fileID, userID uint) (*FileShare, error) {
+	db := lib.GetDatabase()
+
+	var share FileShare
+	if err := db.Scopes(activeShares).Where(&FileShare{SharedFileID: fileID, SharedToID: userID}).
+		First(&share).Error; err != nil {
+		return nil, err
+	}
+
+	return &share, nil
+}
+
+// DeleteExpiredShares removes the shares to users and groups which have expired. The shares are removed
+// for good, since the sealed keys of the encrypted files shouldn't be kept around.
+func DeleteExpiredShares() error {
+	db := lib.GetDatabase()
+	if err := db.Unscoped().Where("expires_at <= ?", time.Now()).Delete(&FileShare{}).Error; err != nil {
+		return err
+	}
+
+	return db.Unscoped().Where("expires_at <= ?", time.Now()).Delete(&GroupShare{}).Error
+}
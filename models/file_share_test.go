@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+func TestPermissionAllows(t *testing.T) {
+	tests := []struct {
+		level, want string
+		allowed     bool
+	}{
+		{PermissionView, PermissionView, true},
+		{PermissionView, PermissionDownload, false},
+		{PermissionDownload, PermissionView, true},
+		{PermissionEdit, PermissionDownload, true},
+		{PermissionEdit, PermissionUpload, false},
+		{PermissionUpload, PermissionEdit, true},
+		{"", PermissionView, false},
+		{PermissionUpload, "unknown", false},
+	}
+
+	for _, test := range tests {
+		if allowed := PermissionAllows(test.level, test.want); allowed != test.allowed {
+			t.Errorf("%q allows %q: want=%t, got=%t", test.level, test.want, test.allowed, allowed)
+		}
+	}
+
+	for _, permission := range Permissions {
+		if !ValidPermission(permission) {
+			t.Errorf("%q should be a valid permission", permission)
+		}
+	}
+
+	if ValidPermission("owner") || ValidPermission("") {
+		t.Error("unknown permissions should not be valid")
+	}
+}
+
+func TestFileShareExpired(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	if (&FileShare{}).Expired() {
+		t.Error("a share without an expiration time should not expire")
+	}
+
+	if !(&FileShare{ExpiresAt: &past}).Expired() {
+		t.Error("the share should have expired")
+	}
+
+	if (&FileShare{ExpiresAt: &future}).Expired() {
+		t.Error("the share should not have expired yet")
+	}
+}
+
+func TestFileShareReplaceAndExpire(t *testing.T) {
+	owner := newTestUser(t)
+	recipient := newTestUser(t)
+	file := newTestFile(t, owner, "shared.txt", "shared contents")
+
+	if err := CreateFileShare(&FileShare{SharedByID: owner.ID, SharedToID: recipient.ID, SharedFileID: file.ID,
+		Permission: PermissionView}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sharing the file again replaces the permission level.
+	if err := CreateFileShare(&FileShare{SharedByID: owner.ID, SharedToID: recipient.ID, SharedFileID: file.ID,
+		Permission: PermissionEdit}); err != nil {
+		t.Fatal(err)
+	}
+
+	permission, err := FilePermission(file, recipient.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if permission != PermissionEdit {
+		t.Errorf("wrong permission. want=%q, got=%q", PermissionEdit, permission)
+	}
+
+	// The expired share cannot be used, and it's removed for good.
+	past := time.Now().Add(-time.Minute)
+	if err := CreateFileShare(&FileShare{SharedByID: owner.ID, SharedToID: recipient.ID, SharedFileID: file.ID,
+		Permission: PermissionEdit, ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindFileShare(file.ID, recipient.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the expired share should not be found, got: %v", err)
+	}
+
+	if _, err := FilePermission(file, recipient.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the expired share should not give a permission, got: %v", err)
+	}
+
+	if err := DeleteExpiredShares(); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	if err := lib.GetDatabase().Unscoped().Model(&FileShare{}).Where("shared_file_id = ?", file.ID).
+		Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("the expired share should have been removed, %d left", count)
+	}
+}
@@ -0,0 +1,262 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// maxFolderDepth limits how deep the folders can be nested. It also stops walking the parents if the
+// references somehow form a loop.
+const maxFolderDepth = 32
+
+var (
+	// ErrFolderCycle is returned when a folder would be moved inside itself.
+	ErrFolderCycle = errors.New("a folder cannot be moved inside itself")
+
+	// ErrFolderTooDeep is returned when the folders would be nested deeper than maxFolderDepth.
+	ErrFolderTooDeep = errors.New("the folders are nested too deep")
+)
+
+// Folder groups the user's files. Folders can contain other folders, the folders at the top level don't
+// have a parent.
+type Folder struct {
+	gorm.Model
+	UUID     string `gorm:"uniqueIndex"`
+	Name     string
+	UserID   uint  `gorm:"index"`
+	ParentID *uint `gorm:"index"`
+}
+
+// Serialize serializes the folder's data into json format
+func (folder *Folder) Serialize() lib.JSON {
+	return lib.JSON{
+		"name":       folder.Name,
+		"uuid":       folder.UUID,
+		"created_at": folder.CreatedAt,
+		"updated_at": folder.UpdatedAt,
+	}
+}
+
+// FindUserFolder finds one of the user's folders.
+func FindUserFolder(userID uint, folderID string) (*Folder, error) {
+	db := lib.GetDatabase()
+
+	var folder Folder
+	if err := db.Where("uuid = ? AND user_id = ?", folderID, userID).First(&folder).Error; err != nil {
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+// FindFolder finds the folder with the given uuid, regardless of its owner.
+func FindFolder(folderID string) (*Folder, error) {
+	db := lib.GetDatabase()
+
+	var folder Folder
+	if err := db.Where("uuid = ?", folderID).First(&folder).Error; err != nil {
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+// folderIDOf returns the id of the folder, or nil for the top level.
+func folderIDOf(folder *Folder) *uint {
+	if folder == nil {
+		return nil
+	}
+
+	return &folder.ID
+}
+
+// inFolder adds a condition which matches the rows in the given folder column.
+func inFolder(db *gorm.DB, column string, folder *Folder) *gorm.DB {
+	if folder == nil {
+		return db.Where(column + " IS NULL")
+	}
+
+	return db.Where(column+" = ?", folder.ID)
+}
+
+// FindFolderContents returns the folders and the page of the files matching the query directly inside
+// the given folder. If the folder is nil, the contents of the user's top level are returned.
+func (user *User) FindFolderContents(folder *Folder, query *FileQuery) ([]Folder, *FilePage, error) {
+	db := lib.GetDatabase()
+
+	var folders []Folder
+	if err := inFolder(db.Where("user_id = ?", user.ID), "parent_id", folder).
+		Order("name").Find(&folders).Error; err != nil {
+		return nil, nil, err
+	}
+
+	page, err := query.Find(inFolder(db.Model(&File{}).Where("user_id = ?", user.ID), "folder_id", folder))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return folders, page, nil
+}
+
+// FindFolders returns all of the user's folders.
+func (user *User) FindFolders() ([]Folder, error) {
+	db := lib.GetDatabase()
+
+	var folders []Folder
+	if err := db.Where(&Folder{UserID: user.ID}).Order("name").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+// FolderNameTaken tells if the parent folder already contains a folder with the given name.
+func (user *User) FolderNameTaken(parent *Folder, name string) (bool, error) {
+	db := lib.GetDatabase()
+
+	var count int64
+	if err := inFolder(db.Model(&Folder{}).Where("user_id = ? AND name = ?", user.ID, name), "parent_id", parent).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// CreateFolder creates a new folder inside the parent folder. If the parent is nil, the folder is created
+// at the top level.
+func (user *User) CreateFolder(name string, parent *Folder) (*Folder, error) {
+	if parent != nil {
+		path, err := parent.Path()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(path) >= maxFolderDepth {
+			return nil, ErrFolderTooDeep
+		}
+	}
+
+	folder := &Folder{
+		UUID:     lib.GenerateUUID(),
+		Name:     name,
+		UserID:   user.ID,
+		ParentID: folderIDOf(parent),
+	}
+
+	if err := lib.GetDatabase().Create(folder).Error; err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// Path returns the folders from the top level down to and including this folder. It's used to display
+// the breadcrumbs.
+func (folder *Folder) Path() ([]Folder, error) {
+	db := lib.GetDatabase()
+
+	path := []Folder{*folder}
+	for current := folder; current.ParentID != nil; {
+		if len(path) > maxFolderDepth {
+			return nil, ErrFolderTooDeep
+		}
+
+		var parent Folder
+		if err := db.Where("id = ? AND user_id = ?", *current.ParentID, folder.UserID).First(&parent).Error; err != nil {
+			return nil, err
+		}
+
+		path = append([]Folder{parent}, path...)
+		current = &parent
+	}
+
+	return path, nil
+}
+
+// Rename changes the name of the folder.
+func (folder *Folder) Rename(name string) error {
+	folder.Name = name
+	return lib.GetDatabase().Model(folder).Update("name", name).Error
+}
+
+// Move moves the folder along with its contents inside the parent folder. If the parent is nil, the folder
+// is moved to the top level. A folder cannot be moved inside itself or one of its subfolders.
+func (folder *Folder) Move(parent *Folder) error {
+	if parent != nil {
+		path, err := parent.Path()
+		if err != nil {
+			return err
+		}
+
+		for _, f := range path {
+			if f.ID == folder.ID {
+				return ErrFolderCycle
+			}
+		}
+
+		if len(path) >= maxFolderDepth {
+			return ErrFolderTooDeep
+		}
+	}
+
+	folder.ParentID = folderIDOf(parent)
+	return lib.GetDatabase().Model(folder).Update("parent_id", folder.ParentID).Error
+}
+
+// subfolderIDs returns the ids of the folder and all of the folders inside it.
+func (folder *Folder) subfolderIDs(db *gorm.DB) ([]uint, error) {
+	ids := []uint{folder.ID}
+	level := []uint{folder.ID}
+	for depth := 0; len(level) > 0; depth++ {
+		if depth > maxFolderDepth {
+			return nil, ErrFolderTooDeep
+		}
+
+		var children []uint
+		if err := db.Model(&Folder{}).Where("parent_id IN ?", level).Pluck("id", &children).Error; err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, children...)
+		level = children
+	}
+
+	return ids, nil
+}
+
+// Delete removes the folder and everything inside it. The files are moved into the trash, from which
+// they are restored at the top level. Everything is removed in a single transaction, so that a failure
+// doesn't leave a half removed tree behind.
+func (folder *Folder) Delete() error {
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		ids, err := folder.subfolderIDs(tx)
+		if err != nil {
+			return err
+		}
+
+		// The folders are removed before the files are trashed, so that the files stored into the
+		// folders in the meantime are trashed as well.
+		if err := tx.Where("id IN ?", ids).Delete(&Folder{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&File{}).Where("folder_id IN ?", ids).Updates(map[string]interface{}{
+			"trashed":    true,
+			"deleted_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("folder_id IN ?", ids).Delete(&GroupShare{}).Error
+	})
+}
+
+// MoveTo moves the file inside the folder. If the folder is nil, the file is moved to the top level.
+func (file *File) MoveTo(folder *Folder) error {
+	file.FolderID = folderIDOf(folder)
+	return lib.GetDatabase().Model(file).Update("folder_id", file.FolderID).Error
+}
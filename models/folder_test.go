@@ -0,0 +1,160 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
+	"gorm.io/gorm"
+)
+
+func TestFindUserFolderRequiresID(t *testing.T) {
+	user := newTestUser(t)
+
+	if _, err := user.CreateFolder("folder", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty id must not match the first folder of the user.
+	if _, err := FindUserFolder(user.ID, ""); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected no folder for an empty id, got: %v", err)
+	}
+}
+
+func TestFolderMoveCycle(t *testing.T) {
+	user := newTestUser(t)
+
+	parent, err := user.CreateFolder("parent", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := user.CreateFolder("child", parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parent.Move(child); err != ErrFolderCycle {
+		t.Errorf("expected a cycle error, got: %v", err)
+	}
+
+	path, err := child.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(path) != 2 || path[0].ID != parent.ID || path[1].ID != child.ID {
+		t.Errorf("wrong path for the child folder: %+v", path)
+	}
+}
+
+func TestFileDeleteRemovesContents(t *testing.T) {
+	user := newTestUser(t)
+	backend := storage.GetBackend()
+
+	// Both of the files use the same blob.
+	first := newTestFile(t, user, "first.txt", "shared contents")
+	second := newTestFile(t, user, "second.txt", "shared contents")
+	key := first.StorageKey(user.UUID)
+
+	if err := first.Delete(user.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.Stat(key); err != nil {
+		t.Errorf("the blob should be kept for the other file, err: %v", err)
+	}
+
+	if err := second.Delete(user.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	if err := lib.GetDatabase().Model(&Blob{}).Where("hash = ?", second.Hash).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Error("the blob should have been removed with the last file")
+	}
+
+	if _, err := FindOneFile(&File{UUID: second.UUID}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the file should have been removed, got: %v", err)
+	}
+}
+
+func TestFolderDelete(t *testing.T) {
+	user := newTestUser(t)
+
+	parent, err := user.CreateFolder("parent", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := user.CreateFolder("child", parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inParent := newTestFile(t, user, "parent.txt", "in the parent")
+	inChild := newTestFile(t, user, "child.txt", "in the child")
+	outside := newTestFile(t, user, "outside.txt", "outside")
+	if err := inParent.MoveTo(parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := inChild.MoveTo(child); err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(user, "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := group.Delete(); err != nil {
+			t.Error("could not remove the test group, err: ", err)
+		}
+	})
+
+	if err := group.ShareFolder(user, child, PermissionView, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parent.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := lib.GetDatabase()
+	for _, file := range []*File{inParent, inChild} {
+		var trashed File
+		if err := db.Unscoped().First(&trashed, file.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if !trashed.Trashed || !trashed.DeletedAt.Valid {
+			t.Errorf("%s should have been moved into the trash", file.Filename)
+		}
+	}
+
+	if _, err := FindOneFile(&File{UUID: outside.UUID}); err != nil {
+		t.Errorf("the file outside of the folder should be kept, err: %v", err)
+	}
+
+	var count int64
+	if err := db.Model(&Folder{}).Where("id IN ?", []uint{parent.ID, child.ID}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("the folders should have been removed, %d left", count)
+	}
+
+	if err := db.Model(&GroupShare{}).Where("folder_id = ?", child.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("the group share of the subfolder should have been removed, %d left", count)
+	}
+}
@@ -0,0 +1,448 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// The roles of the group members. The owners manage the group, the roles of its members and delete the
+// group. The admins add and remove the members. Every member can use the files shared with the group.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Roles contains all of the roles a group member can have.
+var Roles = []string{RoleOwner, RoleAdmin, RoleMember}
+
+var (
+	// ErrLastOwner is returned when the only owner of a group would leave the group or lose the role.
+	ErrLastOwner = errors.New("a group needs at least one owner")
+
+	// ErrAlreadyMember is returned when a user is added to a group they already belong to.
+	ErrAlreadyMember = errors.New("the user is already a member of the group")
+
+	// ErrEncryptedGroupShare is returned when an encrypted file is shared with a group. The key of an
+	// encrypted file is sealed to each recipient, so the members who join later couldn't open the file.
+	ErrEncryptedGroupShare = errors.New("encrypted files cannot be shared with groups")
+)
+
+// Group is a named set of users, to which files and folders can be shared. The access to the shared files
+// is checked through the memberships, so the users who join later get access and the users who leave
+// lose it.
+type Group struct {
+	gorm.Model
+	UUID string `gorm:"uniqueIndex"`
+	Name string
+}
+
+// GroupMembership makes the user a member of the group with the given role.
+type GroupMembership struct {
+	gorm.Model
+	GroupID uint `gorm:"index"`
+	UserID  uint `gorm:"index"`
+	Role    string
+}
+
+// GroupShare shares a file, or the unencrypted files inside a folder and its subfolders, with the members
+// of a group. Exactly one of FileID and FolderID is set.
+type GroupShare struct {
+	gorm.Model
+	GroupID    uint `gorm:"index"`
+	SharedByID uint
+	FileID     *uint `gorm:"index"`
+	FolderID   *uint `gorm:"index"`
+
+	Permission string     `gorm:"default:download"`
+	ExpiresAt  *time.Time `gorm:"index"`
+}
+
+// MemberGroup is a group along with the role of the user who is listing their groups.
+type MemberGroup struct {
+	UUID string
+	Name string
+	Role string
+}
+
+// GroupMember is a member of a group along with their role.
+type GroupMember struct {
+	UserID   uint
+	Username string
+	Role     string
+}
+
+// ValidRole checks if the role is one of the roles a group member can have.
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateGroup creates a new group, which is owned by the user.
+func CreateGroup(owner *User, name string) (*Group, error) {
+	group := &Group{
+		UUID: lib.GenerateUUID(),
+		Name: name,
+	}
+
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(group).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&GroupMembership{GroupID: group.ID, UserID: owner.ID, Role: RoleOwner}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// FindGroup finds the group with the given uuid.
+func FindGroup(groupID string) (*Group, error) {
+	db := lib.GetDatabase()
+
+	var group Group
+	if err := db.Where("uuid = ?", groupID).First(&group).Error; err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// FindGroups returns the groups the user is a member of along with the user's role in them.
+func (user *User) FindGroups() ([]MemberGroup, error) {
+	db := lib.GetDatabase()
+
+	var groups []MemberGroup
+	if err := db.Model(&Group{}).Select("groups.uuid, groups.name, group_memberships.role").
+		Joins("JOIN group_memberships ON group_memberships.group_id = groups.id AND group_memberships.deleted_at IS NULL").
+		Where("group_memberships.user_id = ?", user.ID).Order("groups.name").Scan(&groups).Error; err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// Role returns the role of the user in the group. If the user isn't a member, gorm.ErrRecordNotFound is
+// returned.
+func (group *Group) Role(userID uint) (string, error) {
+	db := lib.GetDatabase()
+
+	var membership GroupMembership
+	if err := db.Where(&GroupMembership{GroupID: group.ID, UserID: userID}).First(&membership).Error; err != nil {
+		return "", err
+	}
+
+	return membership.Role, nil
+}
+
+// FindMembers returns the members of the group ordered by their username.
+func (group *Group) FindMembers() ([]GroupMember, error) {
+	db := lib.GetDatabase()
+
+	var members []GroupMember
+	if err := db.Model(&GroupMembership{}).Select("users.id AS user_id, users.username, group_memberships.role").
+		Joins("JOIN users ON users.id = group_memberships.user_id").
+		Where("group_memberships.group_id = ?", group.ID).Order("users.username").Scan(&members).Error; err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// AddMember adds the user to the group with the given role.
+func (group *Group) AddMember(user *User, role string) error {
+	if _, err := group.Role(user.ID); err == nil {
+		return ErrAlreadyMember
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return lib.GetDatabase().Create(&GroupMembership{GroupID: group.ID, UserID: user.ID, Role: role}).Error
+}
+
+// otherOwners counts the owners of the group other than the user.
+func (group *Group) otherOwners(tx *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&GroupMembership{}).Where("group_id = ? AND role = ? AND user_id <> ?", group.ID, RoleOwner, userID).
+		Count(&count).Error
+	return count, err
+}
+
+// SetRole changes the role of a member. The last owner of the group cannot give up the role.
+func (group *Group) SetRole(userID uint, role string) error {
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if role != RoleOwner {
+			owners, err := group.otherOwners(tx, userID)
+			if err != nil {
+				return err
+			}
+
+			if owners == 0 {
+				return ErrLastOwner
+			}
+		}
+
+		res := tx.Model(&GroupMembership{}).Where("group_id = ? AND user_id = ?", group.ID, userID).Update("role", role)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// RemoveMember removes the user from the group, after which the user cannot access the files shared with
+// the group. The last owner of the group cannot leave it.
+func (group *Group) RemoveMember(userID uint) error {
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		owners, err := group.otherOwners(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if owners == 0 {
+			return ErrLastOwner
+		}
+
+		res := tx.Unscoped().Where("group_id = ? AND user_id = ?", group.ID, userID).Delete(&GroupMembership{})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// Delete removes the group along with its memberships and shares.
+func (group *Group) Delete() error {
+	return lib.GetDatabase().Transaction(group.delete)
+}
+
+func (group *Group) delete(tx *gorm.DB) error {
+	if err := tx.Unscoped().Where(&GroupMembership{GroupID: group.ID}).Delete(&GroupMembership{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Where(&GroupShare{GroupID: group.ID}).Delete(&GroupShare{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Delete(group).Error
+}
+
+// ShareFile shares the file with the members of the group, replacing the earlier share of the same file.
+// Only unencrypted files can be shared with groups.
+func (group *Group) ShareFile(by *User, file *File, permission string, expiresAt *time.Time) error {
+	if !file.ShareableFile {
+		return ErrEncryptedGroupShare
+	}
+
+	return group.share(&GroupShare{GroupID: group.ID, SharedByID: by.ID, FileID: &file.ID,
+		Permission: permission, ExpiresAt: expiresAt}, "file_id = ?", file.ID)
+}
+
+// ShareFolder shares the unencrypted files inside the folder and its subfolders with the members of the
+// group, replacing the earlier share of the same folder.
+func (group *Group) ShareFolder(by *User, folder *Folder, permission string, expiresAt *time.Time) error {
+	return group.share(&GroupShare{GroupID: group.ID, SharedByID: by.ID, FolderID: &folder.ID,
+		Permission: permission, ExpiresAt: expiresAt}, "folder_id = ?", folder.ID)
+}
+
+// share stores the share after removing the earlier shares matching the condition.
+func (group *Group) share(share *GroupShare, condition string, id uint) error {
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("group_id = ?", group.ID).Where(condition, id).
+			Delete(&GroupShare{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(share).Error
+	})
+}
+
+// UnshareFile removes the share of the file with the group.
+func (group *Group) UnshareFile(fileID uint) error {
+	return lib.GetDatabase().Unscoped().Where("group_id = ? AND file_id = ?", group.ID, fileID).
+		Delete(&GroupShare{}).Error
+}
+
+// UnshareFolder removes the share of the folder with the group.
+func (group *Group) UnshareFolder(folderID uint) error {
+	return lib.GetDatabase().Unscoped().Where("group_id = ? AND folder_id = ?", group.ID, folderID).
+		Delete(&GroupShare{}).Error
+}
+
+// FindSharedFiles returns the files shared directly with the group. The expired shares are left out.
+func (group *Group) FindSharedFiles() ([]File, error) {
+	db := lib.GetDatabase()
+
+	var files []File
+	if err := db.Where("id IN (?)", db.Model(&GroupShare{}).Scopes(activeShares).Select("file_id").
+		Where("group_id = ?", group.ID)).Order("filename").Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// FindSharedFolders returns the folders shared with the group. The expired shares are left out.
+func (group *Group) FindSharedFolders() ([]Folder, error) {
+	db := lib.GetDatabase()
+
+	var folders []Folder
+	if err := db.Where("id IN (?)", db.Model(&GroupShare{}).Scopes(activeShares).Select("folder_id").
+		Where("group_id = ?", group.ID)).Order("name").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+// memberGroupShares limits the query to the active group shares of the groups the user is a member of.
+func memberGroupShares(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&GroupShare{}).Scopes(activeShares).
+		Where("group_id IN (?)", db.Model(&GroupMembership{}).Select("group_id").Where("user_id = ?", userID))
+}
+
+// groupFolderIDs returns the ids of the folders shared with the user's groups, along with all of the
+// folders inside them.
+func groupFolderIDs(userID uint) ([]uint, error) {
+	db := lib.GetDatabase()
+
+	var folders []Folder
+	if err := db.Where("id IN (?)", memberGroupShares(db, userID).Select("folder_id")).
+		Find(&folders).Error; err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	for i := range folders {
+		subfolders, err := folders[i].subfolderIDs(db)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, subfolders...)
+	}
+
+	return ids, nil
+}
+
+// FilePermission returns the highest permission the user has to a file owned by another user, either
+// through a share to the user or through the shares with the user's groups. Folder shares only give
+// access to the unencrypted files. If the file isn't shared to the user, gorm.ErrRecordNotFound is
+// returned.
+func FilePermission(file *File, userID uint) (string, error) {
+	db := lib.GetDatabase()
+
+	var permissions []string
+	if share, err := FindFileShare(file.ID, userID); err == nil {
+		permissions = append(permissions, share.Permission)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	// The folder shares apply to the files inside the shared folders and their subfolders.
+	var folderIDs []uint
+	if file.FolderID != nil && file.ShareableFile {
+		var folder Folder
+		if err := db.First(&folder, *file.FolderID).Error; err != nil {
+			return "", err
+		}
+
+		path, err := folder.Path()
+		if err != nil {
+			return "", err
+		}
+
+		for _, f := range path {
+			folderIDs = append(folderIDs, f.ID)
+		}
+	}
+
+	var groupPermissions []string
+	query := memberGroupShares(db, userID)
+	if len(folderIDs) > 0 {
+		query = query.Where("file_id = ? OR folder_id IN ?", file.ID, folderIDs)
+	} else {
+		query = query.Where("file_id = ?", file.ID)
+	}
+	if err := query.Pluck("permission", &groupPermissions).Error; err != nil {
+		return "", err
+	}
+	permissions = append(permissions, groupPermissions...)
+
+	if len(permissions) == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+
+	highest := permissions[0]
+	for _, permission := range permissions[1:] {
+		if permissionLevel(permission) > permissionLevel(highest) {
+			highest = permission
+		}
+	}
+
+	return highest, nil
+}
+
+// leaveGroups removes the user from all of their groups along with the user's group shares. The groups
+// in which the user is the only owner are given to their longest standing member, or removed if the user
+// is the only member.
+func (user *User) leaveGroups(db *gorm.DB) error {
+	var memberships []GroupMembership
+	if err := db.Where(&GroupMembership{UserID: user.ID, Role: RoleOwner}).Find(&memberships).Error; err != nil {
+		return err
+	}
+
+	for _, membership := range memberships {
+		group := &Group{Model: gorm.Model{ID: membership.GroupID}}
+		owners, err := group.otherOwners(db, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if owners > 0 {
+			continue
+		}
+
+		var next GroupMembership
+		err = db.Where("group_id = ? AND user_id <> ?", group.ID, user.ID).Order("created_at").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := group.delete(db); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := db.Model(&next).Update("role", RoleOwner).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.Unscoped().Where(&GroupShare{SharedByID: user.ID}).Delete(&GroupShare{}).Error; err != nil {
+		return err
+	}
+
+	return db.Unscoped().Where(&GroupMembership{UserID: user.ID}).Delete(&GroupMembership{}).Error
+}
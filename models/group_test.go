@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestValidRole(t *testing.T) {
+	for _, role := range Roles {
+		if !ValidRole(role) {
+			t.Errorf("%q should be a valid role", role)
+		}
+	}
+
+	if ValidRole("") || ValidRole(PermissionView) {
+		t.Error("unknown roles should not be valid")
+	}
+}
+
+func TestGroupOwners(t *testing.T) {
+	owner := newTestUser(t)
+	member := newTestUser(t)
+
+	group, err := CreateGroup(owner, "owners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := group.Delete(); err != nil {
+			t.Error("could not remove the test group, err: ", err)
+		}
+	})
+
+	if err := group.AddMember(member, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := group.AddMember(member, RoleAdmin); err != ErrAlreadyMember {
+		t.Errorf("expected the user to be a member already, got: %v", err)
+	}
+
+	// The only owner can neither give up the role nor leave the group.
+	if err := group.SetRole(owner.ID, RoleAdmin); err != ErrLastOwner {
+		t.Errorf("the last owner should not lose the role, got: %v", err)
+	}
+
+	if err := group.RemoveMember(owner.ID); err != ErrLastOwner {
+		t.Errorf("the last owner should not leave the group, got: %v", err)
+	}
+
+	// After another owner has been added, the first one can leave.
+	if err := group.SetRole(member.ID, RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := group.RemoveMember(owner.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := group.Role(owner.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the owner should have left the group, got: %v", err)
+	}
+
+	members, err := group.FindMembers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(members) != 1 || members[0].UserID != member.ID || members[0].Role != RoleOwner {
+		t.Errorf("wrong members left: %+v", members)
+	}
+}
+
+func TestGroupShareAccess(t *testing.T) {
+	owner := newTestUser(t)
+	member := newTestUser(t)
+
+	group, err := CreateGroup(owner, "shares")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := group.Delete(); err != nil {
+			t.Error("could not remove the test group, err: ", err)
+		}
+	})
+
+	if err := group.AddMember(member, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	file := newTestFile(t, owner, "group.txt", "group contents")
+	if err := group.ShareFile(owner, file, PermissionView, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The direct share gives a higher permission than the group share, so the highest one is used.
+	if err := CreateFileShare(&FileShare{SharedByID: owner.ID, SharedToID: member.ID, SharedFileID: file.ID,
+		Permission: PermissionDownload}); err != nil {
+		t.Fatal(err)
+	}
+
+	if permission, err := FilePermission(file, member.ID); err != nil || permission != PermissionDownload {
+		t.Errorf("wrong permission. want=%q, got=%q, err: %v", PermissionDownload, permission, err)
+	}
+
+	// Files inside a shared folder are shared too.
+	folder, err := owner.CreateFolder("shared", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inside := newTestFile(t, owner, "inside.txt", "inside contents")
+	if err := inside.MoveTo(folder); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := group.ShareFolder(owner, folder, PermissionEdit, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if permission, err := FilePermission(inside, member.ID); err != nil || permission != PermissionEdit {
+		t.Errorf("wrong permission inside the folder. want=%q, got=%q, err: %v", PermissionEdit, permission, err)
+	}
+
+	// Encrypted files cannot be shared with groups.
+	if err := group.ShareFile(owner, &File{}, PermissionView, nil); err != ErrEncryptedGroupShare {
+		t.Errorf("expected an error for an encrypted file, got: %v", err)
+	}
+
+	// The member loses the access to the folder after leaving the group.
+	if err := group.RemoveMember(member.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FilePermission(inside, member.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the member should not have access after leaving, got: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// ErrNoPublicKey is returned when an encrypted file is shared to a user who doesn't have a key pair yet.
+// The users get their key pair when they register or the next time they use their master password.
+var ErrNoPublicKey = errors.New("the user doesn't have a key pair for encrypted sharing yet")
+
+// PrivateKey returns the user's private key, which is unwrapped using the key encryption key derived from
+// the master password. If the user doesn't have a key pair yet, it's created. The master password needs
+// to be checked before calling this, since otherwise a new private key could be wrapped with a wrong key.
+func (user *User) PrivateKey(kek []byte) ([]byte, error) {
+	if len(user.WrappedPrivateKey) != 0 {
+		return crypt.UnwrapKey(kek, user.WrappedPrivateKey)
+	}
+
+	publicKey, privateKey, err := crypt.GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := crypt.WrapKey(kek, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another request could have created the key pair in the meantime, in which case it's used instead.
+	res := lib.GetDatabase().Model(user).Where("wrapped_private_key IS NULL").Updates(map[string]interface{}{
+		"public_key":          publicKey,
+		"wrapped_private_key": wrapped,
+	})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		if err := lib.GetDatabase().First(user, user.ID).Error; err != nil {
+			return nil, err
+		}
+
+		return crypt.UnwrapKey(kek, user.WrappedPrivateKey)
+	}
+
+	user.PublicKey = publicKey
+	user.WrappedPrivateKey = wrapped
+	return privateKey, nil
+}
+
+// SealKeyFor seals the data key of a file to the recipient's public key, so that only the recipient can
+// unwrap it. If the recipient doesn't have a key pair yet, ErrNoPublicKey is returned.
+func SealKeyFor(recipient *User, dataKey []byte) ([]byte, error) {
+	if len(recipient.PublicKey) == 0 {
+		return nil, ErrNoPublicKey
+	}
+
+	return crypt.SealKey(recipient.PublicKey, dataKey)
+}
+
+// DataKey unwraps the data key of the shared file using the recipient's private key.
+func (share *FileShare) DataKey(privateKey []byte) ([]byte, error) {
+	return crypt.OpenKey(privateKey, share.WrappedKey)
+}
+
+// SetPrivateKey wraps the user's private key with the key encryption key of the new master password. The
+// key is taken into use when the change is committed.
+func (job *MasterKeyChange) SetPrivateKey(newKek, privateKey []byte) error {
+	wrapped, err := crypt.WrapKey(newKek, privateKey)
+	if err != nil {
+		return err
+	}
+
+	job.WrappedPrivateKey = wrapped
+	return lib.GetDatabase().Model(job).Update("wrapped_private_key", wrapped).Error
+}
+
+// commitPrivateKey takes the private key wrapped with the new master password into use.
+func (job *MasterKeyChange) commitPrivateKey(tx *gorm.DB) error {
+	if len(job.WrappedPrivateKey) == 0 {
+		return nil
+	}
+
+	return tx.Model(&User{}).Where("id = ?", job.UserID).Update("wrapped_private_key", job.WrappedPrivateKey).Error
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nireo/upfi/crypt"
+)
+
+func TestSealKeyWithoutKeyPair(t *testing.T) {
+	if _, err := SealKeyFor(&User{}, []byte("data key")); err != ErrNoPublicKey {
+		t.Errorf("expected no public key, got: %v", err)
+	}
+}
+
+func TestSealKeyFor(t *testing.T) {
+	recipient := newTestUser(t)
+
+	kek, err := recipient.MasterKey("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key pair is created the first time it's needed, and then the same key is returned.
+	privateKey, err := recipient.PrivateKey(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := FindOneUser(&User{UUID: recipient.UUID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, err := stored.PrivateKey(kek); err != nil || !bytes.Equal(again, privateKey) {
+		t.Fatalf("the stored private key should be returned, err: %v", err)
+	}
+
+	dataKey, err := crypt.GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := SealKeyFor(stored, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	share := &FileShare{WrappedKey: sealed}
+	if opened, err := share.DataKey(privateKey); err != nil || !bytes.Equal(opened, dataKey) {
+		t.Errorf("the recipient should open the sealed key, err: %v", err)
+	}
+
+	// Another user's private key cannot open it.
+	other := newTestUser(t)
+	otherKek, err := other.MasterKey("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := other.PrivateKey(otherKek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := share.DataKey(otherKey); err == nil {
+		t.Error("another user should not open the sealed key")
+	}
+
+	// The private key cannot be unwrapped with another master password.
+	wrongKek, err := crypt.DeriveKey("wrong master", stored.KeyDerivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stored.PrivateKey(wrongKek); err == nil {
+		t.Error("the private key should not be unwrapped with a wrong master password")
+	}
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
+)
+
+// hasDatabase tells if the test database has been configured. The tests which need the database are
+// skipped without it.
+var hasDatabase bool
+
+// TestMain connects to the test database, if it's configured in the environment or in the .env file at
+// the root of the repository. The file contents are kept in memory, so that the tests don't leave files
+// on the disk.
+func TestMain(m *testing.M) {
+	godotenv.Load("../.env")
+
+	if os.Getenv("db_host") != "" {
+		if err := ConnectToDatabase(&DatabaseConfig{
+			User: os.Getenv("db_username"),
+			Port: os.Getenv("db_port"),
+			Host: os.Getenv("db_host"),
+			Name: os.Getenv("db_name"),
+		}); err != nil {
+			log.Fatal(err)
+		}
+		hasDatabase = true
+	}
+
+	storage.SetBackend(storage.NewMemory())
+	os.Exit(m.Run())
+}
+
+// requireDatabase skips the test if the test database has not been configured.
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	if !hasDatabase {
+		t.Skip("the test database is not configured")
+	}
+}
+
+// newTestUser creates a user with a unique username, which is removed after the test.
+func newTestUser(t *testing.T) *User {
+	t.Helper()
+	requireDatabase(t)
+
+	user := &User{Username: "test-" + lib.GenerateUUID()[:8], UUID: lib.GenerateUUID()}
+	if err := lib.GetDatabase().Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := user.Delete(); err != nil {
+			t.Error("could not remove the test user, err: ", err)
+		}
+	})
+
+	return user
+}
+
+// newTestFile creates an unencrypted file with the given contents for the user. Files with the same
+// contents share a blob.
+func newTestFile(t *testing.T, user *User, filename, contents string) *File {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(contents))
+	file := &File{
+		Filename:      filename,
+		Extension:     filepath.Ext(filename),
+		UUID:          lib.GenerateUUID(),
+		UserID:        user.ID,
+		Size:          int64(len(contents)),
+		Hash:          hex.EncodeToString(sum[:]),
+		ShareableFile: true,
+	}
+
+	key := file.StorageKey(user.UUID)
+	if _, err := storage.GetBackend().Put(key, strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateBlobFile(file, key); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
@@ -0,0 +1,224 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// ErrRekeyIncomplete is returned when committing a master password change, while some of the user's
+// encrypted files or uploads don't have a data key wrapped with the new master password.
+var ErrRekeyIncomplete = errors.New("some files have not been re-keyed")
+
+// MasterKeyChange records a master password change which is in progress. The data keys are wrapped with
+// the new master password one file at a time into FileKey.PendingWrappedKey, and once all of the files
+// are done, the new keys are taken into use in a single transaction. If the change is interrupted, the
+// old master password keeps working and the change can be resumed.
+type MasterKeyChange struct {
+	gorm.Model
+	UserID        uint   `gorm:"uniqueIndex"`
+	MasterHash    string // The hash of the new master password.
+	KeyDerivation []byte // The key derivation record for the new master password.
+
+	// WrappedPrivateKey holds the user's private key wrapped with the new master password.
+	WrappedPrivateKey []byte
+}
+
+// FindMasterKeyChange returns the master password change of the user, which is in progress.
+func FindMasterKeyChange(userID uint) (*MasterKeyChange, error) {
+	db := lib.GetDatabase()
+
+	var job MasterKeyChange
+	if err := db.Where(&MasterKeyChange{UserID: userID}).First(&job).Error; err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// StartMasterKeyChange starts changing the user's master password. If there already is a change in
+// progress to the same new master password, it's resumed. Otherwise the earlier change is discarded
+// and a new one is started.
+func StartMasterKeyChange(user *User, newMaster string) (*MasterKeyChange, error) {
+	db := lib.GetDatabase()
+
+	job, err := FindMasterKeyChange(user.ID)
+	if err == nil && lib.CheckPasswordHash(newMaster, job.MasterHash) {
+		return job, nil
+	}
+
+	masterHash, err := lib.HashPassword(newMaster)
+	if err != nil {
+		return nil, err
+	}
+
+	derivation, err := crypt.NewKeyDerivation()
+	if err != nil {
+		return nil, err
+	}
+
+	job = &MasterKeyChange{
+		UserID:        user.ID,
+		MasterHash:    masterHash,
+		KeyDerivation: derivation,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where(&MasterKeyChange{UserID: user.ID}).Delete(&MasterKeyChange{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&FileKey{}).Where("file_id IN (?)", encryptedFileIDs(tx, user.ID)).
+			Update("pending_wrapped_key", nil).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Upload{}).Where("user_id = ?", user.ID).
+			Update("pending_wrapped_key", nil).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(job).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// MasterKey derives the new key encryption key from the new master password.
+func (job *MasterKeyChange) MasterKey(newMaster string) ([]byte, error) {
+	return crypt.DeriveKey(newMaster, job.KeyDerivation)
+}
+
+// Rewrap wraps the data key of the file with the new key encryption key. Files which already have a
+// pending key are skipped, so that a resumed change doesn't need to redo them.
+func (job *MasterKeyChange) Rewrap(file *File, kek, newKek []byte) error {
+	db := lib.GetDatabase()
+
+	var fileKey FileKey
+	if err := db.Where(&FileKey{FileID: file.ID}).First(&fileKey).Error; err != nil {
+		return err
+	}
+
+	if len(fileKey.PendingWrappedKey) != 0 {
+		return nil
+	}
+
+	dataKey, err := crypt.UnwrapKey(kek, fileKey.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	pending, err := crypt.WrapKey(newKek, dataKey)
+	if err != nil {
+		return err
+	}
+
+	return db.Model(&fileKey).Update("pending_wrapped_key", pending).Error
+}
+
+// RewrapUpload wraps the data key of an encrypted upload, which is still in progress, with the new key
+// encryption key, so that the upload can be resumed with the new master password.
+func (job *MasterKeyChange) RewrapUpload(upload *Upload, kek, newKek []byte) error {
+	if len(upload.PendingWrappedKey) != 0 {
+		return nil
+	}
+
+	dataKey, err := crypt.UnwrapKey(kek, upload.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	pending, err := crypt.WrapKey(newKek, dataKey)
+	if err != nil {
+		return err
+	}
+
+	return lib.GetDatabase().Model(upload).Update("pending_wrapped_key", pending).Error
+}
+
+// Commit takes the new master password and the pending data keys into use in a single transaction. If
+// some of the user's encrypted files or uploads don't have a pending key, ErrRekeyIncomplete is returned
+// and nothing is changed.
+func (job *MasterKeyChange) Commit() error {
+	db := lib.GetDatabase()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var missing int64
+		err := tx.Unscoped().Model(&File{}).Where("user_id = ? AND shareable_file = ?", job.UserID, false).
+			Where("id NOT IN (?)", tx.Model(&FileKey{}).Select("file_id").Where("pending_wrapped_key IS NOT NULL")).
+			Count(&missing).Error
+		if err != nil {
+			return err
+		}
+
+		if missing != 0 {
+			return ErrRekeyIncomplete
+		}
+
+		err = tx.Model(&Upload{}).Where("user_id = ? AND wrapped_key IS NOT NULL AND pending_wrapped_key IS NULL",
+			job.UserID).Count(&missing).Error
+		if err != nil {
+			return err
+		}
+
+		if missing != 0 {
+			return ErrRekeyIncomplete
+		}
+
+		err = tx.Model(&Upload{}).Where("user_id = ? AND wrapped_key IS NOT NULL", job.UserID).
+			Updates(map[string]interface{}{
+				"wrapped_key":         gorm.Expr("pending_wrapped_key"),
+				"pending_wrapped_key": nil,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&FileKey{}).Where("file_id IN (?)", encryptedFileIDs(tx, job.UserID)).
+			Updates(map[string]interface{}{
+				"wrapped_key":         gorm.Expr("pending_wrapped_key"),
+				"pending_wrapped_key": nil,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := job.commitPrivateKey(tx); err != nil {
+			return err
+		}
+
+		err = tx.Model(&User{}).Where("id = ?", job.UserID).Updates(map[string]interface{}{
+			"file_encryption_master": job.MasterHash,
+			"key_derivation":         job.KeyDerivation,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(job).Error
+	})
+}
+
+// EncryptedFiles returns all of the user's files, which are encrypted. The files in the trash are included,
+// since they can still be restored.
+func (user *User) EncryptedFiles() ([]File, error) {
+	db := lib.GetDatabase()
+
+	var files []File
+	if err := db.Unscoped().Where("user_id = ? AND shareable_file = ?", user.ID, false).Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// encryptedFileIDs returns a subquery which selects the ids of the user's encrypted files, including the
+// files in the trash.
+func encryptedFileIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Unscoped().Model(&File{}).Select("id").Where("user_id = ? AND shareable_file = ?", userID, false)
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// newTestEncryptedFile creates the database entry of an encrypted file, whose data key is wrapped with the
+// key encryption key. The data key is returned along with the file.
+func newTestEncryptedFile(t *testing.T, user *User, filename string, kek []byte) (*File, []byte) {
+	t.Helper()
+
+	dataKey, err := crypt.GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &File{Filename: filename, UUID: lib.GenerateUUID(), UserID: user.ID, Size: 10}
+	if err := CreateEncryptedFile(file, kek, dataKey); err != nil {
+		t.Fatal(err)
+	}
+
+	return file, dataKey
+}
+
+// pendingKey returns the pending data key of the file.
+func pendingKey(t *testing.T, file *File) []byte {
+	t.Helper()
+
+	var fileKey FileKey
+	if err := lib.GetDatabase().Where("file_id = ?", file.ID).First(&fileKey).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return fileKey.PendingWrappedKey
+}
+
+func TestMasterKeyChange(t *testing.T) {
+	user := newTestUser(t)
+
+	kek, err := user.MasterKey("old master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := user.PrivateKey(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, firstKey := newTestEncryptedFile(t, user, "first.txt", kek)
+	second, secondKey := newTestEncryptedFile(t, user, "second.txt", kek)
+
+	upload := &Upload{UUID: lib.GenerateUUID(), UserID: user.ID, Filename: "upload.txt", Length: 10}
+	if upload.WrappedKey, err = crypt.WrapKey(kek, firstKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.GetDatabase().Create(upload).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := StartMasterKeyChange(user, "new master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKek, err := job.MasterKey("new master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.SetPrivateKey(newKek, privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.Rewrap(first, kek, newKek); err != nil {
+		t.Fatal(err)
+	}
+
+	// The change is interrupted before the second file and the upload have been re-keyed, so nothing is
+	// changed yet and the old master password keeps working.
+	if err := job.Commit(); err != ErrRekeyIncomplete {
+		t.Fatalf("expected an incomplete change, got: %v", err)
+	}
+
+	if dataKey, err := second.DataKey(kek); err != nil || !bytes.Equal(dataKey, secondKey) {
+		t.Fatalf("the old master password should still open the file, err: %v", err)
+	}
+
+	// Resuming the change to the same master password keeps the keys which were already wrapped.
+	pending := pendingKey(t, first)
+	resumed, err := StartMasterKeyChange(user, "new master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.ID != job.ID {
+		t.Fatal("the change should have been resumed")
+	}
+
+	if err := resumed.Rewrap(first, kek, newKek); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(pendingKey(t, first), pending) {
+		t.Error("the file which was already re-keyed should have been skipped")
+	}
+
+	if err := resumed.Rewrap(second, kek, newKek); err != nil {
+		t.Fatal(err)
+	}
+
+	// The upload in progress needs to be re-keyed as well.
+	if err := resumed.Commit(); err != ErrRekeyIncomplete {
+		t.Fatalf("expected an incomplete change without the upload, got: %v", err)
+	}
+
+	if err := resumed.RewrapUpload(upload, kek, newKek); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resumed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new master password opens the files, the upload and the private key, and the old one doesn't.
+	changed, err := FindOneUser(&User{UUID: user.UUID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !lib.CheckPasswordHash("new master", changed.FileEncryptionMaster) {
+		t.Error("the new master password should have been taken into use")
+	}
+
+	changedKek, err := changed.MasterKey("new master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		file    *File
+		dataKey []byte
+	}{{first, firstKey}, {second, secondKey}} {
+		if dataKey, err := test.file.DataKey(changedKek); err != nil || !bytes.Equal(dataKey, test.dataKey) {
+			t.Errorf("the new master password should open %s, err: %v", test.file.Filename, err)
+		}
+
+		if _, err := test.file.DataKey(kek); err == nil {
+			t.Errorf("the old master password should not open %s", test.file.Filename)
+		}
+
+		if len(pendingKey(t, test.file)) != 0 {
+			t.Errorf("the pending key of %s should have been cleared", test.file.Filename)
+		}
+	}
+
+	if _, err := changed.PrivateKey(changedKek); err != nil {
+		t.Errorf("the new master password should open the private key, err: %v", err)
+	}
+
+	uploadID := upload.UUID
+	if upload, err = FindUserUpload(user.ID, uploadID); err != nil {
+		t.Fatal(err)
+	}
+
+	if dataKey, err := crypt.UnwrapKey(changedKek, upload.WrappedKey); err != nil || !bytes.Equal(dataKey, firstKey) {
+		t.Errorf("the new master password should open the upload, err: %v", err)
+	}
+
+	if _, err := FindMasterKeyChange(user.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the finished change should have been removed, got: %v", err)
+	}
+}
+
+func TestMasterKeyChangeToAnotherPassword(t *testing.T) {
+	user := newTestUser(t)
+
+	kek, err := user.MasterKey("old master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, _ := newTestEncryptedFile(t, user, "file.txt", kek)
+
+	job, err := StartMasterKeyChange(user, "new master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKek, err := job.MasterKey("new master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.Rewrap(file, kek, newKek); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing to a different master password discards the keys wrapped for the earlier one.
+	other, err := StartMasterKeyChange(user, "other master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.ID == job.ID {
+		t.Error("a new change should have been started")
+	}
+
+	if len(pendingKey(t, file)) != 0 {
+		t.Error("the pending key of the earlier change should have been discarded")
+	}
+
+	if err := other.Commit(); err != ErrRekeyIncomplete {
+		t.Errorf("the change should not be committed without the pending keys, got: %v", err)
+	}
+}
@@ -9,7 +9,32 @@ import (
 // MigrateModels gets run in the main function and it migrates all of the database models
 // to the database. This gets run everytime the service is restarted.
 func MigrateModels(db *gorm.DB) {
-	if err := db.AutoMigrate(&User{}, &File{}, &FileShare{}); err != nil {
+	if err := db.AutoMigrate(&User{}, &File{}, &FileShare{}, &FileKey{}, &MasterKeyChange{}, &APIToken{}, &Session{}, &Upload{}, &Folder{}, &Blob{}, &FileVersion{}, &ShareLink{}, &Group{}, &GroupMembership{}, &GroupShare{}, &Tag{}, &FileTag{}); err != nil {
 		log.Fatal(err)
 	}
+
+	if err := removeDeletedFiles(db); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := recalculateUsage(db); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := clearPlaintextHashes(db); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// clearPlaintextHashes removes the plain SHA-256 hashes of the encrypted files and their versions, which
+// were stored before the hashes were keyed with the data keys. The files are then served without an ETag.
+func clearPlaintextHashes(db *gorm.DB) error {
+	encrypted := db.Unscoped().Model(&File{}).Select("id").Where("shareable_file = ?", false)
+	if err := db.Unscoped().Model(&File{}).Where("id IN (?) AND hash <> '' AND hash NOT LIKE ?", encrypted,
+		KeyedHashPrefix+"%").UpdateColumn("hash", "").Error; err != nil {
+		return err
+	}
+
+	return db.Unscoped().Model(&FileVersion{}).Where("file_id IN (?) AND hash <> '' AND hash NOT LIKE ?", encrypted,
+		KeyedHashPrefix+"%").UpdateColumn("hash", "").Error
 }
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// ErrQuotaExceeded is returned when storing a file would make the user use more than their quota.
+var ErrQuotaExceeded = errors.New("the storage quota would be exceeded")
+
+// DefaultQuota is the quota in bytes of the users, who don't have a quota of their own. Zero means that
+// the amount is not limited.
+var DefaultQuota int64
+
+// LoadDefaultQuota reads the default quota in bytes from the 'default_quota' environment variable. If
+// it's not set, the users can store an unlimited amount of data.
+func LoadDefaultQuota() error {
+	value := os.Getenv("default_quota")
+	if value == "" {
+		return nil
+	}
+
+	quota, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || quota < 0 {
+		return fmt.Errorf("invalid default_quota: %q", value)
+	}
+
+	DefaultQuota = quota
+	return nil
+}
+
+// Quota returns the amount of bytes the user can store. Zero means that the amount is not limited.
+func (user *User) Quota() int64 {
+	if user.QuotaBytes != nil {
+		return *user.QuotaBytes
+	}
+
+	return DefaultQuota
+}
+
+// RemainingQuota returns the amount of bytes the user can still store, or -1 if the amount is not
+// limited.
+func (user *User) RemainingQuota() int64 {
+	quota := user.Quota()
+	if quota == 0 {
+		return -1
+	}
+
+	if user.UsedBytes >= quota {
+		return 0
+	}
+
+	return quota - user.UsedBytes
+}
+
+// SetQuota sets the user's own quota in bytes. If the quota is nil, the default quota is used.
+func (user *User) SetQuota(quota *int64) error {
+	user.QuotaBytes = quota
+	return lib.GetDatabase().Model(user).Update("quota_bytes", quota).Error
+}
+
+// chargeQuota adds the size of a new file to the user's used bytes. The quota is checked in the same
+// statement, so that multiple uploads at the same time cannot exceed it together. If the quota would be
+// exceeded, ErrQuotaExceeded is returned.
+func chargeQuota(tx *gorm.DB, userID uint, size int64) error {
+	res := tx.Exec(`UPDATE users SET used_bytes = used_bytes + ? WHERE id = ? AND
+		(COALESCE(quota_bytes, ?) = 0 OR used_bytes + ? <= COALESCE(quota_bytes, ?))`,
+		size, userID, DefaultQuota, size, DefaultQuota)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// refundQuota removes the size of a removed file from the user's used bytes.
+func refundQuota(tx *gorm.DB, userID uint, size int64) error {
+	return tx.Exec("UPDATE users SET used_bytes = GREATEST(used_bytes - ?, 0) WHERE id = ?", size, userID).Error
+}
+
+// recalculateUsage sets the used bytes of every user to the total size of their files and the files'
+// versions, including the files in the trash. This fills the usage of the files which were uploaded
+// before the usage was tracked.
+func recalculateUsage(db *gorm.DB) error {
+	return db.Exec(`UPDATE users SET used_bytes = (SELECT COALESCE(SUM(size), 0) FROM files
+		WHERE files.user_id = users.id AND (files.deleted_at IS NULL OR files.trashed)) +
+		(SELECT COALESCE(SUM(file_versions.size), 0) FROM file_versions JOIN files ON files.id = file_versions.file_id
+		WHERE files.user_id = users.id AND (files.deleted_at IS NULL OR files.trashed) AND file_versions.deleted_at IS NULL)`).Error
+}
+
+// MIMEUsage is the amount of files and bytes the user has stored of a single mimetype.
+type MIMEUsage struct {
+	MIME  string
+	Files int64
+	Bytes int64
+}
+
+// UsageByMIME returns the user's storage usage grouped by the mimetype of the files. The files in the
+// trash and the earlier versions of the files are counted like in the used bytes, so that the groups add
+// up to the used bytes. The mimetypes using the most space come first.
+func (user *User) UsageByMIME() ([]MIMEUsage, error) {
+	db := lib.GetDatabase()
+
+	var usage []MIMEUsage
+	if err := db.Raw(`SELECT files.mime, COUNT(*) AS files, COALESCE(SUM(files.size), 0) + COALESCE(SUM(versions.size), 0) AS bytes
+		FROM files LEFT JOIN (SELECT file_id, SUM(size) AS size FROM file_versions WHERE deleted_at IS NULL GROUP BY file_id) versions
+		ON versions.file_id = files.id WHERE files.user_id = ? AND (files.deleted_at IS NULL OR files.trashed)
+		GROUP BY files.mime ORDER BY bytes DESC`, user.ID).Scan(&usage).Error; err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/nireo/upfi/lib"
+)
+
+func TestRemainingQuota(t *testing.T) {
+	defaultQuota := DefaultQuota
+	defer func() { DefaultQuota = defaultQuota }()
+
+	own := int64(100)
+	tests := []struct {
+		defaultQuota int64
+		quota        *int64
+		used         int64
+		remaining    int64
+	}{
+		{0, nil, 500, -1},
+		{1000, nil, 400, 600},
+		{1000, &own, 40, 60},
+		{1000, &own, 150, 0},
+	}
+
+	for _, test := range tests {
+		DefaultQuota = test.defaultQuota
+		user := &User{QuotaBytes: test.quota, UsedBytes: test.used}
+
+		if remaining := user.RemainingQuota(); remaining != test.remaining {
+			t.Errorf("wrong remaining quota for %+v. want=%d, got=%d", test, test.remaining, remaining)
+		}
+	}
+}
+
+func TestQuotaIsEnforced(t *testing.T) {
+	user := newTestUser(t)
+
+	quota := int64(20)
+	if err := user.SetQuota(&quota); err != nil {
+		t.Fatal(err)
+	}
+
+	file := newTestFile(t, user, "first.txt", "fifteen bytes..")
+
+	// The second file would exceed the quota, so it's not created.
+	second := &File{UUID: lib.GenerateUUID(), UserID: user.ID, Size: 15, Hash: file.Hash, ShareableFile: true}
+	if err := CreateBlobFile(second, second.StorageKey(user.UUID)); err != ErrQuotaExceeded {
+		t.Errorf("expected the quota to be exceeded, got: %v", err)
+	}
+
+	if err := lib.GetDatabase().First(user, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if user.UsedBytes != 15 {
+		t.Errorf("wrong used bytes after the rejected file. want=15, got=%d", user.UsedBytes)
+	}
+
+	// Removing the file gives the space back.
+	if err := file.Delete(user.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.GetDatabase().First(user, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if user.UsedBytes != 0 {
+		t.Errorf("wrong used bytes after removing the file. want=0, got=%d", user.UsedBytes)
+	}
+}
+
+func TestUsageByMIMEAddsUpToUsedBytes(t *testing.T) {
+	user := newTestUser(t)
+	db := lib.GetDatabase()
+
+	text := newTestFile(t, user, "notes.txt", "some notes")
+	image := newTestFile(t, user, "image.png", "not really an image")
+	if err := db.Model(text).UpdateColumn("mime", "text/plain").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(image).UpdateColumn("mime", "image/png").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The earlier versions and the files in the trash use the quota as well.
+	version := &FileVersion{UUID: lib.GenerateUUID(), FileID: text.ID, Size: 100, MIME: "text/plain"}
+	if err := db.Create(version).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := image.Trash(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recalculateUsage(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.First(user, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := user.UsageByMIME()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	bytes := make(map[string]int64)
+	for _, mime := range usage {
+		total += mime.Bytes
+		bytes[mime.MIME] = mime.Bytes
+	}
+
+	if total != user.UsedBytes {
+		t.Errorf("the usage should add up to the used bytes. want=%d, got=%d", user.UsedBytes, total)
+	}
+
+	if bytes["text/plain"] != text.Size+version.Size || bytes["image/png"] != image.Size {
+		t.Errorf("wrong usage by mimetype: %+v", usage)
+	}
+
+	if len(usage) == 0 || usage[0].MIME != "text/plain" {
+		t.Errorf("the mimetype using the most space should come first: %+v", usage)
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// Session is a login session of a user. The session's token id is stored in the jwt token given to the
+// user, and the token is accepted only as long as the session exists. This way the sessions can be
+// revoked before the token expires.
+type Session struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenID   string `gorm:"uniqueIndex"`
+	UserAgent string
+	ExpiresAt time.Time
+}
+
+// CreateSession creates a new session for the user, which expires at the same time as the token. The
+// user's expired sessions are removed at the same time.
+func CreateSession(user *User, userAgent string) (*Session, error) {
+	db := lib.GetDatabase()
+
+	db.Unscoped().Where("user_id = ? AND expires_at < ?", user.ID, time.Now()).Delete(&Session{})
+
+	// Don't store overly long user agents.
+	if len(userAgent) > 256 {
+		userAgent = userAgent[:256]
+	}
+
+	session := &Session{
+		UserID:    user.ID,
+		TokenID:   lib.GenerateUUID(),
+		UserAgent: userAgent,
+		ExpiresAt: time.Now().Add(lib.TokenLifetime),
+	}
+
+	if err := db.Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// FindSession finds the session with the given token id, if it hasn't expired.
+func FindSession(tokenID string) (*Session, error) {
+	db := lib.GetDatabase()
+
+	var session Session
+	if err := db.Where("token_id = ? AND expires_at > ?", tokenID, time.Now()).First(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RevokeSession removes the session with the given token id, after which its token is not accepted.
+func RevokeSession(tokenID string) error {
+	db := lib.GetDatabase()
+	return db.Unscoped().Where(&Session{TokenID: tokenID}).Delete(&Session{}).Error
+}
+
+// FindSessions returns all of the user's active sessions.
+func (user *User) FindSessions() ([]Session, error) {
+	db := lib.GetDatabase()
+
+	var sessions []Session
+	if err := db.Where("user_id = ? AND expires_at > ?", user.ID, time.Now()).
+		Order("created_at desc").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RevokeUserSession removes one of the user's sessions.
+func (user *User) RevokeUserSession(id uint) error {
+	db := lib.GetDatabase()
+
+	result := db.Unscoped().Where("id = ? AND user_id = ?", id, user.ID).Delete(&Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RevokeSessions removes all of the user's sessions except the session with the given token id, which
+// can be left empty to remove all of the sessions.
+func (user *User) RevokeSessions(exceptTokenID string) error {
+	db := lib.GetDatabase()
+	return db.Unscoped().Where("user_id = ? AND token_id <> ?", user.ID, exceptTokenID).Delete(&Session{}).Error
+}
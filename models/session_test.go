@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+func TestFindSession(t *testing.T) {
+	user := newTestUser(t)
+
+	session, err := CreateSession(user, "test agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := FindSession(session.TokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.ID != session.ID || found.UserID != user.ID {
+		t.Errorf("wrong session found: %+v", found)
+	}
+
+	// The expired sessions are not found.
+	if err := lib.GetDatabase().Model(session).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindSession(session.TokenID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the expired session should not be found, got: %v", err)
+	}
+
+	sessions, err := user.FindSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 0 {
+		t.Errorf("the expired session should not be listed, got %d sessions", len(sessions))
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	user := newTestUser(t)
+	other := newTestUser(t)
+
+	sessions := make([]*Session, 3)
+	for i := range sessions {
+		session, err := CreateSession(user, "test agent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[i] = session
+	}
+
+	otherSession, err := CreateSession(other, "test agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RevokeSession(sessions[0].TokenID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindSession(sessions[0].TokenID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the revoked session should not be found, got: %v", err)
+	}
+
+	// The current session is kept when the other sessions are revoked.
+	if err := user.RevokeSessions(sessions[1].TokenID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindSession(sessions[1].TokenID); err != nil {
+		t.Errorf("the current session should have been kept, err: %v", err)
+	}
+
+	if _, err := FindSession(sessions[2].TokenID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the other session should have been revoked, got: %v", err)
+	}
+
+	// An empty token id revokes all of the sessions, but only the user's own.
+	if err := user.RevokeSessions(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindSession(sessions[1].TokenID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("all of the sessions should have been revoked, got: %v", err)
+	}
+
+	if _, err := FindSession(otherSession.TokenID); err != nil {
+		t.Errorf("the sessions of the other users should be kept, err: %v", err)
+	}
+
+	// The users cannot revoke the sessions of the other users by their id.
+	if err := user.RevokeUserSession(otherSession.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("another user's session should not be found, got: %v", err)
+	}
+}
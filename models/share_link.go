@@ -0,0 +1,152 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrLinkExpired is returned when a share link is used after its expiration time.
+	ErrLinkExpired = errors.New("the share link has expired")
+
+	// ErrLinkExhausted is returned when a share link is used after it has reached its download limit.
+	ErrLinkExhausted = errors.New("the share link has reached its download limit")
+)
+
+// ShareLink lets anyone who knows the token download an unencrypted file without an account. The link
+// can be protected with a password, and it can have an expiration time and a download limit.
+type ShareLink struct {
+	gorm.Model
+	Token        string `gorm:"uniqueIndex"`
+	FileID       uint   `gorm:"index"`
+	PasswordHash string // empty if the link doesn't have a password
+	ExpiresAt    *time.Time
+	MaxDownloads int // zero if the downloads are not limited
+	Downloads    int
+}
+
+// CreateShareLink creates a new share link with a random token for the file. An empty password means
+// that the link doesn't need a password.
+func CreateShareLink(file *File, password string, expiresAt *time.Time, maxDownloads int) (*ShareLink, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	link := &ShareLink{
+		Token:        base64.RawURLEncoding.EncodeToString(random),
+		FileID:       file.ID,
+		ExpiresAt:    expiresAt,
+		MaxDownloads: maxDownloads,
+	}
+
+	if password != "" {
+		hash, err := lib.HashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		link.PasswordHash = hash
+	}
+
+	if err := lib.GetDatabase().Create(link).Error; err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// FindShareLink finds the share link with the given token.
+func FindShareLink(token string) (*ShareLink, error) {
+	db := lib.GetDatabase()
+
+	var link ShareLink
+	if err := db.Where("token = ?", token).First(&link).Error; err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// Expired checks if the link has an expiration time, which has passed.
+func (link *ShareLink) Expired() bool {
+	return link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt)
+}
+
+// Exhausted checks if the link has a download limit, which has been reached.
+func (link *ShareLink) Exhausted() bool {
+	return link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads
+}
+
+// Available returns ErrLinkExpired or ErrLinkExhausted if the link cannot be used anymore.
+func (link *ShareLink) Available() error {
+	if link.Expired() {
+		return ErrLinkExpired
+	}
+
+	if link.Exhausted() {
+		return ErrLinkExhausted
+	}
+
+	return nil
+}
+
+// HasPassword checks if the link is protected with a password.
+func (link *ShareLink) HasPassword() bool {
+	return link.PasswordHash != ""
+}
+
+// CheckPassword checks the password of the link. Links without a password accept any password.
+func (link *ShareLink) CheckPassword(password string) bool {
+	return !link.HasPassword() || lib.CheckPasswordHash(password, link.PasswordHash)
+}
+
+// CountDownload adds a download to the link. The limit is checked in the same statement, so that
+// downloads at the same time cannot exceed it together. If the limit has been reached, ErrLinkExhausted
+// is returned.
+func (link *ShareLink) CountDownload() error {
+	res := lib.GetDatabase().Model(link).
+		Where("max_downloads = 0 OR downloads < max_downloads").
+		Update("downloads", gorm.Expr("downloads + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return ErrLinkExhausted
+	}
+
+	return nil
+}
+
+// FindShareLinks returns the share links of the file, the newest first.
+func (file *File) FindShareLinks() ([]ShareLink, error) {
+	db := lib.GetDatabase()
+
+	var links []ShareLink
+	if err := db.Where(&ShareLink{FileID: file.ID}).Order("created_at DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// DeleteShareLink removes the file's share link with the given token for good.
+func (file *File) DeleteShareLink(token string) error {
+	db := lib.GetDatabase()
+
+	result := db.Unscoped().Where("file_id = ? AND token = ?", file.ID, token).Delete(&ShareLink{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+)
+
+func TestShareLinkAvailable(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		link ShareLink
+		err  error
+	}{
+		{ShareLink{}, nil},
+		{ShareLink{ExpiresAt: &future, MaxDownloads: 3, Downloads: 2}, nil},
+		{ShareLink{ExpiresAt: &past}, ErrLinkExpired},
+		{ShareLink{MaxDownloads: 3, Downloads: 3}, ErrLinkExhausted},
+	}
+
+	for _, test := range tests {
+		if err := test.link.Available(); err != test.err {
+			t.Errorf("wrong availability for %+v. want=%v, got=%v", test.link, test.err, err)
+		}
+	}
+}
+
+func TestShareLinkPassword(t *testing.T) {
+	link := &ShareLink{}
+	if !link.CheckPassword("anything") {
+		t.Error("a link without a password should accept any password")
+	}
+
+	hash, err := lib.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link.PasswordHash = hash
+
+	if !link.CheckPassword("secret") || link.CheckPassword("wrong") || link.CheckPassword("") {
+		t.Error("the link should only accept its own password")
+	}
+}
+
+func TestCountDownload(t *testing.T) {
+	user := newTestUser(t)
+	file := newTestFile(t, user, "linked.txt", "linked contents")
+
+	link, err := CreateShareLink(file, "", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := link.CountDownload(); err != nil {
+			t.Fatalf("download %d should be allowed, err: %v", i+1, err)
+		}
+	}
+
+	if err := link.CountDownload(); err != ErrLinkExhausted {
+		t.Errorf("expected the link to be exhausted, got: %v", err)
+	}
+
+	// An empty token doesn't remove the other links of the file.
+	if err := file.DeleteShareLink(""); err == nil {
+		t.Error("expected an error for an empty token")
+	}
+
+	if _, err := FindShareLink(link.Token); err != nil {
+		t.Errorf("the link should still exist, err: %v", err)
+	}
+}
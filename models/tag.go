@@ -0,0 +1,244 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxTagLength is the longest name a tag can have.
+	MaxTagLength = 32
+
+	// MaxFileTags is the largest number of tags a file can have.
+	MaxFileTags = 20
+)
+
+var (
+	// ErrInvalidTag is returned when a tag name is empty, too long or contains a comma, or when a file
+	// would get too many tags.
+	ErrInvalidTag = errors.New("invalid tag")
+
+	// ErrTagExists is returned when a tag is renamed to the name of another tag of the user.
+	ErrTagExists = errors.New("the tag already exists")
+)
+
+// Tag is a label, which the user can add to their files. The tags belong to the owner of the files, so the
+// recipients with the edit permission use the owner's tags. The tags are removed for good, so that their
+// names can be used again.
+type Tag struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"uniqueIndex:idx_tags_user_name"`
+	Name      string `gorm:"uniqueIndex:idx_tags_user_name"`
+}
+
+// FileTag adds the tag to the file.
+type FileTag struct {
+	FileID uint `gorm:"primaryKey"`
+	TagID  uint `gorm:"primaryKey;index"`
+}
+
+// TagCount is a tag along with the number of files it has, which are not in the trash.
+type TagCount struct {
+	Name  string
+	Files int64
+}
+
+// NormalizeTags trims the tag names and removes the empty names and the duplicates. An error is returned
+// if a name is not valid or there are too many names.
+func NormalizeTags(names []string) ([]string, error) {
+	normalized := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+
+		if len(name) > MaxTagLength || strings.Contains(name, ",") {
+			return nil, ErrInvalidTag
+		}
+
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+
+	if len(normalized) > MaxFileTags {
+		return nil, ErrInvalidTag
+	}
+
+	return normalized, nil
+}
+
+// SetTags replaces the tags of the file with the given names, which are normalized. The tags which the
+// owner doesn't have yet are created, and the tags left without files are removed.
+func (file *File) SetTags(names []string) error {
+	names, err := NormalizeTags(names)
+	if err != nil {
+		return err
+	}
+
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&FileTag{FileID: file.ID}).Delete(&FileTag{}).Error; err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			tag := Tag{UserID: file.UserID, Name: name}
+			if err := tx.Where(&tag).FirstOrCreate(&tag).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Create(&FileTag{FileID: file.ID, TagID: tag.ID}).Error; err != nil {
+				return err
+			}
+		}
+
+		return deleteUnusedTags(tx, file.UserID)
+	})
+}
+
+// FindTags returns the names of the file's tags in alphabetical order.
+func (file *File) FindTags() ([]string, error) {
+	tags, err := FindFileTags([]File{*file})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags[file.ID], nil
+}
+
+// FindFileTags returns the names of the tags of the given files, in alphabetical order, by the ids of the
+// files.
+func FindFileTags(files []File) (map[uint][]string, error) {
+	tags := make(map[uint][]string)
+	if len(files) == 0 {
+		return tags, nil
+	}
+
+	ids := make([]uint, 0, len(files))
+	for i := range files {
+		ids = append(ids, files[i].ID)
+	}
+
+	var rows []struct {
+		FileID uint
+		Name   string
+	}
+	if err := lib.GetDatabase().Model(&FileTag{}).Select("file_tags.file_id, tags.name").
+		Joins("JOIN tags ON tags.id = file_tags.tag_id").Where("file_tags.file_id IN ?", ids).
+		Order("tags.name").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		tags[row.FileID] = append(tags[row.FileID], row.Name)
+	}
+
+	return tags, nil
+}
+
+// FindTags returns the user's tags in alphabetical order along with the number of their files.
+func (user *User) FindTags() ([]TagCount, error) {
+	var tags []TagCount
+	err := lib.GetDatabase().Model(&Tag{}).Select("tags.name, COUNT(files.id) AS files").
+		Joins("LEFT JOIN file_tags ON file_tags.tag_id = tags.id").
+		Joins("LEFT JOIN files ON files.id = file_tags.file_id AND files.deleted_at IS NULL").
+		Where("tags.user_id = ?", user.ID).Group("tags.id, tags.name").Order("tags.name").
+		Scan(&tags).Error
+
+	return tags, err
+}
+
+// findTag finds the user's tag with the given name.
+func findTag(tx *gorm.DB, userID uint, name string) (*Tag, error) {
+	var tag Tag
+	if err := tx.Where("user_id = ? AND name = ?", userID, name).First(&tag).Error; err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// RenameTag renames the user's tag on all of their files. ErrTagExists is returned if the user already has
+// a tag with the new name, in which case the tags can be merged instead.
+func (user *User) RenameTag(name, newName string) error {
+	names, err := NormalizeTags([]string{newName})
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return ErrInvalidTag
+	}
+
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		tag, err := findTag(tx, user.ID, name)
+		if err != nil {
+			return err
+		}
+
+		if tag.Name == names[0] {
+			return nil
+		}
+
+		if _, err := findTag(tx, user.ID, names[0]); err == nil {
+			return ErrTagExists
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		return tx.Model(tag).Update("name", names[0]).Error
+	})
+}
+
+// MergeTags moves the files of the user's tag with the given name to the tag named into, and removes the
+// merged tag. The files which already have both of the tags keep only one.
+func (user *User) MergeTags(name, into string) error {
+	return lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		source, err := findTag(tx, user.ID, name)
+		if err != nil {
+			return err
+		}
+
+		target, err := findTag(tx, user.ID, into)
+		if err != nil {
+			return err
+		}
+
+		if source.ID == target.ID {
+			return nil
+		}
+
+		if err := tx.Exec("INSERT INTO file_tags (file_id, tag_id) SELECT file_id, ? FROM file_tags WHERE tag_id = ? ON CONFLICT DO NOTHING",
+			target.ID, source.ID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where(&FileTag{TagID: source.ID}).Delete(&FileTag{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(source).Error
+	})
+}
+
+// deleteUnusedTags removes the user's tags which are not on any file. The files in the trash keep their
+// tags, so that they are restored with them.
+func deleteUnusedTags(tx *gorm.DB, userID uint) error {
+	return tx.Where("user_id = ? AND id NOT IN (?)", userID, tx.Model(&FileTag{}).Select("tag_id")).
+		Delete(&Tag{}).Error
+}
+
+// deleteFileTags removes the tags of the file, and the owner's tags which were only on the file.
+func deleteFileTags(tx *gorm.DB, file *File) error {
+	if err := tx.Where(&FileTag{FileID: file.ID}).Delete(&FileTag{}).Error; err != nil {
+		return err
+	}
+
+	return deleteUnusedTags(tx, file.UserID)
+}
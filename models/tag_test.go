@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	names, err := NormalizeTags([]string{" work ", "", "notes", "work", "   "})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(names, ",") != "work,notes" {
+		t.Errorf("wrong tags. want=%q, got=%q", "work,notes", names)
+	}
+
+	tooMany := make([]string, MaxFileTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag-%d", i)
+	}
+
+	invalid := [][]string{
+		{strings.Repeat("a", MaxTagLength+1)},
+		{"a,b"},
+		tooMany,
+	}
+	for _, names := range invalid {
+		if _, err := NormalizeTags(names); err != ErrInvalidTag {
+			t.Errorf("expected an invalid tag for %d names, got: %v", len(names), err)
+		}
+	}
+
+	// The duplicates don't count towards the limit.
+	if _, err := NormalizeTags(append(tooMany[:MaxFileTags], tooMany[0])); err != nil {
+		t.Errorf("the duplicate should have been removed, got: %v", err)
+	}
+}
+
+func TestFileTags(t *testing.T) {
+	user := newTestUser(t)
+	first := newTestFile(t, user, "first.txt", "first")
+	second := newTestFile(t, user, "second.txt", "second")
+
+	if err := first.SetTags([]string{"work", "notes"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.SetTags([]string{"work"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := first.FindTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(tags, ",") != "notes,work" {
+		t.Errorf("the tags should be in alphabetical order, got: %v", tags)
+	}
+
+	// The tag left without files is removed.
+	if err := first.SetTags(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := user.FindTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts) != 1 || counts[0].Name != "work" || counts[0].Files != 1 {
+		t.Errorf("wrong tags for the user: %+v", counts)
+	}
+}
+
+func TestRenameAndMergeTags(t *testing.T) {
+	user := newTestUser(t)
+	first := newTestFile(t, user, "first.txt", "first")
+	second := newTestFile(t, user, "second.txt", "second")
+
+	if err := first.SetTags([]string{"work", "job"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.SetTags([]string{"job"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.RenameTag("work", "job"); err != ErrTagExists {
+		t.Errorf("expected the tag to exist, got: %v", err)
+	}
+
+	if err := user.RenameTag("work", " "); err != ErrInvalidTag {
+		t.Errorf("expected an invalid tag for an empty name, got: %v", err)
+	}
+
+	if err := user.RenameTag("", "office"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("an empty name should not match a tag, got: %v", err)
+	}
+
+	if err := user.RenameTag("work", "office"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file with both of the tags keeps only one of them.
+	if err := user.MergeTags("office", "job"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []*File{first, second} {
+		tags, err := file.FindTags()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Join(tags, ",") != "job" {
+			t.Errorf("wrong tags for %s after the merge: %v", file.Filename, tags)
+		}
+	}
+
+	if _, err := findTag(lib.GetDatabase(), user.ID, "office"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the merged tag should have been removed, got: %v", err)
+	}
+}
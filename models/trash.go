@@ -0,0 +1,164 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+// TrashDays is the number of days the files are kept in the trash before they are purged. Zero means
+// that the files are kept until the trash is emptied.
+var TrashDays = 30
+
+// LoadTrashDays reads the number of days the files are kept in the trash from the 'trash_days'
+// environment variable. If it's not set, the default is used.
+func LoadTrashDays() error {
+	value := os.Getenv("trash_days")
+	if value == "" {
+		return nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return fmt.Errorf("invalid trash_days: %q", value)
+	}
+
+	TrashDays = days
+	return nil
+}
+
+// Trash moves the file into the trash. The trashed files are soft deleted, so they are hidden everywhere
+// else. The contents are kept until the file is removed from the trash, so they still count towards the
+// owner's quota.
+func (file *File) Trash() error {
+	now := time.Now()
+	if err := lib.GetDatabase().Model(file).Updates(map[string]interface{}{
+		"trashed":    true,
+		"deleted_at": now,
+	}).Error; err != nil {
+		return err
+	}
+
+	file.Trashed = true
+	file.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+	return nil
+}
+
+// Restore moves the file back from the trash. If the file's folder has been removed in the meantime, the
+// file is restored at the top level.
+func (file *File) Restore() error {
+	db := lib.GetDatabase()
+
+	if file.FolderID != nil {
+		if err := db.First(&Folder{}, *file.FolderID).Error; err != nil {
+			file.FolderID = nil
+		}
+	}
+
+	if err := db.Unscoped().Model(file).Updates(map[string]interface{}{
+		"trashed":    false,
+		"deleted_at": nil,
+		"folder_id":  file.FolderID,
+	}).Error; err != nil {
+		return err
+	}
+
+	file.Trashed = false
+	file.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+// PurgeAt returns the time when the trashed file is purged, or the zero time if the files are kept
+// until the trash is emptied.
+func (file *File) PurgeAt() time.Time {
+	if TrashDays == 0 {
+		return time.Time{}
+	}
+
+	return file.DeletedAt.Time.Add(time.Duration(TrashDays) * 24 * time.Hour)
+}
+
+// FindTrash returns the files in the user's trash, the latest trashed first.
+func (user *User) FindTrash() ([]File, error) {
+	db := lib.GetDatabase()
+
+	var files []File
+	if err := db.Unscoped().Where("user_id = ? AND trashed", user.ID).Order("deleted_at DESC").
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// FindTrashedFile returns the file with the given uuid from the user's trash.
+func (user *User) FindTrashedFile(fileID string) (*File, error) {
+	db := lib.GetDatabase()
+
+	var file File
+	if err := db.Unscoped().Where("user_id = ? AND uuid = ? AND trashed", user.ID, fileID).
+		First(&file).Error; err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// EmptyTrash removes the files in the user's trash for good.
+func (user *User) EmptyTrash() error {
+	files, err := user.FindTrash()
+	if err != nil {
+		return err
+	}
+
+	for i := range files {
+		if err := files[i].Delete(user.UUID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PurgeTrash removes the files which have been in the trash for longer than TrashDays for good. The files
+// which cannot be removed are logged and skipped.
+func PurgeTrash() error {
+	if TrashDays == 0 {
+		return nil
+	}
+
+	db := lib.GetDatabase()
+	before := time.Now().Add(-time.Duration(TrashDays) * 24 * time.Hour)
+
+	var files []File
+	if err := db.Unscoped().Where("trashed AND deleted_at < ?", before).Find(&files).Error; err != nil {
+		return err
+	}
+
+	// A file which cannot be removed is left for the next run, so that it doesn't keep the other files in
+	// the trash.
+	for i := range files {
+		owner, err := FindOneUser(&User{Model: gorm.Model{ID: files[i].UserID}})
+		if err != nil {
+			log.Printf("purging file %s: finding the owner: %v", files[i].UUID, err)
+			continue
+		}
+
+		if err := files[i].Delete(owner.UUID); err != nil {
+			log.Printf("purging file %s: %v", files[i].UUID, err)
+		}
+	}
+
+	return nil
+}
+
+// removeDeletedFiles removes the database entries of the files which were soft deleted before the trash
+// existed. Their contents have already been removed.
+func removeDeletedFiles(db *gorm.DB) error {
+	return db.Unscoped().Where("deleted_at IS NOT NULL AND trashed IS NOT TRUE").Delete(&File{}).Error
+}
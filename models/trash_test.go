@@ -0,0 +1,152 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"gorm.io/gorm"
+)
+
+func TestPurgeAt(t *testing.T) {
+	days := TrashDays
+	defer func() { TrashDays = days }()
+
+	trashed := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	file := &File{}
+	file.DeletedAt = gorm.DeletedAt{Time: trashed, Valid: true}
+
+	TrashDays = 30
+	if want := trashed.Add(30 * 24 * time.Hour); !file.PurgeAt().Equal(want) {
+		t.Errorf("wrong purge time. want=%v, got=%v", want, file.PurgeAt())
+	}
+
+	TrashDays = 0
+	if !file.PurgeAt().IsZero() {
+		t.Errorf("the files should not be purged when the trash days are zero, got: %v", file.PurgeAt())
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	user := newTestUser(t)
+	file := newTestFile(t, user, "trashed.txt", "contents")
+
+	if err := file.Trash(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindOneFile(&File{UUID: file.UUID}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the trashed file should not be found, got: %v", err)
+	}
+
+	trashed, err := user.FindTrashedFile(file.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := trashed.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindOneFile(&File{UUID: file.UUID}); err != nil {
+		t.Errorf("the restored file should be found, err: %v", err)
+	}
+}
+
+// trashTestFile moves the file into the trash at the given time.
+func trashTestFile(t *testing.T, file *File, at time.Time) {
+	t.Helper()
+
+	if err := lib.GetDatabase().Unscoped().Model(file).Updates(map[string]interface{}{
+		"trashed":    true,
+		"deleted_at": at,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	days := TrashDays
+	defer func() { TrashDays = days }()
+	TrashDays = 30
+
+	user := newTestUser(t)
+	old := newTestFile(t, user, "old.txt", "old contents")
+	recent := newTestFile(t, user, "recent.txt", "recent contents")
+	trashTestFile(t, old, time.Now().Add(-31*24*time.Hour))
+	trashTestFile(t, recent, time.Now().Add(-time.Hour))
+
+	// A file whose owner cannot be found doesn't stop the other files from being purged. The file is
+	// removed along with the rest of the owner's data after the test.
+	orphan := newTestUser(t)
+	orphaned := newTestFile(t, orphan, "orphaned.txt", "orphaned contents")
+	trashTestFile(t, orphaned, time.Now().Add(-31*24*time.Hour))
+	if err := lib.GetDatabase().Unscoped().Delete(orphan).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PurgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := user.FindTrashedFile(old.UUID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the old file should have been purged, got: %v", err)
+	}
+
+	if _, err := user.FindTrashedFile(recent.UUID); err != nil {
+		t.Errorf("the recently trashed file should be kept, err: %v", err)
+	}
+}
+
+func TestUserDelete(t *testing.T) {
+	requireDatabase(t)
+	db := lib.GetDatabase()
+
+	owner := &User{Username: "test-" + lib.GenerateUUID()[:8], UUID: lib.GenerateUUID()}
+	if err := db.Create(owner).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	recipient := newTestUser(t)
+	file := newTestFile(t, owner, "shared.txt", "shared contents")
+	if err := CreateFileShare(&FileShare{SharedByID: owner.ID, SharedToID: recipient.ID, SharedFileID: file.ID,
+		Permission: PermissionDownload}); err != nil {
+		t.Fatal(err)
+	}
+
+	received := newTestFile(t, recipient, "received.txt", "received contents")
+	if err := CreateFileShare(&FileShare{SharedByID: recipient.ID, SharedToID: owner.ID, SharedFileID: received.ID,
+		Permission: PermissionView}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := owner.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The shares both from and to the user are removed for good.
+	var shares int64
+	if err := db.Unscoped().Model(&FileShare{}).Where("shared_by_id = ? OR shared_to_id = ?", owner.ID, owner.ID).
+		Count(&shares).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if shares != 0 {
+		t.Errorf("the shares of the removed user should be removed, found %d", shares)
+	}
+
+	// The user isn't only soft deleted, so the username can be used again.
+	var users int64
+	if err := db.Unscoped().Model(&User{}).Where("id = ?", owner.ID).Count(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if users != 0 {
+		t.Error("the user should have been removed for good")
+	}
+
+	if _, err := FindOneFile(&File{UUID: received.UUID}); err != nil {
+		t.Errorf("the other user's file should be kept, err: %v", err)
+	}
+}
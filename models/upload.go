@@ -0,0 +1,111 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
+	"gorm.io/gorm"
+)
+
+// StaleUploadAge is the time after the last received data, after which an unfinished upload is removed.
+const StaleUploadAge = 7 * 24 * time.Hour
+
+// Upload is a resumable upload, which is still in progress. The data received so far is staged in the
+// storage backend as numbered parts, one for each request which sent data. Once all of the data has been
+// received, the parts are combined into a normal file and the upload is removed.
+type Upload struct {
+	gorm.Model
+	UUID        string `gorm:"uniqueIndex"`
+	UserID      uint
+	Filename    string
+	Description string
+	FolderID    *uint // The folder in which the file is created, nil for the top level.
+	Length      int64 // The total size of the file in bytes.
+	Offset      int64 // The number of bytes received so far.
+	Parts       int   // The number of staged parts.
+
+	// The data key of an encrypted upload wrapped with the owner's master password. The staged parts are
+	// encrypted with the data key, so that the data is never stored as plaintext. Empty if the file is not
+	// encrypted.
+	WrappedKey []byte
+
+	// PendingWrappedKey is the data key wrapped with the new master password, while the user's master
+	// password is being changed.
+	PendingWrappedKey []byte
+}
+
+// Encrypted tells whether the file is encrypted when the upload completes.
+func (upload *Upload) Encrypted() bool {
+	return len(upload.WrappedKey) != 0
+}
+
+// StagingPrefix returns the prefix of the keys under which the parts of the upload are stored.
+func (upload *Upload) StagingPrefix(ownerUUID string) string {
+	return ownerUUID + "/uploads/" + upload.UUID + "/"
+}
+
+// PartKey returns the key under which the given part of the upload is stored.
+func (upload *Upload) PartKey(ownerUUID string, part int) string {
+	return fmt.Sprintf("%s%06d", upload.StagingPrefix(ownerUUID), part)
+}
+
+// FindUserUpload finds one of the user's uploads.
+func FindUserUpload(userID uint, uploadID string) (*Upload, error) {
+	db := lib.GetDatabase()
+
+	var upload Upload
+	if err := db.Where("uuid = ? AND user_id = ?", uploadID, userID).First(&upload).Error; err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// Remove removes the staged parts and the database entry of the upload.
+func (upload *Upload) Remove(ownerUUID string) error {
+	if err := storage.DeletePrefix(storage.GetBackend(), upload.StagingPrefix(ownerUUID)); err != nil {
+		return err
+	}
+
+	return lib.GetDatabase().Unscoped().Delete(upload).Error
+}
+
+// DeleteStaleUploads removes the uploads, which haven't received any data in StaleUploadAge, along with
+// their staged parts. The uploads which cannot be removed are logged and tried again the next time.
+func DeleteStaleUploads() error {
+	db := lib.GetDatabase()
+
+	var uploads []Upload
+	if err := db.Where("updated_at < ?", time.Now().Add(-StaleUploadAge)).Find(&uploads).Error; err != nil {
+		return err
+	}
+
+	for i := range uploads {
+		owner, err := FindOneUser(&User{Model: gorm.Model{ID: uploads[i].UserID}})
+		if err != nil {
+			log.Printf("removing stale upload %s: %v", uploads[i].UUID, err)
+			continue
+		}
+
+		if err := uploads[i].Remove(owner.UUID); err != nil {
+			log.Printf("removing stale upload %s: %v", uploads[i].UUID, err)
+		}
+	}
+
+	return nil
+}
+
+// EncryptedUploads returns the user's encrypted uploads, which are still in progress.
+func (user *User) EncryptedUploads() ([]Upload, error) {
+	db := lib.GetDatabase()
+
+	var uploads []Upload
+	if err := db.Where("user_id = ? AND wrapped_key IS NOT NULL", user.ID).Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
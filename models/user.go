@@ -1,9 +1,8 @@
 package models
 
 import (
-	"os"
-
 	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
 	"gorm.io/gorm"
 )
 
@@ -14,7 +13,17 @@ type User struct {
 	Password             string // Password to see the files.
 	UUID                 string `json:"uuid"` // Unique ID to identify a user.
 	FileEncryptionMaster string // A password which holds the passphrase with which files are encrypted.
+	KeyDerivation        []byte // The parameters and salt used to derive a key from the master password.
 	Files                []File // A relation to files, which hold a UserID which refers to this model.
+	Admin                bool   // Admins can change the quotas of the other users.
+	QuotaBytes           *int64 // The user's own quota, nil if the default quota is used.
+	UsedBytes            int64  // The total size of the user's files.
+	KeepVersions         int    // How many earlier versions of each file are kept, zero for all of them.
+	KeepVersionDays      int    // How many days the earlier versions are kept, zero for forever.
+
+	// The key pair used to share encrypted files. The private key is wrapped with the master password.
+	PublicKey         []byte
+	WrappedPrivateKey []byte
 }
 
 // Serialize serializes a given user's data into json format
@@ -22,60 +31,107 @@ func (user *User) Serialize() lib.JSON {
 	return lib.JSON{
 		"username": user.Username,
 		"uuid":     user.UUID,
+		"used":     user.UsedBytes,
+		"quota":    user.Quota(),
 	}
 }
 
-func (user *User) FindSharedToFiles() ([]File, error) {
+// FindFiles returns all of the files owned by the user.
+func (user *User) FindFiles() ([]File, error) {
 	db := lib.GetDatabase()
 
-	var sharedFileContracts []FileShare
-
-	db.Where(&FileShare{SharedToID: user.ID}).Find(&sharedFileContracts)
-
 	var files []File
-	for _, sf := range sharedFileContracts {
-		var tempFile File
-		if err := db.Where("id = ?", sf.SharedFileID).First(&tempFile).Error; err != nil {
-			return nil, err
-		}
-
-		files = append(files, tempFile)
+	if err := db.Where(&File{UserID: user.ID}).Find(&files).Error; err != nil {
+		return nil, err
 	}
 
 	return files, nil
 }
 
-func (user *User) FindSharedByFiles() ([]File, error) {
+// FindSharedToFiles returns the page of the files shared to the user matching the query, either directly
+// or through the user's groups. The folders shared with the groups give access to the unencrypted files
+// inside them.
+func (user *User) FindSharedToFiles(fileQuery *FileQuery) (*FilePage, error) {
 	db := lib.GetDatabase()
 
-	var sharedFileContracts []FileShare
-	db.Where(&FileShare{SharedByID: user.ID}).Find(&sharedFileContracts)
-
-	var files []File
-	for _, sf := range sharedFileContracts {
-		var tempFile File
-		if err := db.Where("id = ?", sf.SharedFileID).First(&tempFile).Error; err != nil {
-			return nil, err
-		}
+	folderIDs, err := groupFolderIDs(user.ID)
+	if err != nil {
+		return nil, err
+	}
 
-		files = append(files, tempFile)
+	query := db.Where("id IN (?)", db.Model(&FileShare{}).Scopes(activeShares).Select("shared_file_id").
+		Where(&FileShare{SharedToID: user.ID})).
+		Or("id IN (?)", memberGroupShares(db, user.ID).Select("file_id"))
+	if len(folderIDs) > 0 {
+		query = query.Or("shareable_file AND folder_id IN ?", folderIDs)
 	}
 
-	return files, nil
+	// The user's own files can be in the folders shared with their groups.
+	return fileQuery.Find(db.Model(&File{}).Where(query).Where("user_id <> ?", user.ID))
 }
 
-// Delete deletes the given user's file and removes the user's database entry from the database.
-func (user *User) Delete() error {
+// FindSharedByFiles returns the page of the files the user has shared to other users matching the query.
+// The files in the trash and the expired shares are left out.
+func (user *User) FindSharedByFiles(query *FileQuery) (*FilePage, error) {
 	db := lib.GetDatabase()
+	return query.Find(db.Model(&File{}).Where("id IN (?)", db.Model(&FileShare{}).Scopes(activeShares).
+		Select("shared_file_id").Where(&FileShare{SharedByID: user.ID})))
+}
+
+// Delete removes the user along with their files, shares, groups and the rest of their data for good. The
+// database entries are removed in a single transaction, and the contents are removed from the storage
+// once it has been committed.
+func (user *User) Delete() error {
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		fileIDs := tx.Unscoped().Model(&File{}).Select("id").Where("user_id = ?", user.ID)
+
+		// The shares of the user's files and the files shared to the user cannot be used anymore.
+		if err := tx.Unscoped().Where("shared_by_id = ? OR shared_to_id = ? OR shared_file_id IN (?)",
+			user.ID, user.ID, fileIDs).Delete(&FileShare{}).Error; err != nil {
+			return err
+		}
+
+		// The blobs are shared with the other users' files, so only the references are removed. The
+		// user's own contents are removed along with the rest of the user's storage.
+		var blobFiles []File
+		if err := tx.Unscoped().Where("user_id = ? AND (blob_id IS NOT NULL OR id IN (?))", user.ID,
+			tx.Model(&FileVersion{}).Select("file_id").Where("blob_id IS NOT NULL")).
+			Find(&blobFiles).Error; err != nil {
+			return err
+		}
+
+		for i := range blobFiles {
+			if _, err := deleteFile(tx, &blobFiles[i], user.UUID); err != nil {
+				return err
+			}
+		}
+
+		// Remove the entries which belong to the user's files, the files in the trash included.
+		for _, model := range []interface{}{&FileKey{}, &FileVersion{}, &ShareLink{}, &FileTag{}} {
+			if err := tx.Unscoped().Where("file_id IN (?)", fileIDs).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, model := range []interface{}{&Tag{}, &File{}, &MasterKeyChange{}, &APIToken{}, &Session{},
+			&Upload{}, &Folder{}} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := user.leaveGroups(tx); err != nil {
+			return err
+		}
 
-	// Remove the user's folder
-	if err := os.RemoveAll(lib.AddRootToPath("files/") + user.UUID); err != nil {
+		return tx.Unscoped().Delete(user).Error
+	})
+	if err != nil {
 		return err
 	}
 
-	// Remove from database
-	db.Delete(&user)
-	return nil
+	// Remove all of the user's files from the storage
+	return storage.DeletePrefix(storage.GetBackend(), user.UUID+"/")
 }
 
 // FindOneUser takes a interface{} as an argument and returns a pointer to a user struct,
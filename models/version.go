@@ -0,0 +1,334 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
+	"gorm.io/gorm"
+)
+
+// FileVersion holds the earlier contents of a file, which were replaced by uploading a new version. The
+// contents of an unencrypted version are kept in a blob, and the contents of an encrypted version under
+// their own key. Encrypted versions use the data key of the file, so changing the master password
+// covers them as well. The versions are created when they are replaced, so CreatedAt tells when the
+// contents stopped being the current ones.
+type FileVersion struct {
+	gorm.Model
+	UUID       string `gorm:"uniqueIndex"`
+	FileID     uint   `gorm:"index"`
+	Size       int64
+	SizeHuman  string
+	Hash       string
+	MIME       string
+	BlobID     *uint
+	ContentKey string
+}
+
+// Content describes the new contents of a file, which have been stored under Key.
+type Content struct {
+	Key       string
+	Size      int64
+	SizeHuman string
+	Hash      string
+	MIME      string
+}
+
+// StorageKey returns the key under which the contents of the version are stored.
+func (version *FileVersion) StorageKey() string {
+	if version.BlobID != nil {
+		return BlobKey(version.Hash)
+	}
+
+	return version.ContentKey
+}
+
+// AsFile returns a copy of the file which has the contents of the version. This is used to download the
+// version in the same way as the file itself.
+func (version *FileVersion) AsFile(file *File) *File {
+	copied := *file
+	copied.Size = version.Size
+	copied.SizeHuman = version.SizeHuman
+	copied.Hash = version.Hash
+	copied.MIME = version.MIME
+	copied.BlobID = version.BlobID
+	copied.ContentKey = version.ContentKey
+	copied.UpdatedAt = version.CreatedAt
+
+	return &copied
+}
+
+// Serialize serializes the version's data into json format. The keyed hashes of the encrypted versions
+// are left out like the hashes of the encrypted files.
+func (version *FileVersion) Serialize() lib.JSON {
+	serialized := lib.JSON{
+		"uuid":       version.UUID,
+		"created_at": version.CreatedAt,
+		"size":       version.Size,
+		"mime":       version.MIME,
+	}
+
+	if !strings.HasPrefix(version.Hash, KeyedHashPrefix) {
+		serialized["hash"] = version.Hash
+	}
+
+	return serialized
+}
+
+// versionOf creates a version from the current contents of the file.
+func versionOf(file *File, ownerUUID string) *FileVersion {
+	version := &FileVersion{
+		UUID:      lib.GenerateUUID(),
+		FileID:    file.ID,
+		Size:      file.Size,
+		SizeHuman: file.SizeHuman,
+		Hash:      file.Hash,
+		MIME:      file.MIME,
+		BlobID:    file.BlobID,
+	}
+
+	if file.BlobID == nil {
+		version.ContentKey = file.StorageKey(ownerUUID)
+	}
+
+	return version
+}
+
+// setContent makes the contents described by the version the current contents of the file.
+func setContent(tx *gorm.DB, file *File, version *FileVersion) error {
+	file.Size = version.Size
+	file.SizeHuman = version.SizeHuman
+	file.Hash = version.Hash
+	file.MIME = version.MIME
+	file.BlobID = version.BlobID
+	file.ContentKey = version.ContentKey
+
+	return tx.Model(file).Select("size", "size_human", "hash", "mime", "blob_id", "content_key").
+		Updates(file).Error
+}
+
+// AddVersion makes the contents stored under content.Key the current contents of the file, and keeps the
+// earlier contents as a version. The contents of an unencrypted file are moved into a blob. The size of
+// the new contents is added to the owner's used bytes, and ErrQuotaExceeded is returned if it doesn't
+// fit.
+func (file *File) AddVersion(ownerUUID string, content *Content) error {
+	backend := storage.GetBackend()
+	previous := *file
+	reused := false
+
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if err := chargeQuota(tx, file.UserID, content.Size); err != nil {
+			return err
+		}
+
+		if err := tx.Create(versionOf(file, ownerUUID)).Error; err != nil {
+			return err
+		}
+
+		next := &FileVersion{
+			Size:       content.Size,
+			SizeHuman:  content.SizeHuman,
+			Hash:       content.Hash,
+			MIME:       content.MIME,
+			ContentKey: content.Key,
+		}
+
+		if file.ShareableFile {
+			blob, blobReused, err := acquireBlob(tx, content.Hash, content.Size, content.Key)
+			if err != nil {
+				return err
+			}
+			reused = blobReused
+			next.BlobID = &blob.ID
+			next.ContentKey = ""
+		}
+
+		return setContent(tx, file, next)
+	})
+	if err != nil {
+		*file = previous
+		return err
+	}
+
+	if reused {
+		backend.Delete(content.Key)
+	}
+
+	return nil
+}
+
+// FindVersions returns the versions of the file, the newest first.
+func (file *File) FindVersions() ([]FileVersion, error) {
+	db := lib.GetDatabase()
+
+	var versions []FileVersion
+	if err := db.Where(&FileVersion{FileID: file.ID}).Order("created_at DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// FindVersion returns the version of the file with the given uuid.
+func (file *File) FindVersion(versionID string) (*FileVersion, error) {
+	db := lib.GetDatabase()
+
+	var version FileVersion
+	if err := db.Where("file_id = ? AND uuid = ?", file.ID, versionID).First(&version).Error; err != nil {
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+// RestoreVersion makes the contents of the version the current contents of the file. The current
+// contents are kept as a new version, so restoring can be undone.
+func (file *File) RestoreVersion(ownerUUID string, version *FileVersion) error {
+	previous := *file
+
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(versionOf(file, ownerUUID)).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Delete(version).Error; err != nil {
+			return err
+		}
+
+		return setContent(tx, file, version)
+	})
+	if err != nil {
+		*file = previous
+	}
+
+	return err
+}
+
+// DeleteVersion removes the version and its contents. The size of the version is removed from the
+// owner's used bytes.
+func (file *File) DeleteVersion(version *FileVersion) error {
+	return deleteVersionsNow(file.UserID, []FileVersion{*version})
+}
+
+// deleteVersionsNow removes the versions in a transaction, and their contents after it has been
+// committed.
+func deleteVersionsNow(userID uint, versions []FileVersion) error {
+	var keys []string
+	err := lib.GetDatabase().Transaction(func(tx *gorm.DB) error {
+		var err error
+		keys, err = deleteVersions(tx, userID, versions)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	deleteObjects(keys)
+	return nil
+}
+
+// deleteVersions removes the versions and removes their size from the user's used bytes. The keys of
+// their own contents are returned, so that they can be removed once the transaction has been committed.
+func deleteVersions(tx *gorm.DB, userID uint, versions []FileVersion) ([]string, error) {
+	var keys []string
+	var size int64
+	for i := range versions {
+		if versions[i].BlobID != nil {
+			if err := releaseBlob(tx, *versions[i].BlobID); err != nil {
+				return nil, err
+			}
+		} else {
+			keys = append(keys, versions[i].ContentKey)
+		}
+
+		if err := tx.Unscoped().Delete(&versions[i]).Error; err != nil {
+			return nil, err
+		}
+		size += versions[i].Size
+	}
+
+	if size == 0 {
+		return keys, nil
+	}
+
+	return keys, refundQuota(tx, userID, size)
+}
+
+// PruneVersions removes the versions of the file, which the owner doesn't want to keep according to
+// their retention policy.
+func (file *File) PruneVersions(owner *User) error {
+	versions, err := file.FindVersions()
+	if err != nil {
+		return err
+	}
+
+	var expired []FileVersion
+	for i, version := range versions {
+		if owner.KeepVersions > 0 && i >= owner.KeepVersions ||
+			owner.KeepVersionDays > 0 && time.Since(version.CreatedAt) > versionAge(owner.KeepVersionDays) {
+			expired = append(expired, version)
+		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	return deleteVersionsNow(file.UserID, expired)
+}
+
+// PruneExpiredVersions removes the versions which are older than their owners want to keep them.
+func PruneExpiredVersions() error {
+	db := lib.GetDatabase()
+
+	var files []File
+	if err := db.Where("id IN (?)", db.Table("file_versions").Select("file_versions.file_id").
+		Joins("JOIN files ON files.id = file_versions.file_id").
+		Joins("JOIN users ON users.id = files.user_id").
+		Where("users.keep_version_days > 0 AND file_versions.deleted_at IS NULL").
+		Where("file_versions.created_at < NOW() - users.keep_version_days * INTERVAL '1 day'")).
+		Find(&files).Error; err != nil {
+		return err
+	}
+
+	for i := range files {
+		owner, err := FindOneUser(&User{Model: gorm.Model{ID: files[i].UserID}})
+		if err != nil {
+			return err
+		}
+
+		if err := files[i].PruneVersions(owner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VersionUsage returns the total size of the earlier versions of the user's files.
+func (user *User) VersionUsage() (int64, error) {
+	db := lib.GetDatabase()
+
+	var size int64
+	if err := db.Model(&FileVersion{}).Select("COALESCE(SUM(file_versions.size), 0)").
+		Joins("JOIN files ON files.id = file_versions.file_id").
+		Where("files.user_id = ? AND files.deleted_at IS NULL", user.ID).Scan(&size).Error; err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// SetVersionRetention sets how many versions of each file the user wants to keep, and for how many days.
+// Zero means that the versions are not limited by the count or by the age.
+func (user *User) SetVersionRetention(keep, days int) error {
+	user.KeepVersions = keep
+	user.KeepVersionDays = days
+
+	return lib.GetDatabase().Model(user).Select("keep_versions", "keep_version_days").Updates(user).Error
+}
+
+func versionAge(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/storage"
+)
+
+// addTestVersion makes the contents the current contents of the file.
+func addTestVersion(t *testing.T, user *User, file *File, contents string) {
+	t.Helper()
+
+	key := user.UUID + "/" + lib.GenerateUUID()
+	if _, err := storage.GetBackend().Put(key, strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(contents))
+	if err := file.AddVersion(user.UUID, &Content{
+		Key:  key,
+		Size: int64(len(contents)),
+		Hash: hex.EncodeToString(sum[:]),
+		MIME: "text/plain; charset=utf-8",
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionHistory(t *testing.T) {
+	user := newTestUser(t)
+	file := newTestFile(t, user, "document.txt", "first")
+	first := file.Hash
+
+	addTestVersion(t, user, file, "second")
+
+	versions, err := file.FindVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 1 || versions[0].Hash != first || versions[0].Size != int64(len("first")) {
+		t.Fatalf("wrong versions after adding a version: %+v", versions)
+	}
+
+	// An empty id doesn't match any version.
+	if _, err := file.FindVersion(""); err == nil {
+		t.Error("expected no version for an empty id")
+	}
+
+	// Restoring keeps the replaced contents as a version.
+	if err := file.RestoreVersion(user.UUID, &versions[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if file.Hash != first {
+		t.Errorf("the first contents should have been restored")
+	}
+
+	versions, err = file.FindVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 1 || versions[0].Size != int64(len("second")) {
+		t.Errorf("wrong versions after restoring: %+v", versions)
+	}
+}
+
+func TestPruneVersions(t *testing.T) {
+	user := newTestUser(t)
+	file := newTestFile(t, user, "document.txt", "first")
+
+	addTestVersion(t, user, file, "second")
+	addTestVersion(t, user, file, "third")
+	addTestVersion(t, user, file, "fourth")
+
+	if err := user.SetVersionRetention(2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.PruneVersions(user); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := file.FindVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The newest versions are kept.
+	if len(versions) != 2 || versions[0].Size != int64(len("third")) || versions[1].Size != int64(len("second")) {
+		t.Errorf("wrong versions after pruning: %+v", versions)
+	}
+}
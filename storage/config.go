@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/nireo/upfi/lib"
+)
+
+// NewFromEnv creates the backend which is selected with the 'storage_backend' environment variable.
+// The files are stored on the local disk by default. The s3 backend is configured using the
+// 's3_endpoint', 's3_region', 's3_bucket', 's3_access_key', 's3_secret_key' and 's3_part_size'
+// environment variables.
+func NewFromEnv() (Backend, error) {
+	switch os.Getenv("storage_backend") {
+	case "", "local":
+		return NewLocal(lib.AddRootToPath("files")), nil
+	case "s3":
+		conf := S3Config{
+			Endpoint:  os.Getenv("s3_endpoint"),
+			Region:    os.Getenv("s3_region"),
+			Bucket:    os.Getenv("s3_bucket"),
+			AccessKey: os.Getenv("s3_access_key"),
+			SecretKey: os.Getenv("s3_secret_key"),
+		}
+
+		if partSize := os.Getenv("s3_part_size"); partSize != "" {
+			size, err := strconv.ParseInt(partSize, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("storage: invalid s3_part_size: %s", err)
+			}
+			conf.PartSize = size
+		}
+
+		return NewS3(conf)
+	default:
+		return nil, fmt.Errorf("storage: unknown storage backend %q", os.Getenv("storage_backend"))
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local stores the files into a directory on the local disk. This is the default backend and it
+// uses the same 'files/<user-uuid>/<file-uuid><ext>' layout that upfi has always used.
+type Local struct {
+	root string
+}
+
+// NewLocal creates a local backend which stores all of the objects under the given directory.
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+// Put writes the data into a temporary file in the same directory and then renames it into place, so
+// that readers never see a partially written file.
+func (l *Local) Put(key string, r io.Reader) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrInvalidKey
+	}
+
+	dst := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".upload-")
+	if err != nil {
+		return 0, err
+	}
+	// If the rename succeeds, this doesn't do anything.
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// Get opens the file stored under the key.
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	f, err := os.Open(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+// GetRange opens the file stored under the key and seeks to the offset.
+func (l *Local) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	rc, err := l.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f := rc.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// Move renames the file, so the data doesn't need to be copied.
+func (l *Local) Move(src, dst string) error {
+	if !validKey(src) || !validKey(dst) {
+		return ErrInvalidKey
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path(dst)), 0755); err != nil {
+		return err
+	}
+
+	err := os.Rename(l.path(src), l.path(dst))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// Delete removes the file stored under the key.
+func (l *Local) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	if err := os.Remove(l.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Stat returns the size and modification time of the file stored under the key.
+func (l *Local) Stat(key string) (*ObjectInfo, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	info, err := os.Stat(l.path(key))
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List walks the storage directory and returns all of the files whose key starts with the prefix.
+func (l *Local) List(prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+
+	err := filepath.Walk(l.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		// Skip the temporary files of uploads that are still in progress.
+		if strings.HasPrefix(filepath.Base(path), ".upload-") || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
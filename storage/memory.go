@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Memory keeps all of the objects in memory. It is mostly useful in tests, where we don't want to
+// create files on the disk.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// NewMemory creates an empty in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]memoryObject)}
+}
+
+// Put reads all of the data into memory and stores it under the key.
+func (m *Memory) Put(key string, r io.Reader) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrInvalidKey
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+
+	m.mu.Lock()
+	m.objects[key] = memoryObject{data: data, modTime: time.Now()}
+	m.mu.Unlock()
+
+	return int64(len(data)), nil
+}
+
+// Get returns a reader to the stored data.
+func (m *Memory) Get(key string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// GetRange returns a reader to a part of the stored data.
+func (m *Memory) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if offset < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	// Like with the other backends, the ranges past the end and the negative lengths are empty.
+	data := obj.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length < 0 {
+		length = 0
+	}
+	if length < int64(len(data)) {
+		data = data[:length]
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Move stores the object under the destination key.
+func (m *Memory) Move(src, dst string) error {
+	if !validKey(dst) {
+		return ErrInvalidKey
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[src]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(m.objects, src)
+	m.objects[dst] = obj
+	return nil
+}
+
+// Delete removes the object from memory.
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	delete(m.objects, key)
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Stat returns the size and the time when the object was stored.
+func (m *Memory) Stat(key string) (*ObjectInfo, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &ObjectInfo{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+// List returns all of the objects whose key starts with the prefix.
+func (m *Memory) List(prefix string) ([]ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	objects := []ObjectInfo{}
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// GetRange returns a reader to at most length bytes of the object's data starting from the offset. If
+// the backend doesn't implement RangeGetter, the object is read from the start and the data before the
+// offset is skipped.
+func GetRange(b Backend, key string, offset, length int64) (io.ReadCloser, error) {
+	if rg, ok := b.(RangeGetter); ok {
+		return rg.GetRange(key, offset, length)
+	}
+
+	rc, err := b.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return skipRange(rc, offset, length)
+}
+
+// skipRange skips the data before the offset in a reader which starts from the beginning of the object
+// and limits the reader to the length.
+func skipRange(rc io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, length), rc}, nil
+}
+
+// ObjectReader reads an object from a backend and supports seeking, which is needed for example to
+// serve range requests. The data is streamed from the current position until the reader is moved
+// with Seek, so reading the object sequentially needs only a single request to the backend.
+type ObjectReader struct {
+	backend Backend
+	key     string
+	size    int64
+	pos     int64
+	body    io.ReadCloser
+}
+
+// Open returns an ObjectReader to the object stored under the key.
+func Open(b Backend, key string) (*ObjectReader, error) {
+	info, err := b.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectReader{backend: b, key: key, size: info.Size}, nil
+}
+
+// Size returns the size of the object.
+func (o *ObjectReader) Size() int64 {
+	return o.size
+}
+
+func (o *ObjectReader) Read(p []byte) (int, error) {
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		body, err := GetRange(o.backend, o.key, o.pos, o.size-o.pos)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	if err == io.EOF && o.pos < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// Seek moves the position of the next read. The data from the new position is requested from the
+// backend once it's read.
+func (o *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.pos
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+
+	if offset != o.pos {
+		o.closeBody()
+		o.pos = offset
+	}
+
+	return offset, nil
+}
+
+// Close closes the connection to the backend.
+func (o *ObjectReader) Close() error {
+	return o.closeBody()
+}
+
+func (o *ObjectReader) closeBody() error {
+	if o.body == nil {
+		return nil
+	}
+
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
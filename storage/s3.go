@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minPartSize is the smallest part size S3 accepts for all but the last part of a multipart upload.
+const minPartSize = 5 << 20
+
+// s3RequestTimeout is how long the requests other than the downloads can take, including sending the
+// body of an upload part.
+const s3RequestTimeout = 5 * time.Minute
+
+// errRangeNotSatisfiable is returned by do when the requested range starts past the end of the object.
+var errRangeNotSatisfiable = errors.New("storage: range not satisfiable")
+
+// S3Config contains all the fields needed to connect to a S3 compatible object storage.
+type S3Config struct {
+	Endpoint  string // For example http://localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// PartSize is the size of the parts in multipart uploads. Objects smaller than this are uploaded
+	// with a single request. Defaults to 8 MB.
+	PartSize int64
+}
+
+// S3 stores the files into a bucket in a S3 compatible object storage such as MinIO or Ceph RGW. The
+// bucket is addressed using path-style urls, since those work with all of the self-hosted servers.
+type S3 struct {
+	conf   S3Config
+	client *http.Client
+}
+
+// NewS3 creates a S3 backend from the given configuration.
+func NewS3(conf S3Config) (*S3, error) {
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, errors.New("storage: s3 endpoint and bucket are required")
+	}
+
+	if conf.AccessKey == "" || conf.SecretKey == "" {
+		return nil, errors.New("storage: s3 access key and secret key are required")
+	}
+
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+
+	if conf.PartSize == 0 {
+		conf.PartSize = 8 << 20
+	}
+
+	if conf.PartSize < minPartSize {
+		return nil, fmt.Errorf("storage: s3 part size must be at least %d bytes", minPartSize)
+	}
+
+	conf.Endpoint = strings.TrimSuffix(conf.Endpoint, "/")
+	return &S3{conf: conf, client: newS3Client()}, nil
+}
+
+// newS3Client creates the http client used to talk to S3. The client doesn't have an overall timeout,
+// since the downloads are streamed to the users for as long as they take. Instead connecting and waiting
+// for the response headers have their own timeouts, so that an unresponsive server cannot hang the
+// requests forever.
+func newS3Client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+			ExpectContinueTimeout: time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   16,
+		},
+	}
+}
+
+// cancelCloser releases the context of a request once its response body is closed.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// s3Error is the error document returned by S3 when a request fails.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// objectURL returns the path-style url of an object. An empty key points to the bucket itself.
+func (s *S3) objectURL(key string, query url.Values) string {
+	u := s.conf.Endpoint + "/" + s3Escape(s.conf.Bucket, true)
+	if key != "" {
+		u += "/" + s3Escape(key, false)
+	}
+
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	return u
+}
+
+// do signs and sends a request. The body is given as bytes, since the signature needs the hash of
+// the payload. Responses with an error status are turned into errors. The requests other than GET must
+// finish within s3RequestTimeout, including reading the response, while the objects are downloaded for as
+// long as it takes.
+func (s *S3) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if method != http.MethodGet || key == "" {
+		ctx, cancel = context.WithTimeout(context.Background(), s3RequestTimeout)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, query), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	signRequest(req, payloadHash, s.conf.AccessKey, s.conf.SecretKey, s.conf.Region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelCloser{ReadCloser: resp.Body, cancel: cancel}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return nil, errRangeNotSatisfiable
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		var s3Err s3Error
+		data, _ := ioutil.ReadAll(resp.Body)
+		if err := xml.Unmarshal(data, &s3Err); err != nil || s3Err.Code == "" {
+			return nil, fmt.Errorf("storage: s3 %s request failed with status %d", method, resp.StatusCode)
+		}
+
+		return nil, fmt.Errorf("storage: s3 %s: %s", s3Err.Code, s3Err.Message)
+	}
+
+	return resp, nil
+}
+
+// Put uploads the data in parts, such that at most one part is kept in memory at a time. Small
+// objects are uploaded with a single request.
+func (s *S3) Put(key string, r io.Reader) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrInvalidKey
+	}
+
+	buf := make([]byte, s.conf.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(http.MethodPut, key, nil, buf[:n], nil)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return s.putMultipart(key, r, buf)
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// putMultipart uploads the object using the multipart upload api. The buf already contains the
+// first full part of the data. If anything fails, the upload is aborted so that the parts don't
+// keep using space in the bucket.
+func (s *S3) putMultipart(key string, r io.Reader, buf []byte) (int64, error) {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	abort := func() {
+		if resp, err := s.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	var (
+		total int64
+		parts []completedPart
+		n     = len(buf)
+	)
+
+	for n > 0 {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(len(parts) + 1)},
+			"uploadId":   {initiated.UploadID},
+		}
+
+		resp, err := s.do(http.MethodPut, key, query, buf[:n], nil)
+		if err != nil {
+			abort()
+			return total, err
+		}
+		resp.Body.Close()
+
+		parts = append(parts, completedPart{PartNumber: len(parts) + 1, ETag: resp.Header.Get("ETag")})
+		total += int64(n)
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return total, err
+		}
+	}
+
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		abort()
+		return total, err
+	}
+
+	resp, err = s.do(http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, body, nil)
+	if err != nil {
+		abort()
+		return total, err
+	}
+	defer resp.Body.Close()
+
+	// S3 can report an error in the body of a successful response if the completion failed.
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return total, err
+	}
+
+	var s3Err s3Error
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		abort()
+		return total, fmt.Errorf("storage: s3 %s: %s", s3Err.Code, s3Err.Message)
+	}
+
+	return total, nil
+}
+
+// Get returns the body of the object's response, which streams the data from the server.
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// GetRange requests a part of the object using the Range header.
+func (s *S3) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	if offset < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	if length <= 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	// The ranges which start past the end of the object are empty, like with the other backends.
+	resp, err := s.do(http.MethodGet, key, nil, nil, header)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Some S3 compatible services ignore the Range header and return the whole object.
+	if resp.StatusCode != http.StatusPartialContent {
+		return skipRange(resp.Body, offset, length)
+	}
+
+	return resp.Body, nil
+}
+
+// Delete removes the object from the bucket.
+func (s *S3) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Stat uses a HEAD request to find the size and modification time of the object.
+func (s *S3) Stat(key string) (*ObjectInfo, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List uses the ListObjectsV2 api and follows the continuation tokens until all of the objects with
+// the prefix have been listed.
+func (s *S3) List(prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
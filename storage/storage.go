@@ -0,0 +1,128 @@
+// Package storage contains the backends in which the contents of the user's files are stored. The
+// handlers only talk to the Backend interface, such that the actual place where the files are
+// stored can be changed without touching the handlers.
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by the backends when an object with the given key does not exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned when a key would escape the storage area or is otherwise malformed.
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// ErrInvalidRange is returned by GetRange when the offset is negative.
+var ErrInvalidRange = errors.New("storage: invalid range")
+
+// ObjectInfo contains the information about a single stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend defines a place where file contents can be stored. Keys are slash separated paths such as
+// '<user-uuid>/<file-uuid>.txt'. All of the methods stream the data, such that the whole object
+// doesn't need to fit into memory.
+type Backend interface {
+	// Put stores all of the data from the reader under the given key, replacing any existing object.
+	// Returns the amount of bytes written.
+	Put(key string, r io.Reader) (int64, error)
+
+	// Get returns a reader to the object's data. The caller must close the reader.
+	Get(key string) (io.ReadCloser, error)
+
+	// Delete removes the object. Deleting an object that doesn't exist is not an error.
+	Delete(key string) error
+
+	// Stat returns information about a single object.
+	Stat(key string) (*ObjectInfo, error)
+
+	// List returns all of the objects whose key starts with the given prefix sorted by key.
+	List(prefix string) ([]ObjectInfo, error)
+}
+
+// RangeGetter is implemented by the backends which can read a part of an object without reading the
+// data before it.
+type RangeGetter interface {
+	// GetRange returns a reader to at most length bytes of the object's data starting from the offset.
+	// The caller must close the reader.
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Mover is implemented by the backends which can move an object to another key without copying the
+// data through the server.
+type Mover interface {
+	// Move moves the object to the destination key, replacing any existing object.
+	Move(src, dst string) error
+}
+
+var backend Backend
+
+// SetBackend sets the global backend variable in this file to the backend created in the main function.
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// GetBackend returns the backend which is used to store all of the files.
+func GetBackend() Backend {
+	return backend
+}
+
+// DeletePrefix removes all of the objects which have a key starting with the given prefix. This is
+// used for example to remove all of the files of a single user.
+func DeletePrefix(b Backend, prefix string) error {
+	objects, err := b.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if err := b.Delete(obj.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Move moves the object to the destination key. If the backend doesn't implement Mover, the data is
+// copied to the destination and the source object is removed.
+func Move(b Backend, src, dst string) error {
+	if m, ok := b.(Mover); ok {
+		return m.Move(src, dst)
+	}
+
+	rc, err := b.Get(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if _, err := b.Put(dst, rc); err != nil {
+		return err
+	}
+
+	return b.Delete(src)
+}
+
+// validKey checks that the key is a relative slash separated path which doesn't contain any
+// parent directory references.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return false
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+
+	return !strings.Contains(key, "\\")
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// testBackend runs the same set of checks against any backend implementation.
+func testBackend(t *testing.T, b Backend) {
+	data := []byte("some file contents")
+
+	n, err := b.Put("user/file.txt", bytes.NewReader(data))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n != int64(len(data)) {
+		t.Errorf("wrong amount of bytes written. want=%d, got=%d", len(data), n)
+		return
+	}
+
+	if _, err := b.Put("user/other.txt", strings.NewReader("other")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := b.Put("another/file.txt", strings.NewReader("another")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	rc, err := b.Get("user/file.txt")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("wrong data returned. want=%q, got=%q", data, got)
+		return
+	}
+
+	testRanges(t, b, "user/file.txt", data)
+
+	testMove(t, b)
+
+	info, err := b.Stat("user/file.txt")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if info.Size != int64(len(data)) {
+		t.Errorf("wrong size in stat. want=%d, got=%d", len(data), info.Size)
+		return
+	}
+
+	objects, err := b.List("user/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(objects) != 2 || objects[0].Key != "user/file.txt" || objects[1].Key != "user/other.txt" {
+		t.Errorf("wrong objects listed: %v", objects)
+		return
+	}
+
+	if err := DeletePrefix(b, "user/"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := b.Get("user/file.txt"); err != ErrNotFound {
+		t.Errorf("expected a not found error after deletion, got: %v", err)
+		return
+	}
+
+	if _, err := b.Stat("another/file.txt"); err != nil {
+		t.Error("an object with a different prefix was deleted", err)
+		return
+	}
+
+	// Deleting something that doesn't exist should not fail.
+	if err := b.Delete("user/file.txt"); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+// testMove checks that an object can be moved to another key.
+func testMove(t *testing.T, b Backend) {
+	if _, err := b.Put("moved/src.txt", strings.NewReader("moved data")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := Move(b, "moved/src.txt", "moved/dir/dst.txt"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := b.Stat("moved/src.txt"); err != ErrNotFound {
+		t.Errorf("expected the source to be removed, got: %v", err)
+		return
+	}
+
+	rc, err := b.Get("moved/dir/dst.txt")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(got) != "moved data" {
+		t.Errorf("wrong data after moving. want=%q, got=%q, err: %v", "moved data", got, err)
+		return
+	}
+
+	if err := DeletePrefix(b, "moved/"); err != nil {
+		t.Error(err)
+	}
+}
+
+// testRanges checks that parts of the object can be read with GetRange and an ObjectReader.
+func testRanges(t *testing.T, b Backend, key string, data []byte) {
+	rc, err := GetRange(b, key, 5, 4)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, data[5:9]) {
+		t.Errorf("wrong range returned. want=%q, got=%q, err: %v", data[5:9], got, err)
+		return
+	}
+
+	obj, err := Open(b, key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer obj.Close()
+
+	if obj.Size() != int64(len(data)) {
+		t.Errorf("wrong size. want=%d, got=%d", len(data), obj.Size())
+		return
+	}
+
+	buf := make([]byte, 4)
+	for _, offset := range []int64{10, 0, 14} {
+		if _, err := obj.Seek(offset, io.SeekStart); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := io.ReadFull(obj, buf); err != nil || !bytes.Equal(buf, data[offset:offset+4]) {
+			t.Errorf("wrong data at %d. want=%q, got=%q, err: %v", offset, data[offset:offset+4], buf, err)
+			return
+		}
+	}
+
+	if _, err := obj.Read(buf); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got: %v", err)
+	}
+
+	if _, err := GetRange(b, key, -1, 4); err != ErrInvalidRange {
+		t.Errorf("expected an invalid range error for a negative offset, got: %v", err)
+	}
+
+	for _, r := range [][2]int64{{int64(len(data)), 4}, {int64(len(data)) + 1, 4}, {0, -1}} {
+		rc, err := GetRange(b, key, r[0], r[1])
+		if err != nil {
+			t.Errorf("unexpected error for the range %v: %v", r, err)
+			continue
+		}
+
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || len(got) != 0 {
+			t.Errorf("expected an empty range for %v, got=%q, err: %v", r, got, err)
+		}
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	testBackend(t, NewLocal(t.TempDir()))
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+func TestInvalidKeys(t *testing.T) {
+	b := NewLocal(t.TempDir())
+	keys := []string{"", "../escape", "user/../../escape", "/absolute", "user/", "user//file"}
+
+	for _, key := range keys {
+		if _, err := b.Put(key, strings.NewReader("data")); err != ErrInvalidKey {
+			t.Errorf("expected an invalid key error for %q, got: %v", key, err)
+		}
+	}
+}
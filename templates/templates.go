@@ -4,6 +4,8 @@ import (
 	"embed"
 	"html/template"
 	"io"
+	"net/url"
+	"time"
 
 	"github.com/nireo/upfi/lib"
 	"github.com/nireo/upfi/models"
@@ -27,8 +29,14 @@ var (
 	fileSingle = parse("single_file_template.html")
 	upload     = parse("upload.html")
 	sharePage  = parse("share_file.html")
+	trash      = parse("trash.html")
+	shareLink  = parse("share_link.html")
+	groups     = parse("groups.html")
+	group      = parse("group.html")
+	tags       = parse("tags.html")
 
-	settings = parse("settings_template.html")
+	settings   = parse("settings_template.html")
+	adminUsers = parse("admin_users.html")
 
 	login    = parse("login.html")
 	register = parse("register.html")
@@ -41,7 +49,27 @@ var (
 type FilesParams struct {
 	Title         string
 	Files         []models.File
+	Tags          map[uint][]string // the names of the files' tags by the ids of the files
 	Authenticated bool
+
+	// The folder whose contents are shown, nil for the top level. The folders are only shown on the
+	// user's own files page.
+	ShowFolders bool
+	Folder      *models.Folder
+	Folders     []models.Folder
+	AllFolders  []models.Folder
+	Breadcrumbs []models.Folder
+
+	// The groups of the user, with which the folders can be shared.
+	Groups []models.MemberGroup
+
+	// Path is the path of the list, to which the search form is sent. Query has the search, the filters
+	// and the sort of the list, and NextPage is the path of the next page, empty on the last page.
+	// Searching tells that the results are from all of the folders.
+	Path      string
+	Query     url.Values
+	NextPage  string
+	Searching bool
 }
 
 // Files renders the files template file
@@ -49,11 +77,96 @@ func Files(w io.Writer, params FilesParams) error {
 	return filesPage.Execute(w, params)
 }
 
+// ShareLinkParams contains all of the parameters to the public page of a share link.
+type ShareLinkParams struct {
+	Title         string
+	Authenticated bool
+	File          models.File
+	Token         string
+	NeedsPassword bool
+}
+
+// ShareLink renders the share link template file
+func ShareLink(w io.Writer, params ShareLinkParams) error {
+	return shareLink.Execute(w, params)
+}
+
+// GroupsParams contains all of the parameters to the groups page.
+type GroupsParams struct {
+	Title         string
+	Authenticated bool
+	Groups        []models.MemberGroup
+}
+
+// Groups renders the groups template file
+func Groups(w io.Writer, params GroupsParams) error {
+	return groups.Execute(w, params)
+}
+
+// GroupParams contains all of the parameters to the page of a single group.
+type GroupParams struct {
+	Title         string
+	Authenticated bool
+	Group         *models.Group
+	UserID        uint // the id of the user viewing the page, who can leave the group.
+	Owner         bool // the owners can change the roles and delete the group.
+	CanManage     bool // the owners and the admins can add and remove members.
+	Members       []models.GroupMember
+	Files         []models.File
+	Folders       []models.Folder
+}
+
+// Group renders the group template file
+func Group(w io.Writer, params GroupParams) error {
+	return group.Execute(w, params)
+}
+
+// TagsParams contains all of the parameters to the tags page.
+type TagsParams struct {
+	Title         string
+	Authenticated bool
+	Tags          []models.TagCount
+}
+
+// Tags renders the tags template file
+func Tags(w io.Writer, params TagsParams) error {
+	return tags.Execute(w, params)
+}
+
+// TrashParams contains all of the parameters to the trash page.
+type TrashParams struct {
+	Title         string
+	Authenticated bool
+	Files         []TrashRow
+	TrashDays     int
+}
+
+// TrashRow contains a file in the trash along with the times it was trashed and when it will be purged.
+// PurgeAt is zero if the file is kept until the trash is emptied.
+type TrashRow struct {
+	File      models.File
+	TrashedAt time.Time
+	PurgeAt   time.Time
+}
+
+// Trash renders the trash template file
+func Trash(w io.Writer, params TrashParams) error {
+	return trash.Execute(w, params)
+}
+
 // SingleFileParams contains all of the parameters to the single file page.
 type SingleFileParams struct {
 	Title         string
 	File          models.File
 	Authenticated bool
+	Owner         bool
+	CanDownload   bool // the owner and the recipients with the download permission can download the file.
+	CanEdit       bool // the owner and the recipients with the edit permission can edit the file and its tags.
+	CanUpload     bool // the owner and the recipients with the upload permission can upload new versions.
+	Tags          []string
+	Versions      []models.FileVersion
+	Links         []models.ShareLink
+	CSRFToken     string // sent in a header with the new versions, so that they can be streamed
 }
 
 // SingleFile renders the single file template file
@@ -73,9 +186,26 @@ func Home(w io.Writer, params HomeParams) error {
 
 // SettingsParams contains all of the parameters to the settings page.
 type SettingsParams struct {
-	Title         string
-	User          *models.User
-	Authenticated bool
+	Title               string
+	User                *models.User
+	Authenticated       bool
+	MasterChangePending bool
+	Tokens              []models.APIToken
+	Scopes              []string
+	NewToken            string // A newly created api token, which is shown only once.
+	Sessions            []models.Session
+	CurrentSession      string // The token id of the session the page is viewed with.
+	Used                string
+	Quota               string
+	Usage               []UsageRow
+	VersionsUsed        string
+}
+
+// UsageRow contains the storage usage of a single mimetype.
+type UsageRow struct {
+	MIME  string
+	Files int64
+	Size  string
 }
 
 // Settings renders the settings template file
@@ -83,6 +213,29 @@ func Settings(w io.Writer, params SettingsParams) error {
 	return settings.Execute(w, params)
 }
 
+// AdminUsersParams contains all of the parameters to the admin's user list.
+type AdminUsersParams struct {
+	Title         string
+	Authenticated bool
+	Users         []AdminUserRow
+	DefaultQuota  string
+}
+
+// AdminUserRow contains the storage usage and the quota of a single user.
+type AdminUserRow struct {
+	UUID     string
+	Username string
+	Admin    bool
+	Used     string
+	Quota    string
+	Default  bool // The user has the default quota.
+}
+
+// AdminUsers renders the admin's user list template file
+func AdminUsers(w io.Writer, params AdminUsersParams) error {
+	return adminUsers.Execute(w, params)
+}
+
 // LoginParams contains parameters for the login page
 type LoginParams struct {
 	Authenticated bool
@@ -109,6 +262,9 @@ func Register(w io.Writer, params RegisterParams) error {
 type UploadParams struct {
 	Authenticated bool
 	Title         string
+	MaxSize       string
+	Folder        string // the folder in which the file is uploaded
+	CSRFToken     string // sent in a header, so that the upload can be streamed
 }
 
 // Upload renders the upload template file
@@ -147,7 +303,11 @@ func Success(w io.Writer, params SuccessPage) error {
 type ShareFilePage struct {
 	Authenticated bool
 	FileID        string // this needed is to send the request to share the correct file.
+	Encrypted     bool   // encrypted files need the master password to seal the file's key.
 	Title         string
+
+	// The groups of the user, with which unencrypted files can be shared.
+	Groups []models.MemberGroup
 }
 
 // SharePage renders the share_file.html file where the user can share a file to another user.
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/templates"
+)
+
+// adminUser finds the user who made the request and makes sure that they are an admin.
+func adminUser(r *http.Request) (*models.User, error) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Admin {
+		return nil, errNoAccess
+	}
+
+	return user, nil
+}
+
+// ServeAdminUsers serves the admins a page listing all of the users along with their storage usage and
+// quota.
+func ServeAdminUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if _, err := adminUser(r); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	users, err := models.FindUsers()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	rows := make([]templates.AdminUserRow, len(users))
+	for i, user := range users {
+		rows[i] = templates.AdminUserRow{
+			UUID:     user.UUID,
+			Username: user.Username,
+			Admin:    user.Admin,
+			Used:     formatFileSize(user.UsedBytes),
+			Quota:    formatQuota(user.Quota()),
+			Default:  user.QuotaBytes == nil,
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	templates.AdminUsers(w, templates.AdminUsersParams{
+		Title:         "users",
+		Authenticated: true,
+		Users:         rows,
+		DefaultQuota:  formatQuota(models.DefaultQuota),
+	})
+}
+
+// SetUserQuota changes the quota of a user. Only admins can change the quotas. An empty quota makes the
+// user use the default quota again.
+func SetUserQuota(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if _, err := adminUser(r); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	user, err := models.FindOneUser(&models.User{UUID: r.FormValue("user")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	var quota *int64
+	if value := strings.TrimSpace(r.FormValue("quota")); value != "" {
+		size, err := parseFileSize(value)
+		if err != nil {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+			return
+		}
+		quota = &size
+	}
+
+	if err := user.SetQuota(quota); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// formatQuota formats a quota in a human readable format. A zero quota means that the storage is not
+// limited.
+func formatQuota(quota int64) string {
+	if quota == 0 {
+		return "Unlimited"
+	}
+
+	return formatFileSize(quota)
+}
+
+// parseFileSize parses a size such as '500 MB' or '2GB' into bytes. The units are the same as the ones
+// formatFileSize uses, and a plain number is read as bytes.
+func parseFileSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRight(value, "kKMGTPEB ")
+	unit := strings.ToUpper(strings.TrimSpace(value[len(number):]))
+
+	multiplier := int64(1)
+	if unit != "" && unit != "B" {
+		exp := strings.IndexByte("KMGTPE", unit[0])
+		if exp < 0 || unit[1:] != "B" && unit[1:] != "" {
+			return 0, errInvalidInput
+		}
+
+		for i := 0; i <= exp; i++ {
+			multiplier *= 1000
+		}
+	}
+
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || size < 0 || size*float64(multiplier) > float64(1<<62) {
+		return 0, errInvalidInput
+	}
+
+	return int64(size * float64(multiplier)), nil
+}
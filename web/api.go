@@ -0,0 +1,65 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+)
+
+// The json api lives under /api/v1 and mirrors the html handlers. The handlers share the same logic
+// as the html handlers, but respond with json. Errors are returned as json objects built from the same
+// error contents which are shown on the error pages:
+//
+//	{"error": {"status": 404, "message": "Not Found", "description": "..."}}
+
+// maxJSONBodySize limits the size of the json request bodies.
+const maxJSONBodySize = 1 << 20
+
+// APIErrorHandler is the json api counterpart of ErrorPageHandler.
+func APIErrorHandler(w http.ResponseWriter, err error) {
+	lib.WriteErrorJSON(w, errorContent(err))
+}
+
+// decodeJSON decodes the json request body into the given value. Bodies which cannot be decoded are
+// reported as invalid input.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodySize)).Decode(v); err != nil {
+		return errInvalidInput
+	}
+
+	return nil
+}
+
+// apiUser finds the user who is making the request. The auth token middleware appends the user's
+// username in to the request header.
+func apiUser(r *http.Request) (*models.User, error) {
+	return models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+}
+
+// serializeFiles serializes the files along with their tags.
+func serializeFiles(files []models.File) ([]lib.JSON, error) {
+	tags, err := models.FindFileTags(files)
+	if err != nil {
+		return nil, err
+	}
+
+	serialized := make([]lib.JSON, 0, len(files))
+	for i := range files {
+		serialized = append(serialized, serializeFile(&files[i], tags[files[i].ID]))
+	}
+
+	return serialized, nil
+}
+
+// serializeFile serializes the file along with the given tags.
+func serializeFile(file *models.File, tags []string) lib.JSON {
+	serialized := file.Serialize()
+	if tags == nil {
+		tags = []string{}
+	}
+	serialized["tags"] = tags
+
+	return serialized
+}
@@ -0,0 +1,272 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+)
+
+// APIListFiles returns the user's files in all of the folders. The files can be searched, filtered,
+// sorted and split into pages with the same queries as the files page. All of the files are returned,
+// if the 'limit' query is not given.
+func APIListFiles(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	query, err := parseFileQuery(r, 0)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	page, err := user.SearchFiles(query)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	writeFilePage(w, page)
+}
+
+// writeFilePage writes the files on the page, and the cursor of the next page if there is one.
+func writeFilePage(w http.ResponseWriter, page *models.FilePage) {
+	files, err := serializeFiles(page.Files)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	body := lib.JSON{"files": files}
+	if page.Next != "" {
+		body["next"] = page.Next
+	}
+
+	lib.WriteJSON(w, http.StatusOK, body)
+}
+
+// writeFile writes the file along with its tags.
+func writeFile(w http.ResponseWriter, status int, file *models.File) {
+	tags, err := file.FindTags()
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	lib.WriteJSON(w, status, serializeFile(file, tags))
+}
+
+// APIGetFile returns a single file, which the user owns or which has been shared to them.
+func APIGetFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	file, _, err := findAccessibleFile(user, ps.ByName("file"), models.PermissionView)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	writeFile(w, http.StatusOK, file)
+}
+
+// APIUploadFile uploads a file from a multipart form, which has the same 'file', 'master' and
+// 'description' fields as the upload page. The file is encrypted if the master password is given. The
+// form is streamed, so the fields need to come before the file.
+func APIUploadFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	file, err := receiveFile(r, user)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	lib.WriteJSON(w, http.StatusCreated, serializeFile(file, nil))
+}
+
+// APIDownloadFile sends the contents of the file. Encrypted files need the owner's master password, or
+// the recipient's own master password for the files shared to them, which is given in a json body: {"master": "..."}. GET requests give the master password in the
+// Upfi-Master header instead, so that range requests can be made by clients which don't send a body.
+func APIDownloadFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	file, owner, err := findAccessibleFile(user, ps.ByName("file"), models.PermissionDownload)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	var body struct {
+		Master string `json:"master"`
+	}
+	if r.Method == http.MethodGet {
+		body.Master = r.Header.Get(masterHeader)
+	} else if !file.ShareableFile {
+		if err := decodeJSON(w, r, &body); err != nil {
+			APIErrorHandler(w, err)
+			return
+		}
+	}
+
+	if err := sendAccessibleFile(w, r, user, file, owner, body.Master); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+}
+
+// APIUpdateFile updates the filename, the description and the tags of a file owned by the user or shared
+// to the user with the edit permission. Only the fields which are given are updated:
+// {"filename": "...", "description": "...", "tags": ["..."]}. An empty list of tags removes all of them.
+func APIUpdateFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	var body struct {
+		Filename    string   `json:"filename"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	file, err := updateFile(user, ps.ByName("file"), body.Filename, body.Description, body.Tags)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	writeFile(w, http.StatusOK, file)
+}
+
+// APIDeleteFile moves a file owned by the user into the trash.
+func APIDeleteFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	file, err := findOwnedFile(user, ps.ByName("file"))
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if err := file.Trash(); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIShareFile shares a file owned by the user to another user: {"username": "...", "master": "...",
+// "permission": "...", "expires": hours}. The master password is only needed for encrypted files. The
+// share has the download permission and never expires, if they are not given.
+func APIShareFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	var body struct {
+		Username   string `json:"username"`
+		Master     string `json:"master"`
+		Permission string `json:"permission"`
+		Expires    int    `json:"expires"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if body.Expires < 0 {
+		APIErrorHandler(w, errInvalidInput)
+		return
+	}
+
+	var expiresAt *time.Time
+	if body.Expires > 0 {
+		expiration := time.Now().Add(time.Duration(body.Expires) * time.Hour)
+		expiresAt = &expiration
+	}
+
+	if err := shareFile(user, ps.ByName("file"), body.Username, body.Master, body.Permission, expiresAt); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListShared returns the files shared by the user, or the files shared to the user, depending on
+// whether the type is "by" or "to". The same queries as in APIListFiles can be used.
+func APIListShared(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	query, err := parseFileQuery(r, 0)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	var page *models.FilePage
+	switch ps.ByName("type") {
+	case "by":
+		page, err = user.FindSharedByFiles(query)
+	case "to":
+		page, err = user.FindSharedToFiles(query)
+	default:
+		err = errInvalidInput
+	}
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	writeFilePage(w, page)
+}
+
+// APIDeleteShare removes a share of a file shared by the user or shared to the user, depending on
+// whether the type is "by" or "to".
+func APIDeleteShare(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if err := deleteShare(user, ps.ByName("type"), ps.ByName("file")); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,212 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/models"
+	"gorm.io/gorm"
+)
+
+// decodeError decodes the json error returned by the api.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) (status int, message string) {
+	t.Helper()
+
+	var body struct {
+		Error struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal("could not decode the json error, err: ", err)
+	}
+
+	return body.Error.Status, body.Error.Message
+}
+
+func TestAPIErrorHandler(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{gorm.ErrRecordNotFound, http.StatusNotFound},
+		{errInvalidInput, http.StatusBadRequest},
+		{errNoAccess, http.StatusForbidden},
+		{models.ErrQuotaExceeded, http.StatusRequestEntityTooLarge},
+		{errors.New("unexpected"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		APIErrorHandler(rec, test.err)
+
+		if rec.Code != test.status {
+			t.Errorf("wrong status code for %v. want=%d, got=%d", test.err, test.status, rec.Code)
+		}
+
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("wrong content type for %v: %q", test.err, contentType)
+		}
+
+		if status, message := decodeError(t, rec); status != test.status || message == "" {
+			t.Errorf("wrong json error for %v. status=%d, message=%q", test.err, status, message)
+		}
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var body struct {
+		Filename string `json:"filename"`
+	}
+
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/files/id", strings.NewReader(`{"filename": "new.txt"}`))
+	if err := decodeJSON(httptest.NewRecorder(), r, &body); err != nil || body.Filename != "new.txt" {
+		t.Errorf("could not decode the body. filename=%q, err: %v", body.Filename, err)
+	}
+
+	invalid := []string{
+		`{"filename": `,
+		`{"filename": "` + strings.Repeat("a", maxJSONBodySize) + `"}`,
+	}
+	for _, data := range invalid {
+		r := httptest.NewRequest(http.MethodPatch, "/api/v1/files/id", strings.NewReader(data))
+		if err := decodeJSON(httptest.NewRecorder(), r, &body); err != errInvalidInput {
+			t.Errorf("expected invalid input for a body of %d bytes, got: %v", len(data), err)
+		}
+	}
+}
+
+func TestEmptyShareFileIDsAreRejected(t *testing.T) {
+	user := &models.User{Username: "owner"}
+
+	for _, toOrBy := range []string{"to", "by"} {
+		if err := deleteShare(user, toOrBy, ""); err != errInvalidInput {
+			t.Errorf("deleteShare(%q): expected invalid input, got: %v", toOrBy, err)
+		}
+	}
+
+	if err := shareFile(user, "", "recipient", "", "", nil); err != errInvalidInput {
+		t.Errorf("shareFile: expected invalid input, got: %v", err)
+	}
+}
+
+func TestAPIRequiresAuthentication(t *testing.T) {
+	routes := []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/api/v1/files"},
+		{http.MethodGet, "/api/v1/files/id"},
+		{http.MethodDelete, "/api/v1/files/id"},
+		{http.MethodGet, "/api/v1/account"},
+	}
+
+	for _, route := range routes {
+		rec := serve(t, nil, httptest.NewRequest(route.method, route.path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("wrong status code for %s %s. want=401, got=%d", route.method, route.path, rec.Code)
+			continue
+		}
+
+		if status, _ := decodeError(t, rec); status != http.StatusUnauthorized {
+			t.Errorf("wrong json error for %s %s: %d", route.method, route.path, status)
+		}
+	}
+}
+
+func TestSerializeFileWithoutTags(t *testing.T) {
+	serialized := serializeFile(&models.File{UUID: "id", ShareableFile: true}, nil)
+
+	data, err := json.Marshal(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(data, []byte(`"tags":[]`)) {
+		t.Errorf("the tags should be an empty list, got: %s", data)
+	}
+}
+
+func TestAPIFileLifecycle(t *testing.T) {
+	user := newTestUser(t)
+
+	// Upload a file with the same multipart form as the upload page.
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("description", "api upload")
+	part, err := mw.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("some notes"))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/files", &form)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := serve(t, user, r)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("could not upload the file. status=%d, body=%s", rec.Code, rec.Body)
+	}
+
+	var uploaded struct {
+		UUID        string `json:"uuid"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&uploaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if uploaded.UUID == "" || uploaded.Description != "api upload" {
+		t.Fatalf("wrong file returned: %+v", uploaded)
+	}
+
+	// Update the description and the tags, and check that they are returned.
+	r = httptest.NewRequest(http.MethodPatch, "/api/v1/files/"+uploaded.UUID,
+		strings.NewReader(`{"description": "changed", "tags": ["work", "work", " notes "]}`))
+	rec = serve(t, user, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("could not update the file. status=%d, body=%s", rec.Code, rec.Body)
+	}
+
+	var updated struct {
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Description != "changed" || strings.Join(updated.Tags, ",") != "notes,work" {
+		t.Errorf("wrong updated file: %+v", updated)
+	}
+
+	// The file can be downloaded.
+	rec = serve(t, user, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+uploaded.UUID+"/download", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "some notes" {
+		t.Errorf("wrong download. status=%d, body=%q", rec.Code, rec.Body)
+	}
+
+	// Another user cannot see the file.
+	other := newTestUser(t)
+	rec = serve(t, other, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+uploaded.UUID, nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("another user should not get the file. status=%d", rec.Code)
+	}
+
+	// The deleted file is not found anymore.
+	rec = serve(t, user, httptest.NewRequest(http.MethodDelete, "/api/v1/files/"+uploaded.UUID, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("could not delete the file. status=%d, body=%s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, user, httptest.NewRequest(http.MethodGet, "/api/v1/files/"+uploaded.UUID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("the deleted file should not be found. status=%d", rec.Code)
+	}
+}
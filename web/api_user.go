@@ -0,0 +1,110 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+)
+
+// APIGetAccount returns the user's account information.
+func APIGetAccount(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	lib.WriteJSON(w, http.StatusOK, user.Serialize())
+}
+
+// APIUpdateAccount changes the user's username: {"username": "..."}.
+func APIUpdateAccount(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if err := changeUsername(user, body.Username); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	lib.WriteJSON(w, http.StatusOK, user.Serialize())
+}
+
+// APIUpdatePassword changes the user's login password: {"password": "...", "new_password": "..."}.
+func APIUpdatePassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	var body struct {
+		Password    string `json:"password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if err := changePassword(user, body.Password, body.NewPassword, r.Header.Get("session")); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIChangeMaster changes the user's master password and re-wraps the data keys of the encrypted
+// files: {"master": "...", "new_master": "..."}.
+func APIChangeMaster(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	var body struct {
+		Master    string `json:"master"`
+		NewMaster string `json:"new_master"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if err := changeMaster(user, body.Master, body.NewMaster); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIDeleteAccount deletes the user and all of their files.
+func APIDeleteAccount(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if err := user.Delete(); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,7 +3,6 @@ package web
 import (
 	"fmt"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -31,8 +30,7 @@ func ServeLoginPage(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 }
 
 // Register handles the register request from the /register page html form. It creates checks for conflicting
-// usernames and creates a folder to the store all of the user's files in. Finally it creates a database entry
-// with all the information in given in the form.
+// usernames and then creates a database entry with all the information in given in the form.
 func Register(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// check if the user is already logged in
 	if lib.IsAuth(r) {
@@ -95,30 +93,32 @@ func Register(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		UUID:                 lib.GenerateUUID(),
 	}
 
-	// Use that unique id to create a folder in the files directory that in the future will contain all of the
-	// user's files.
-	err = os.Mkdir(lib.AddRootToPath("files/")+newUser.UUID, 0755)
-	if err != nil {
+	// Finally save the entry. The user's files are stored under the unique id in the storage backend, so
+	// nothing needs to be created there beforehand.
+	db := lib.GetDatabase()
+	if err := db.Create(&newUser).Error; err != nil {
 		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 		return
 	}
 
-	// Finally save that entry after creating the folder, since the folder creation is more likely to fail.
-	db := lib.GetDatabase()
-	db.Create(&newUser)
-
-	// Create a new authentication token for the user so that he/she can use authenticated routes.
-	token, err := lib.CreateToken(newUser.Username)
+	// Create the key pair used to share encrypted files, so that other users can share files to the new user
+	// right away.
+	kek, err := newUser.MasterKey(masterPass)
 	if err != nil {
 		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 		return
 	}
 
-	// Use the token we created before and store it in a cookie, which will be checked when accessing
-	// authenticated routes.
-	expirationTime := time.Now().Add(time.Hour * 24)
-	cookie := http.Cookie{Name: "token", Value: token, Expires: expirationTime}
-	http.SetCookie(w, &cookie)
+	if _, err := newUser.PrivateKey(kek); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	// Create a new session for the user so that he/she can use authenticated routes.
+	if err := startSession(w, r, &newUser); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
 
 	successParams := templates.SuccessPage{
 		Title:         "Successfully registered",
@@ -172,19 +172,12 @@ func Login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
-	// Create a new authentication token for the user so that he/she can use authenticated routes.
-	token, err := lib.CreateToken(user.Username)
-	if err != nil {
+	// Create a new session for the user so that he/she can use authenticated routes.
+	if err := startSession(w, r, user); err != nil {
 		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 		return
 	}
 
-	// Use the token we created before and store it in a cookie, which will be checked when accessing
-	// authenticated routes.
-	expirationTime := time.Now().Add(time.Hour * 24)
-	cookie := http.Cookie{Name: "token", Value: token, Expires: expirationTime}
-	http.SetCookie(w, &cookie)
-
 	// Redirect the new user to the files page where the user can add new files.
 	successParams := templates.SuccessPage{
 		Title:         "Successfully logged in",
@@ -197,3 +190,52 @@ func Login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		fmt.Println(err)
 	}
 }
+
+// Logout ends the current session, so that its token cannot be used anymore even if it was copied
+// somewhere, and removes the token cookie.
+func Logout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// The auth token middleware appends the token id of the session in to the request header.
+	if err := models.RevokeSession(r.Header.Get("session")); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	clearTokenCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// startSession creates a new session for the user, and stores a token belonging to the session into a
+// cookie, which will be checked when accessing authenticated routes.
+func startSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session, err := models.CreateSession(user, r.UserAgent())
+	if err != nil {
+		return err
+	}
+
+	token, err := lib.CreateToken(user.Username, session.TokenID)
+	if err != nil {
+		return err
+	}
+
+	cookie := http.Cookie{
+		Name:     "token",
+		Value:    token,
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+
+	return nil
+}
+
+// clearTokenCookie removes the user's authentication cookie.
+func clearTokenCookie(w http.ResponseWriter) {
+	c := &http.Cookie{
+		Name:    "token",
+		Value:   "",
+		Expires: time.Unix(0, 0),
+
+		HttpOnly: true,
+	}
+	http.SetCookie(w, c)
+}
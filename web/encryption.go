@@ -0,0 +1,286 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"log"
+
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
+)
+
+// errWrongMaster is returned when the given master password doesn't match the owner's master password.
+var errWrongMaster = errors.New("wrong master password")
+
+// storeWithDataKey encrypts the data with the given data key while it's being stored under the key in
+// the storage backend.
+func storeWithDataKey(key string, src io.Reader, dataKey []byte) error {
+	encrypted := crypt.EncryptReaderWithKey(src, dataKey)
+	defer encrypted.Close()
+
+	_, err := storage.GetBackend().Put(key, encrypted)
+	return err
+}
+
+// unlockMaster checks the user's master password and derives the key encryption key from it. The user
+// gets a key pair for sharing encrypted files, if they don't have one yet. If the master password is
+// wrong, errWrongMaster is returned.
+func unlockMaster(user *models.User, master string) ([]byte, error) {
+	if !lib.CheckPasswordHash(master, user.FileEncryptionMaster) {
+		return nil, errWrongMaster
+	}
+
+	kek, err := user.MasterKey(master)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := user.PrivateKey(kek); err != nil {
+		return nil, err
+	}
+
+	return kek, nil
+}
+
+// sealForRecipient unwraps the data key of the owner's encrypted file using the owner's master password,
+// and seals it to the recipient's public key. Files encrypted with the master password itself are first
+// upgraded to use a data key.
+func sealForRecipient(file *models.File, owner, recipient *models.User, master string) ([]byte, error) {
+	kek, err := unlockMaster(owner, master)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := upgradeEncryption(file, owner.UUID, kek, master); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := file.DataKey(kek)
+	if err != nil {
+		if errors.Is(err, crypt.ErrInvalidCiphertext) {
+			return nil, errWrongMaster
+		}
+		return nil, err
+	}
+
+	return models.SealKeyFor(recipient, dataKey)
+}
+
+// openEncrypted unwraps the data key of an encrypted file using the owner's master password and
+// returns a reader to the decrypted contents of the file, which can seek. Files encrypted with the
+// master password itself are first upgraded to use a data key. If the master password is wrong,
+// errWrongMaster is returned.
+func openEncrypted(file *models.File, owner *models.User, master string) (io.ReadSeekCloser, error) {
+	kek, err := owner.MasterKey(master)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := upgradeEncryption(file, owner.UUID, kek, master); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := file.DataKey(kek)
+	if err != nil {
+		if errors.Is(err, crypt.ErrInvalidCiphertext) {
+			return nil, errWrongMaster
+		}
+		return nil, err
+	}
+
+	return openWithDataKey(file.StorageKey(owner.UUID), dataKey)
+}
+
+// openShared unwraps the data key of an encrypted file shared to the recipient using the recipient's own
+// master password and private key, and returns a reader to the decrypted contents of the file. If the
+// master password is wrong, errWrongMaster is returned.
+func openShared(file *models.File, owner *models.User, share *models.FileShare, recipient *models.User,
+	master string) (io.ReadSeekCloser, error) {
+	dataKey, err := sharedDataKey(share, recipient, master)
+	if err != nil {
+		return nil, err
+	}
+
+	return openWithDataKey(file.StorageKey(owner.UUID), dataKey)
+}
+
+// sharedDataKey unwraps the data key sealed to the recipient using the recipient's own master password
+// and private key. If the master password is wrong, errWrongMaster is returned.
+func sharedDataKey(share *models.FileShare, recipient *models.User, master string) ([]byte, error) {
+	kek, err := unlockMaster(recipient, master)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := recipient.PrivateKey(kek)
+	if err != nil {
+		if errors.Is(err, crypt.ErrInvalidCiphertext) {
+			return nil, errWrongMaster
+		}
+		return nil, err
+	}
+
+	return share.DataKey(privateKey)
+}
+
+// openWithDataKey returns a reader to the decrypted contents stored under the key, which can seek.
+func openWithDataKey(key string, dataKey []byte) (io.ReadSeekCloser, error) {
+	blob, err := storage.Open(storage.GetBackend(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := crypt.NewKeyReadSeeker(blob, dataKey)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{dec, blob}, nil
+}
+
+// upgradeEncryption re-encrypts a file, which was encrypted using the master password, with a new random
+// data key. The older formats derived the key from the master password, so the files are upgraded the
+// next time the user supplies their master password. Files which already use a data key are left as
+// they are. If the master password is wrong, errWrongMaster is returned.
+func upgradeEncryption(file *models.File, ownerUUID string, kek []byte, master string) error {
+	// The contents are only read for the files which don't have a data key yet, so that the upgraded
+	// files don't need an extra request to the storage backend.
+	upgraded, err := file.HasDataKey()
+	if err != nil {
+		return err
+	}
+
+	if upgraded {
+		return nil
+	}
+
+	backend := storage.GetBackend()
+
+	key := file.StorageKey(ownerUUID)
+	blob, err := backend.Get(key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	info, blobReader, err := crypt.Inspect(blob)
+	if err != nil {
+		return err
+	}
+
+	if info.DataKey {
+		return nil
+	}
+
+	dec, err := crypt.NewReader(blobReader, master)
+	if err != nil {
+		if errors.Is(err, crypt.ErrInvalidCiphertext) {
+			return errWrongMaster
+		}
+		return err
+	}
+
+	dataKey, err := crypt.GenerateDataKey()
+	if err != nil {
+		return err
+	}
+
+	// The contents are stored under a new key, and the whole file is decrypted while doing so. Nothing
+	// is stored in the database before the decryption has succeeded, so with a wrong master password or
+	// a damaged file, the file is left as it was.
+	hash := newContentHash(dataKey)
+	newKey := ownerUUID + "/" + lib.GenerateUUID() + file.Extension
+	if err := storeWithDataKey(newKey, io.TeeReader(dec, hash), dataKey); err != nil {
+		backend.Delete(newKey)
+		if errors.Is(err, crypt.ErrInvalidCiphertext) {
+			return errWrongMaster
+		}
+		return err
+	}
+
+	// The data key and the new contents are taken into use together. If the file was upgraded or
+	// replaced meanwhile, the new contents are not needed.
+	if err := file.UpgradeContent(newKey, encodeContentHash(hash.Sum(nil), dataKey), kek, dataKey); err != nil {
+		backend.Delete(newKey)
+		if !errors.Is(err, models.ErrContentChanged) {
+			return err
+		}
+
+		return lib.GetDatabase().First(file, file.ID).Error
+	}
+
+	if err := backend.Delete(key); err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
+
+// rekeyFiles wraps the data keys of all of the user's encrypted files with the new master password and
+// then takes the new master password into use. Files encrypted with the master password itself are
+// upgraded to use a data key first. The keys of the encrypted uploads in progress are wrapped as well, so
+// that they can be resumed. The files which were uploaded while re-keying are picked up by running
+// through the files again.
+func rekeyFiles(user *models.User, job *models.MasterKeyChange, master, newMaster string) error {
+	kek, err := user.MasterKey(master)
+	if err != nil {
+		return err
+	}
+
+	newKek, err := job.MasterKey(newMaster)
+	if err != nil {
+		return err
+	}
+
+	// The private key is wrapped with the new master password as well, so that the files shared to the
+	// user can still be opened.
+	privateKey, err := user.PrivateKey(kek)
+	if err != nil {
+		return err
+	}
+
+	if err := job.SetPrivateKey(newKek, privateKey); err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		files, err := user.EncryptedFiles()
+		if err != nil {
+			return err
+		}
+
+		for i := range files {
+			file := &files[i]
+			if err := upgradeEncryption(file, user.UUID, kek, master); err != nil {
+				return err
+			}
+
+			if err := job.Rewrap(file, kek, newKek); err != nil {
+				return err
+			}
+		}
+
+		uploads, err := user.EncryptedUploads()
+		if err != nil {
+			return err
+		}
+
+		for i := range uploads {
+			if err := job.RewrapUpload(&uploads[i], kek, newKek); err != nil {
+				return err
+			}
+		}
+
+		if err := job.Commit(); err != models.ErrRekeyIncomplete {
+			return err
+		}
+	}
+
+	return models.ErrRekeyIncomplete
+}
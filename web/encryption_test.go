@@ -0,0 +1,192 @@
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
+)
+
+// newTestEncryptedFile stores an encrypted file with the given contents for the user, whose master
+// password is testMaster.
+func newTestEncryptedFile(t *testing.T, user *models.User, filename, contents string) *models.File {
+	t.Helper()
+
+	kek, err := unlockMaster(user, testMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := newFileEntry(user, filename, "", "text/plain; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storeFile(user, file, strings.NewReader(contents), kek); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// newTestLegacyFile stores a file encrypted with the master password itself, like the files stored before
+// the data keys were added.
+func newTestLegacyFile(t *testing.T, user *models.User, filename, contents string) *models.File {
+	t.Helper()
+
+	file, err := newFileEntry(user, filename, "", "text/plain; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Size = int64(len(contents))
+
+	encrypted := crypt.EncryptReader(strings.NewReader(contents), testMaster)
+	defer encrypted.Close()
+	if _, err := storage.GetBackend().Put(file.StorageKey(user.UUID), encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.GetDatabase().Create(file).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestUpgradeEncryption(t *testing.T) {
+	user := newTestUser(t)
+	file := newTestLegacyFile(t, user, "legacy.txt", "legacy contents")
+	oldKey := file.StorageKey(user.UUID)
+
+	kek, err := user.MasterKey(testMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := upgradeEncryption(file, user.UUID, kek, "wrong master"); err != errWrongMaster {
+		t.Errorf("expected a wrong master password, got: %v", err)
+	}
+
+	if upgraded, err := file.HasDataKey(); err != nil || upgraded {
+		t.Fatalf("the file should not be upgraded with a wrong master password, err: %v", err)
+	}
+
+	if err := upgradeEncryption(file, user.UUID, kek, testMaster); err != nil {
+		t.Fatal(err)
+	}
+
+	if upgraded, err := file.HasDataKey(); err != nil || !upgraded {
+		t.Fatalf("the file should have a data key, err: %v", err)
+	}
+
+	if file.StorageKey(user.UUID) == oldKey || !strings.HasPrefix(file.Hash, models.KeyedHashPrefix) {
+		t.Errorf("the contents should have been moved and hashed with the data key. key=%q, hash=%q",
+			file.StorageKey(user.UUID), file.Hash)
+	}
+
+	if _, err := storage.GetBackend().Stat(oldKey); err == nil {
+		t.Error("the contents encrypted with the master password should have been removed")
+	}
+
+	// The upgraded files are not read again, so the upgrade succeeds even without the contents.
+	newKey := file.StorageKey(user.UUID)
+	blob, err := storage.GetBackend().Get(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.GetBackend().Delete(newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := upgradeEncryption(file, user.UUID, kek, testMaster); err != nil {
+		t.Errorf("the upgraded file should not be read, err: %v", err)
+	}
+
+	if _, err := storage.GetBackend().Put(newKey, strings.NewReader(string(stored))); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := openEncrypted(file, user, testMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+
+	if data, err := ioutil.ReadAll(content); err != nil || string(data) != "legacy contents" {
+		t.Errorf("wrong contents after the upgrade: %q, err: %v", data, err)
+	}
+}
+
+// downloadAs downloads the file through the api as the user, who gives the master password.
+func downloadAs(t *testing.T, user *models.User, fileID, master string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/files/"+fileID+"/download", nil)
+	r.Header.Set(masterHeader, master)
+	return serve(t, user, r)
+}
+
+func TestShareEncryptedFile(t *testing.T) {
+	owner := newTestUser(t)
+	recipient := newTestUser(t)
+	file := newTestEncryptedFile(t, owner, "secret.txt", "encrypted contents")
+
+	if err := shareFile(owner, file.UUID, recipient.Username, "wrong master", "", nil); err != errWrongMaster {
+		t.Errorf("the file should not be shared with a wrong master password, got: %v", err)
+	}
+
+	if err := shareFile(owner, file.UUID, recipient.Username, testMaster, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recipient opens the file with their own master password, not the owner's.
+	rec := downloadAs(t, recipient, file.UUID, testMaster)
+	if rec.Code != http.StatusOK || rec.Body.String() != "encrypted contents" {
+		t.Errorf("the recipient should download the file. status=%d, body=%q", rec.Code, rec.Body)
+	}
+
+	if rec := downloadAs(t, recipient, file.UUID, "wrong master"); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code for a wrong master password. want=403, got=%d", rec.Code)
+	}
+
+	// The owner still opens the file with their master password.
+	if rec := downloadAs(t, owner, file.UUID, testMaster); rec.Body.String() != "encrypted contents" {
+		t.Errorf("the owner should download the file. status=%d, body=%q", rec.Code, rec.Body)
+	}
+}
+
+func TestShareEncryptedFileWithoutKeyPair(t *testing.T) {
+	owner := newTestUser(t)
+	file := newTestEncryptedFile(t, owner, "secret.txt", "encrypted contents")
+
+	// The recipient hasn't used their master password yet, so they don't have a key pair.
+	recipient := &models.User{Username: "test-" + lib.GenerateUUID()[:8], UUID: lib.GenerateUUID()}
+	if err := lib.GetDatabase().Create(recipient).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := recipient.Delete(); err != nil {
+			t.Error("could not remove the test user, err: ", err)
+		}
+	})
+
+	if err := shareFile(owner, file.UUID, recipient.Username, testMaster, "", nil); err != models.ErrNoPublicKey {
+		t.Errorf("expected no public key, got: %v", err)
+	}
+
+	if _, err := models.FindFileShare(file.ID, recipient.ID); err == nil {
+		t.Error("the file should not have been shared")
+	}
+}
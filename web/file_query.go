@@ -0,0 +1,128 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/upfi/models"
+)
+
+const (
+	// defaultPageSize is the number of files on a page of the file lists, if the 'limit' query is not
+	// given.
+	defaultPageSize = 50
+
+	// maxPageSize is the largest number of files a page can have.
+	maxPageSize = 200
+)
+
+// parseFileQuery parses the search, the filters, the sort and the page of a file list from the url
+// queries:
+//   - 'q' is a part of the filename or the description
+//   - 'ext' is the extension and 'mime' is the mime type or its prefix like 'image/'
+//   - 'tags' is a comma separated list of tags, all of which the files need to have
+//   - 'min_size' and 'max_size' are sizes like '10 MB'
+//   - 'from' and 'to' are the first and the last creation dates in the YYYY-MM-DD format
+//   - 'sort' is 'name', 'size', 'created' or 'updated' and 'order' is 'asc' or 'desc'
+//   - 'cursor' is the position of the page and 'limit' is the number of files on a page
+//
+// The pages have pageSize files, if the limit is not given. A zero page size lists all of the files.
+func parseFileQuery(r *http.Request, pageSize int) (*models.FileQuery, error) {
+	values := r.URL.Query()
+	query := &models.FileQuery{
+		Search:    values.Get("q"),
+		Extension: values.Get("ext"),
+		MIME:      values.Get("mime"),
+		Sort:      values.Get("sort"),
+		Cursor:    values.Get("cursor"),
+		Limit:     pageSize,
+	}
+
+	var err error
+	if query.Tags, err = parseTags(values.Get("tags")); err != nil {
+		return nil, err
+	}
+
+	if query.MinSize, err = parseSizeQuery(values.Get("min_size")); err != nil {
+		return nil, err
+	}
+
+	if query.MaxSize, err = parseSizeQuery(values.Get("max_size")); err != nil {
+		return nil, err
+	}
+
+	if query.From, err = parseDateQuery(values.Get("from"), 0); err != nil {
+		return nil, err
+	}
+
+	// The last day is included.
+	if query.To, err = parseDateQuery(values.Get("to"), 1); err != nil {
+		return nil, err
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return nil, errInvalidInput
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 || query.Limit > maxPageSize {
+			return nil, errInvalidInput
+		}
+	}
+
+	return query, nil
+}
+
+// parseTags parses a comma separated list of tags.
+func parseTags(value string) ([]string, error) {
+	return models.NormalizeTags(strings.Split(value, ","))
+}
+
+// parseSizeQuery parses an optional file size.
+func parseSizeQuery(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	size, err := parseFileSize(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &size, nil
+}
+
+// parseDateQuery parses an optional date in the YYYY-MM-DD format, and adds the given number of days to
+// it.
+func parseDateQuery(value string, days int) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, errInvalidInput
+	}
+
+	date = date.AddDate(0, 0, days)
+	return &date, nil
+}
+
+// nextPagePath returns the path of the next page of a file list, which keeps the other queries. An empty
+// string is returned for the last page.
+func nextPagePath(r *http.Request, page *models.FilePage) string {
+	if page.Next == "" {
+		return ""
+	}
+
+	values := r.URL.Query()
+	values.Set("cursor", page.Next)
+	return (&url.URL{Path: r.URL.Path, RawQuery: values.Encode()}).String()
+}
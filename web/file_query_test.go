@@ -0,0 +1,109 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/upfi/models"
+)
+
+func TestParseFileQuery(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet,
+		"/files?q=report&ext=pdf&tags=work,+notes&min_size=1+KB&from=2021-01-02&to=2021-01-05&sort=size&order=desc&limit=10", nil)
+
+	query, err := parseFileQuery(r, defaultPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if query.Search != "report" || query.Extension != "pdf" || query.Sort != models.SortSize || !query.Desc {
+		t.Errorf("wrong query: %+v", query)
+	}
+
+	if strings.Join(query.Tags, ",") != "work,notes" {
+		t.Errorf("wrong tags: %v", query.Tags)
+	}
+
+	if query.MinSize == nil || *query.MinSize != 1000 || query.MaxSize != nil {
+		t.Errorf("wrong sizes. min=%v, max=%v", query.MinSize, query.MaxSize)
+	}
+
+	// The last day is included.
+	if !query.From.Equal(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)) ||
+		!query.To.Equal(time.Date(2021, 1, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong dates. from=%v, to=%v", query.From, query.To)
+	}
+
+	if query.Limit != 10 {
+		t.Errorf("wrong limit. want=10, got=%d", query.Limit)
+	}
+
+	query, err = parseFileQuery(httptest.NewRequest(http.MethodGet, "/files", nil), defaultPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if query.Filtered() || query.Limit != defaultPageSize || query.Desc {
+		t.Errorf("wrong default query: %+v", query)
+	}
+
+	invalid := []string{
+		"order=random",
+		"limit=0",
+		"limit=-1",
+		"limit=" + url.QueryEscape("1e3"),
+		"limit=1000",
+		"from=yesterday",
+		"min_size=big",
+	}
+	for _, raw := range invalid {
+		r := httptest.NewRequest(http.MethodGet, "/files?"+raw, nil)
+		if _, err := parseFileQuery(r, defaultPageSize); err == nil {
+			t.Errorf("expected an error for %q", raw)
+		}
+	}
+}
+
+func TestNextPagePath(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/files?q=report&cursor=old", nil)
+
+	if path := nextPagePath(r, &models.FilePage{}); path != "" {
+		t.Errorf("the last page should not have a next page, got: %q", path)
+	}
+
+	path := nextPagePath(r, &models.FilePage{Next: "new"})
+	next, err := url.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next.Path != "/files" || next.Query().Get("q") != "report" || next.Query().Get("cursor") != "new" {
+		t.Errorf("wrong next page path: %q", path)
+	}
+}
+
+func TestSearchWithCursorOfAnotherSort(t *testing.T) {
+	user := newTestUser(t)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		newTestFile(t, user, name, name)
+	}
+
+	page, err := user.SearchFiles(&models.FileQuery{Sort: models.SortName, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(t, user, httptest.NewRequest(http.MethodGet, "/api/v1/files?sort=size&limit=1&cursor="+page.Next, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code for a cursor of another sort. want=400, got=%d", rec.Code)
+	}
+
+	rec = serve(t, user, httptest.NewRequest(http.MethodGet, "/api/v1/files?sort=name&limit=1&cursor="+page.Next, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code for the next page. want=200, got=%d", rec.Code)
+	}
+}
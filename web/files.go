@@ -1,18 +1,26 @@
 package web
 
 import (
-	"bytes"
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/gorilla/csrf"
 	"github.com/julienschmidt/httprouter"
 	"github.com/nireo/upfi/crypt"
 	"github.com/nireo/upfi/lib"
 	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
 	"github.com/nireo/upfi/templates"
+	"gorm.io/gorm"
 )
 
 func formatFileSize(b int64) string {
@@ -34,19 +42,17 @@ func ServeUploadPage(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 	w.Header().Set("Content-Type", "text/html")
 	templates.Upload(w, templates.UploadParams{
 		Title:         "upload",
+		Folder:        r.URL.Query().Get("folder"),
 		Authenticated: true,
+		MaxSize:       formatFileSize(maxUploadSize),
+		CSRFToken:     csrf.Token(r),
 	})
 }
 
+// UploadFile handles the file upload form. If the user gives their master password, the file is
+// encrypted, otherwise it's stored as plaintext so that it can be shared.
+// Also the route is protected, so that the security token is checked before calling this handler.
 func UploadFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	r.ParseMultipartForm(50 << 20) // ~50 mb
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
-		return
-	}
-
-	db := lib.GetDatabase()
 	username := r.Header.Get("username")
 	user, err := models.FindOneUser(&models.User{Username: username})
 	if err != nil {
@@ -54,119 +60,189 @@ func UploadFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
-	if len(r.Form["master"]) == 0 {
-		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+	// the user wants to share the file thus it needs to be unecrypted.
+	// in the future probably do this some javascript.
+	if _, err := receiveFile(r, user); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	// the user wants to share the file thus it needs to be unecrypted.
-	// in the future probably do this some javascript.
-	var toBeEncrypted bool
-	if len(r.Form["master"][0]) == 0 {
-		toBeEncrypted = false
-	} else {
-		toBeEncrypted = true
+	successParams := templates.SuccessPage{
+		Title:         "File has been uploaded.",
+		Description:   "Now you can see the new file on the files page.",
+		RedirectPath:  "files",
+		Authenticated: true,
 	}
 
-	var description string
-	if len(r.Form["description"]) == 0 {
-		// not provided so use a default value
-		description = "No description"
+	if err := templates.Success(w, successParams); err != nil {
+		fmt.Println(err)
 	}
+}
 
-	// make sure that the file isn't too long
-	if len(r.Form["description"]) != 0 && len(r.Form["description"][0]) >= 256 {
-		ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
-		return
+// createFile stores the contents of an uploaded file into the storage backend and creates the file's
+// database entry inside the given folder. If the master password is given, the file is encrypted,
+// otherwise it's stored as plaintext. Files larger than maxUploadSize are rejected with errTooLarge and
+// files which don't fit into the user's quota with models.ErrQuotaExceeded.
+func createFile(user *models.User, content io.Reader, filename, master, description, folderID string) (*models.File, error) {
+	if filename == "" {
+		return nil, errInvalidInput
+	}
+
+	folder, err := findFolder(user, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the mimetype so that we can set the content type properly. The start of the file is
+	// peeked, so it's still stored along with the rest of the file.
+	buffered := bufio.NewReader(newUploadLimit(user, content))
+	fileHeader, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	newFileEntry, err := newFileEntry(user, filename, description, http.DetectContentType(fileHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	if folder != nil {
+		newFileEntry.FolderID = &folder.ID
+	}
+
+	var kek []byte
+	if master != "" {
+		// now check that the encryption key is valid.
+		if kek, err = unlockMaster(user, master); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := storeFile(user, newFileEntry, buffered, kek); err != nil {
+		return nil, err
+	}
+
+	return newFileEntry, nil
+}
+
+// newFileEntry validates the filename and the description of a new file and constructs the file's
+// database entry.
+func newFileEntry(user *models.User, name, description, mime string) (*models.File, error) {
+	// make sure that the description isn't too long
+	if len(description) >= 256 {
+		return nil, errInvalidInput
+	}
+
+	if description == "" {
+		// not provided so use a default value
+		description = "No description"
 	}
 
 	// validate the filename
 	var filename string
-	if len(header.Filename) >= 32 {
+	if len(name) >= 32 {
 		// since the max length for a file can be really long, we don't want to store tons of text,
 		// and nor should the user hold such long filenames.
-		filename = header.Filename[0:32] + "..."
+		filename = name[0:32] + "..."
 	} else {
-		filename = header.Filename
+		filename = name
 	}
 
-	// Construct a database entry
-	newFileEntry := &models.File{
-		Filename:      filename,
-		UUID:          lib.GenerateUUID(),
-		Description:   description,
-		Size:          header.Size,
-		SizeHuman:     formatFileSize(header.Size),
-		UserID:        user.ID,
-		Extension:     filepath.Ext(header.Filename),
-		ShareableFile: !toBeEncrypted,
+	return &models.File{
+		Filename:    filename,
+		UUID:        lib.GenerateUUID(),
+		Description: description,
+		UserID:      user.ID,
+		Extension:   filepath.Ext(name),
+		MIME:        mime,
+	}, nil
+}
+
+// storeFile stores the contents of a new file into the storage backend and creates the database entry.
+// If the key encryption key is given, the file is encrypted with a new data key, which is wrapped with
+// the key encryption key. Otherwise the file is stored as plaintext, so that it can be shared. The size
+// and the hash of the file are calculated while it's being stored.
+func storeFile(user *models.User, newFileEntry *models.File, content io.Reader, kek []byte) error {
+	newFileEntry.ShareableFile = kek == nil
+
+	// The key under which the file is stored in the storage backend.
+	key := newFileEntry.StorageKey(user.UUID)
+
+	// The encrypted files get their data key before the contents are hashed, since the hash is keyed
+	// with it.
+	var dataKey []byte
+	if !newFileEntry.ShareableFile {
+		var err error
+		if dataKey, err = crypt.GenerateDataKey(); err != nil {
+			return err
+		}
 	}
 
-	// Define a path, where the file should be stored. Even though we encrypt the file, we
-	// still want to keep the extension, since windows for example does not work without proper file
-	// types.
-	path := fmt.Sprintf("%s/%s/%s%s", lib.AddRootToPath("files"),
-		user.UUID, newFileEntry.UUID, newFileEntry.Extension)
+	// Hash the contents while they are being stored, the hash is used to check if a client already has
+	// the same contents.
+	hash := newContentHash(dataKey)
+	counter := &countingReader{r: io.TeeReader(content, hash)}
+	content = counter
 
 	// there are two ways to store files, either encrypted or just as plaintext.
-	if toBeEncrypted {
-		// now check that the encryption key is valid.
-		if !lib.CheckPasswordHash(r.Form["master"][0], user.FileEncryptionMaster) {
-			ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
-			return
+	if newFileEntry.ShareableFile {
+		// the file is not encrypted since the user wants to share it.
+		if _, err := storage.GetBackend().Put(key, content); err != nil {
+			return err
 		}
+		setContentInfo(newFileEntry, counter.n, encodeContentHash(hash.Sum(nil), nil))
 
-		// Read the bytes of the file into a buffer.
-		buf := bytes.NewBuffer(nil)
-		if _, err := io.Copy(buf, file); err != nil {
-			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-			return
+		// Files with the same contents share a single blob, so the contents are only kept once.
+		if err := models.CreateBlobFile(newFileEntry, key); err != nil {
+			storage.GetBackend().Delete(key)
+			return err
 		}
 
-		// Encrypt the data of the file using AESCipher and store it into the before defined path.
-		if err := crypt.EncryptToDst(path, buf.Bytes(), r.Form["master"][0]); err != nil {
-			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-			return
-		}
-	} else {
-		fmt.Println("file stored as plaintext")
-
-		// the file is not encrypted since the user wants to share it.
-		f, err := os.Create(path)
-		if err != nil {
-			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-			return
-		}
-		defer f.Close()
+		return nil
+	}
 
-		if _, err := io.Copy(f, file); err != nil {
-			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-			return
-		}
+	// Encrypt the data of the file with its own data key while it's being stored into the storage
+	// backend. The file is encrypted in chunks, so that the whole file doesn't need to be kept in memory.
+	if err := storeWithDataKey(key, content, dataKey); err != nil {
+		return err
 	}
+	setContentInfo(newFileEntry, counter.n, encodeContentHash(hash.Sum(nil), dataKey))
 
-	// Read the mimetype so that we can set the content type properly
-	// Create a buffer to store the header of the file in
-	fileHeader := make([]byte, 512)
-	// Copy the headers into the FileHeader buffer
-	if _, err := file.Read(fileHeader); err != nil && err != io.EOF {
-		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-		return
+	// The file entry and its wrapped data key are created together, so that an encrypted file
+	// never lacks its key.
+	if err := models.CreateEncryptedFile(newFileEntry, kek, dataKey); err != nil {
+		storage.GetBackend().Delete(key)
+		return err
 	}
 
-	newFileEntry.MIME = http.DetectContentType(fileHeader)
-	db.Create(newFileEntry)
+	return nil
+}
 
-	successParams := templates.SuccessPage{
-		Title:         "File has been uploaded.",
-		Description:   "Now you can see the new file on the files page.",
-		RedirectPath:  "files",
-		Authenticated: true,
+// setContentInfo sets the size and the hash of the stored contents into the file's entry.
+func setContentInfo(file *models.File, size int64, hash string) {
+	file.Size = size
+	file.SizeHuman = formatFileSize(size)
+	file.Hash = hash
+}
+
+// newContentHash returns the hash of the stored contents. The contents of the encrypted files are hashed
+// with an HMAC keyed by their data key, so that the hash cannot be used to confirm a guess of the
+// contents without the key.
+func newContentHash(dataKey []byte) hash.Hash {
+	if dataKey == nil {
+		return sha256.New()
 	}
 
-	if err := templates.Success(w, successParams); err != nil {
-		fmt.Println(err)
+	return hmac.New(sha256.New, dataKey)
+}
+
+// encodeContentHash encodes the sum of a hash from newContentHash with the same data key.
+func encodeContentHash(sum, dataKey []byte) string {
+	if dataKey == nil {
+		return hex.EncodeToString(sum)
 	}
+
+	return models.KeyedHashPrefix + hex.EncodeToString(sum)
 }
 
 // GetSingleFile returns the database entry, which contains data about a file to the user. The user
@@ -175,35 +251,54 @@ func UploadFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 func GetSingleFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Get the user's username which was appended to the request header
 	username := r.Header.Get("username")
-	db := lib.GetDatabase()
 
 	// Find the user's database entry who is requesting this handler.
-	var user models.User
-	if err := db.Where(&models.User{Username: username}).First(&user).Error; err != nil {
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
 		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
 		return
 	}
 
-	// Find the file
-	fileID := ps.ByName("file")
-	var file models.File
-	if err := db.Where(&models.File{UUID: fileID}).First(&file).Error; err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+	// Find the file and check that the user owns the file or that it has been shared to them.
+	file, _, err := findAccessibleFile(user, fileIDParam(r, ps), models.PermissionView)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	// Check that the user owns the file.
-	if user.ID != file.UserID {
-		// check if the file is shared.
-		var sharedFile models.FileShare
-		if err := db.Where(
-			&models.FileShare{SharedToID: user.ID, SharedFileID: file.ID}).First(&sharedFile).
-			Error; err != nil {
-			// the file is not even shared
-			ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
+	// The recipients only see the actions which their share allows.
+	owner := file.UserID == user.ID
+	canDownload, canEdit, canUpload := owner, owner, owner
+	if !owner {
+		level, err := models.FilePermission(file, user.ID)
+		if err != nil {
+			ErrorPageHandler(w, r, errorContent(err))
+			return
+		}
+		canDownload = models.PermissionAllows(level, models.PermissionDownload)
+		canEdit = models.PermissionAllows(level, models.PermissionEdit)
+		canUpload = models.PermissionAllows(level, models.PermissionUpload)
+	}
+
+	tags, err := file.FindTags()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	// Only the owner can see and manage the earlier versions and the share links.
+	var versions []models.FileVersion
+	var links []models.ShareLink
+	if owner {
+		if versions, err = file.FindVersions(); err != nil {
+			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+			return
+		}
+
+		if links, err = file.FindShareLinks(); err != nil {
+			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 			return
 		}
-		// if it executes without any problem, we don't need to do anything.
 	}
 
 	// Display the user with the file's information, this template also includes the option to download a file.
@@ -211,39 +306,175 @@ func GetSingleFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	params := templates.SingleFileParams{
 		Authenticated: true,
 		Title:         file.Filename,
-		File:          file,
+		File:          *file,
+		Owner:         owner,
+		CanDownload:   canDownload,
+		CanEdit:       canEdit,
+		CanUpload:     canUpload,
+		Tags:          tags,
+		Versions:      versions,
+		Links:         links,
+		CSRFToken:     csrf.Token(r),
 	}
 
 	templates.SingleFile(w, params)
 }
 
-// GetUserFiles returns all the files that are related to the username which is requesting this
-// handler. Then handler finds all the related files and constructs a template, which the user
-// then can view as html content.
+// findAccessibleFile finds the file with the given uuid, if the user owns the file or the file has
+// been shared to them or their groups with the given permission. The owner of the file is also returned, since the files
+// are stored under the owner's uuid and the data keys are wrapped with the owner's master password.
+func findAccessibleFile(user *models.User, fileID, permission string) (*models.File, *models.User, error) {
+	if fileID == "" {
+		return nil, nil, errInvalidInput
+	}
+
+	file, err := models.FindOneFile(&models.File{UUID: fileID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user.ID == file.UserID {
+		return file, user, nil
+	}
+
+	// check if the file is shared to the user or the user's groups, and that the share hasn't expired.
+	level, err := models.FilePermission(file, user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the file is not even shared
+		return nil, nil, errNoAccess
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if !models.PermissionAllows(level, permission) {
+		return nil, nil, errNoAccess
+	}
+
+	owner, err := models.FindOneUser(&models.User{Model: gorm.Model{ID: file.UserID}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, owner, nil
+}
+
+// findOwnedFile finds the file with the given uuid, if the user owns the file. Other users' files are
+// reported as not found, since we don't want the unauthorized user to know about the file's existence.
+func findOwnedFile(user *models.User, fileID string) (*models.File, error) {
+	if fileID == "" {
+		return nil, errInvalidInput
+	}
+
+	file, err := models.FindOneFile(&models.File{UUID: fileID})
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID != file.UserID {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return file, nil
+}
+
+// fileIDParam returns the id of the file the request is about. The id is either a part of the route
+// or given as the 'file' query parameter.
+func fileIDParam(r *http.Request, ps httprouter.Params) string {
+	if fileID := ps.ByName("file"); fileID != "" {
+		return fileID
+	}
+
+	return r.URL.Query().Get("file")
+}
+
+// GetUserFiles returns the files and the folders inside one of the user's folders, which is given in the
+// 'folder' query, or at the top level if it's not given. Then handler constructs a template, which the
+// user then can view as html content.
 // Also the route is protected, so that the security token is checked before calling this handler.
 func GetUserFiles(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "text/html")
 	username := r.Header.Get("username")
-	db := lib.GetDatabase()
 
 	// Find the user's database entry who is requesting this handler.
-	var user models.User
-	if err := db.Where(&models.User{Username: username}).First(&user).Error; err != nil {
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
 		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
 		return
 	}
 
-	// Find all file models which are related to the user in the database.
-	var files []models.File
-	db.Where(&models.File{UserID: user.ID}).Find(&files)
+	folder, err := findFolder(user, r.URL.Query().Get("folder"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	query, err := parseFileQuery(r, defaultPageSize)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	// Find the folders and the files which are inside the folder. The searches and the filters go
+	// through the files in all of the folders.
+	var folders []models.Folder
+	var page *models.FilePage
+	if query.Filtered() {
+		page, err = user.SearchFiles(query)
+	} else {
+		folders, page, err = user.FindFolderContents(folder, query)
+	}
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	// The folders are listed on the first page.
+	if query.Cursor != "" {
+		folders = nil
+	}
+
+	// All of the folders are listed, so that files and folders can be moved anywhere.
+	allFolders, err := user.FindFolders()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	var breadcrumbs []models.Folder
+	if folder != nil {
+		if breadcrumbs, err = folder.Path(); err != nil {
+			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+			return
+		}
+	}
+
+	// The folders can be shared with the user's groups.
+	groups, err := user.FindGroups()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
 
-	for _, f := range files {
-		f.CreatedAt.Format("02-Jan-2006")
+	tags, err := models.FindFileTags(page.Files)
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
 	}
 
 	pageParams := templates.FilesParams{
-		Title: "your files",
-		Files: files,
+		Title:       "your files",
+		Files:       page.Files,
+		Tags:        tags,
+		Path:        "/files",
+		Query:       r.URL.Query(),
+		NextPage:    nextPagePath(r, page),
+		Searching:   query.Filtered(),
+		ShowFolders: true,
+		Folder:      folder,
+		Folders:     folders,
+		AllFolders:  allFolders,
+		Breadcrumbs: breadcrumbs,
+		Groups:      groups,
 		// No need to check if the user is authenticated
 		Authenticated: true,
 	}
@@ -253,12 +484,12 @@ func GetUserFiles(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	}
 }
 
-// UpdateFile is http handler which takes a file id as a query parameter and checks for the file's ownership.
-// This handler can be used to update file title and description.
+// UpdateFile is http handler which takes a file id as a query parameter and checks that the user owns the
+// file or has the edit permission to it. This handler can be used to update file title and description,
+// and the comma separated 'tags' if the field is included.
 // Also the route is protected, so that the security token is checked before calling this handler.
 func UpdateFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	username := r.Header.Get("username")
-	db := lib.GetDatabase()
 
 	// Parse the multipart form so that we can take the 'title' and 'description' fields.
 	if err := r.ParseMultipartForm(1 << 20); err != nil {
@@ -282,6 +513,16 @@ func UpdateFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
+	// The tags are only changed if the field is included.
+	var tags []string
+	if len(r.Form["tags"]) > 0 {
+		var err error
+		if tags, err = parseTags(r.Form["tags"][0]); err != nil {
+			ErrorPageHandler(w, r, errorContent(err))
+			return
+		}
+	}
+
 	// Find the user that is requesting this handler.
 	user, err := models.FindOneUser(&models.User{Username: username})
 	if err != nil {
@@ -289,21 +530,35 @@ func UpdateFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	// Find the file
-	fileID := ps.ByName("file")
-	var file models.File
-	if err := db.Where(&models.File{UUID: fileID}).First(&file).Error; err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+	file, err := updateFile(user, fileIDParam(r, ps), title, description, tags)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	// Check that the user owns the file
-	if user.ID != file.UserID {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
-		return
+	http.Redirect(w, r, "/file?file="+file.UUID, http.StatusSeeOther)
+}
+
+// updateFile updates the title, the description and the tags of a file owned by the user or shared to the
+// user with the edit permission. Only the fields, which are not empty, are updated. The tags are left as
+// they are if they are nil, and an empty list removes them.
+func updateFile(user *models.User, fileID, title, description string, tags []string) (*models.File, error) {
+	if len(description) >= 256 {
+		return nil, errInvalidInput
+	}
+
+	if tags != nil {
+		var err error
+		if tags, err = models.NormalizeTags(tags); err != nil {
+			return nil, err
+		}
+	}
+
+	file, _, err := findAccessibleFile(user, fileID, models.PermissionEdit)
+	if err != nil {
+		return nil, err
 	}
 
-	// Update only the fields, which are not empty.
 	if description != "" {
 		file.Description = description
 	}
@@ -313,50 +568,46 @@ func UpdateFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	}
 
 	// Save the changes to the database.
-	db.Save(&file)
+	if err := lib.GetDatabase().Save(file).Error; err != nil {
+		return nil, err
+	}
 
-	r.Method = http.MethodGet
-	http.Redirect(w, r, "/files", http.StatusMovedPermanently)
+	if tags != nil {
+		if err := file.SetTags(tags); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
 }
 
-// DeleteFile is a handler that deletes a file owned by the user. The handler takes a file id as a query parameter
-// and then does checking on the ownership of the file.
+// DeleteFile is a handler that moves a file owned by the user into the trash. The handler takes a file id as a
+// query parameter and then does checking on the ownership of the file.
 // Also the route is protected, so that the security token is checked before calling this handler.
 func DeleteFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	username := r.Header.Get("username")
-	db := lib.GetDatabase()
 
 	// Find the database entry of the user that requested this handler.
-	var user models.User
-	if err := db.Where(&models.User{Username: username}).First(&user).Error; err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
-		return
-	}
-
-	// Find the file, if the file does not exist, return a not found error
-	fileID := ps.ByName("file")
-	var file models.File
-	if err := db.Where(&models.File{UUID: fileID}).First(&file).Error; err != nil {
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
 		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
 		return
 	}
 
-	// Check that the user owns the file.
-	if user.ID != file.UserID {
-		// Return a not found error, since we don't want the unauthorized user to know about the
-		// file's existence
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+	// Find the file, if the file does not exist or the user doesn't own it, return a not found error
+	file, err := findOwnedFile(user, fileIDParam(r, ps))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	// Remove the file, if the file cannot be removed the return a internal server error to the user.
-	if err := os.Remove(lib.AddRootToPath("files/") + user.UUID + "/" + fmt.Sprintf("%s%s", file.UUID, file.Extension)); err != nil {
+	// Move the file into the trash, from which it can still be restored. The contents are removed once
+	// the file is removed from the trash.
+	if err := file.Trash(); err != nil {
 		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 		return
 	}
 
-	// Delete the database entry
-	db.Delete(&file)
 	r.Method = http.MethodGet
 	http.Redirect(w, r, "/files", http.StatusMovedPermanently)
 }
@@ -365,85 +616,113 @@ func DeleteFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 // Also the route is protected, so that the security token is checked before calling this handler.
 func DownloadFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	username := r.Header.Get("username")
-	db := lib.GetDatabase()
 
-	var user models.User
-	if err := db.Where(&models.User{Username: username}).First(&user).Error; err != nil {
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
 		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
 		return
 	}
 
-	fileID := ps.ByName("file")
-	var file models.File
-	if err := db.Where(&models.File{UUID: fileID}).First(&file).Error; err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+	file, owner, err := findAccessibleFile(user, fileIDParam(r, ps), models.PermissionDownload)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	// we need to get the actual owner, since the files are stored in folders with the owner's
-	// uuid.
-	var ownerID string
-	// Check that the user owns the file.
-	if user.ID != file.UserID {
-		// check if the file is shared.
-		var sharedFile models.FileShare
-		if err := db.Where(
-			&models.FileShare{SharedToID: user.ID, SharedFileID: file.ID}).First(&sharedFile).
-			Error; err != nil {
-			// the file is not even shared
-			ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
+	// Encrypted files need the master password of the owner, or the recipient's own master password if
+	// the file's key has been sealed to them.
+	var master string
+	if !file.ShareableFile {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
 			return
 		}
 
-		// if it executes without any problem, we don't need to do anything.
-		var owner models.User
-
-		// TODO: probably do something better if the owner doesn't actually exist anymore.
-		if err := db.Where("id = ?", sharedFile.SharedByID).First(&owner).Error; err != nil {
-			ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		if len(r.Form["master"]) == 0 {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
 			return
 		}
+		master = r.Form["master"][0]
+	}
 
-		ownerID = owner.UUID
-	} else {
-		// if the user owns the file, the owner id is easy to get.
-		ownerID = user.UUID
+	if err := sendAccessibleFile(w, r, user, file, owner, master); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
 	}
+}
 
-	path := fmt.Sprintf("%s/%s/%s%s", lib.AddRootToPath("files"),
-		ownerID, file.UUID, file.Extension)
+// sendAccessibleFile writes the contents of a file which the user owns or which has been shared to the
+// user. Encrypted files shared to the user are decrypted with the user's own master password, unless the
+// share was created before the key pairs existed, in which case the owner's master password is needed.
+func sendAccessibleFile(w http.ResponseWriter, r *http.Request, user *models.User, file *models.File,
+	owner *models.User, master string) error {
+	if file.ShareableFile || file.UserID == user.ID {
+		return sendFile(w, r, file, owner, master)
+	}
 
-	// Set the proper headers for transfering the file.
-	w.Header().Set("Content-Type", file.MIME)
-	w.Header().Set("Content-Disposition", "attachment; filename="+file.Filename)
+	share, err := models.FindFileShare(file.ID, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(share.WrappedKey) == 0 {
+		return sendFile(w, r, file, owner, master)
+	}
+
+	if master == "" {
+		return errInvalidInput
+	}
+
+	content, err := openShared(file, owner, share, user, master)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	serveContent(w, r, file, content)
+	return nil
+}
+
+// sendFile writes the contents of the file into the response. Encrypted files are decrypted using the
+// master password. Range requests and conditional requests are supported for all files. Only the parts
+// of an encrypted file which are requested are decrypted. If an error is returned, nothing has been
+// written to the response.
+func sendFile(w http.ResponseWriter, r *http.Request, file *models.File, owner *models.User, master string) error {
+	var content io.ReadSeekCloser
+	var err error
 
 	// check if the file in encrypted or not.
 	if file.ShareableFile {
-		http.ServeFile(w, r, path)
+		content, err = storage.Open(storage.GetBackend(), file.StorageKey(owner.UUID))
 	} else {
-		if err := r.ParseMultipartForm(1 << 20); err != nil {
-			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
-			return
+		if master == "" {
+			return errInvalidInput
 		}
+		content, err = openEncrypted(file, owner, master)
+	}
+	if err != nil {
+		return err
+	}
+	defer content.Close()
 
-		if len(r.Form["master"]) == 0 {
-			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
-			return
-		}
+	serveContent(w, r, file, content)
+	return nil
+}
 
-		tempUUID := lib.GenerateUUID()
-		tempPath := fmt.Sprintf("%s/%s%s", lib.AddRootToPath("temp"),
-			tempUUID, file.Extension)
-		if err := crypt.DecryptToDst(tempPath, path, r.Form["master"][0]); err != nil {
-			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-			return
-		}
+// serveContent writes the contents of the file into the response with the proper headers.
+func serveContent(w http.ResponseWriter, r *http.Request, file *models.File, content io.ReadSeeker) {
+	setDownloadHeaders(w, file)
+	http.ServeContent(w, r, file.Filename, file.UpdatedAt, content)
+}
 
-		http.ServeFile(w, r, tempPath)
-		if err := os.Remove(tempPath); err != nil {
-			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-			return
-		}
+// setDownloadHeaders sets the proper headers for transfering the file.
+func setDownloadHeaders(w http.ResponseWriter, file *models.File) {
+	w.Header().Set("Content-Type", file.MIME)
+	w.Header().Set("Content-Disposition", "attachment; filename="+file.Filename)
+
+	// http.ServeContent uses the ETag for the If-None-Match and If-Range headers.
+	if etag := file.ETag(); etag != "" {
+		w.Header().Set("ETag", etag)
 	}
 }
 
@@ -458,15 +737,31 @@ func GetSharedByUser(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 		return
 	}
 
-	files, err := user.FindSharedByFiles()
+	query, err := parseFileQuery(r, defaultPageSize)
 	if err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	page, err := user.FindSharedByFiles(query)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	tags, err := models.FindFileTags(page.Files)
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 		return
 	}
 
 	pageParams := templates.FilesParams{
-		Title: "your files",
-		Files: files,
+		Title:    "your files",
+		Files:    page.Files,
+		Tags:     tags,
+		Path:     "/shared_by",
+		Query:    r.URL.Query(),
+		NextPage: nextPagePath(r, page),
 		// No need to check if the user is authenticated
 		Authenticated: true,
 	}
@@ -487,17 +782,31 @@ func GetSharedToUser(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 		return
 	}
 
-	files, err := user.FindSharedToFiles()
+	query, err := parseFileQuery(r, defaultPageSize)
 	if err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	fmt.Printf("found %d files", len(files))
+	page, err := user.FindSharedToFiles(query)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	tags, err := models.FindFileTags(page.Files)
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
 
 	pageParams := templates.FilesParams{
 		Title:         "files shared to you",
-		Files:         files,
+		Files:         page.Files,
+		Tags:          tags,
+		Path:          "/shared_to",
+		Query:         r.URL.Query(),
+		NextPage:      nextPagePath(r, page),
 		Authenticated: true,
 	}
 
@@ -518,36 +827,15 @@ func DeleteSharedContract(w http.ResponseWriter, r *http.Request, ps httprouter.
 	}
 
 	toOrBy := ps.ByName("type")
-	if toOrBy != "to" && toOrBy != "by" {
-		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
-		return
+	if toOrBy == "" {
+		toOrBy = r.URL.Query().Get("type")
 	}
 
-	fileID := ps.ByName("file")
-	file, err := models.FindOneFile(&models.File{UUID: fileID})
-	if err != nil {
-		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+	if err := deleteShare(user, toOrBy, fileIDParam(r, ps)); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	db := lib.GetDatabase()
-	var sharedContract models.FileShare
-	if toOrBy == "to" {
-		if err := db.Where(
-			&models.FileShare{SharedToID: user.ID, SharedFileID: file.ID}).
-			First(&sharedContract).Error; err != nil {
-			ErrorPageHandler(w, r, lib.NotFoundErrorPage)
-		}
-	} else {
-		if err := db.Where(
-			&models.FileShare{SharedByID: user.ID, SharedFileID: file.ID}).
-			First(&sharedContract).Error; err != nil {
-			ErrorPageHandler(w, r, lib.NotFoundErrorPage)
-		}
-	}
-
-	db.Delete(&sharedContract)
-
 	successParams := templates.SuccessPage{
 		Title:         "Shared contract has been deleted.",
 		Description:   "The shared file has been deleted, but it can be shared again!",
@@ -560,16 +848,67 @@ func DeleteSharedContract(w http.ResponseWriter, r *http.Request, ps httprouter.
 	}
 }
 
+// deleteShare removes a share of the file, which has either been shared to the user ("to") or shared
+// by the user ("by"). An empty file id is rejected, since it would match the first file.
+func deleteShare(user *models.User, toOrBy, fileID string) error {
+	if fileID == "" {
+		return errInvalidInput
+	}
+
+	var condition models.FileShare
+	switch toOrBy {
+	case "to":
+		condition.SharedToID = user.ID
+	case "by":
+		condition.SharedByID = user.ID
+	default:
+		return errInvalidInput
+	}
+
+	file, err := models.FindOneFile(&models.File{UUID: fileID})
+	if err != nil {
+		return err
+	}
+	condition.SharedFileID = file.ID
+
+	db := lib.GetDatabase()
+	var sharedContract models.FileShare
+	if err := db.Where(&condition).First(&sharedContract).Error; err != nil {
+		return err
+	}
+
+	return db.Delete(&sharedContract).Error
+}
+
 // ServeCreateSharedPage just renders the template containing the share page.
 func ServeCreateSharedPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	w.Header().Set("Content-Type", "text/html")
-	fileID := ps.ByName("file")
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
 
-	fmt.Println(fileID)
+	file, err := findOwnedFile(user, fileIDParam(r, ps))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
 
+	// Only unencrypted files can be shared with groups.
+	var groups []models.MemberGroup
+	if file.ShareableFile {
+		if groups, err = user.FindGroups(); err != nil {
+			ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
 	templates.SharePage(w, templates.ShareFilePage{
 		Title:         "share file to user",
-		FileID:        fileID,
+		FileID:        file.UUID,
+		Encrypted:     !file.ShareableFile,
+		Groups:        groups,
 		Authenticated: true,
 	})
 }
@@ -587,57 +926,97 @@ func CreateSharedFile(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 		return
 	}
 
-	// just easily check that the username is valid so we don't have to do unneeded
-	// computations
-	if !lib.IsUsernameValid(r.Form["username"][0]) {
-		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+	// we need this, since we need to check the ownership of the file.
+	byUser, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
 		return
 	}
 
-	toShareUser, err := models.FindOneUser(&models.User{Username: r.Form["username"][0]})
+	// Encrypted files need the master password, so that the file's key can be sealed to the recipient.
+	var master string
+	if len(r.Form["master"]) > 0 {
+		master = r.Form["master"][0]
+	}
+
+	// The share never expires, if the number of hours is not given.
+	expiresAt, err := parseExpiry(r.FormValue("expires"))
 	if err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	// we need this, since we need to check the ownership of the file.
-	byUser, err := models.FindOneUser(&models.User{})
-	if err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+	if err := shareFile(byUser, fileIDParam(r, ps), r.Form["username"][0], master, r.FormValue("permission"),
+		expiresAt); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	fileID := ps.ByName("file")
+	params := templates.SuccessPage{
+		Title: "File shared successfully",
+		Description: fmt.Sprintf(
+			"This file has been shared to %s, now the can be accessed by that user.", r.Form["username"][0]),
+		Authenticated: true,
+	}
+
+	if err := templates.Success(w, params); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+}
+
+// shareFile shares a file owned by the user to the user with the given username, with the permission
+// level and the optional expiration time. The recipients can download the file, if the permission is not
+// given. The key of an encrypted file is sealed to the recipient's public key, which needs the owner's
+// master password. Sharing the file again to the same user replaces the earlier share.
+func shareFile(byUser *models.User, fileID, username, master, permission string, expiresAt *time.Time) error {
+	// just easily check that the username is valid so we don't have to do unneeded
+	// computations
+	if !lib.IsUsernameValid(username) || username == byUser.Username || fileID == "" {
+		return errInvalidInput
+	}
+
+	if permission == "" {
+		permission = models.PermissionDownload
+	}
+
+	if !models.ValidPermission(permission) {
+		return errInvalidInput
+	}
+
+	toShareUser, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
+		return err
+	}
+
 	file, err := models.FindOneFile(&models.File{UUID: fileID})
 	if err != nil {
-		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
-		return
+		return err
 	}
 
 	// there really is no way to share another person's file from the website, but
 	// check just in case :D
 	if file.UserID != byUser.ID {
-		ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
-		return
+		return errNoAccess
 	}
 
 	sharedContract := &models.FileShare{
 		SharedByID:   byUser.ID,
 		SharedToID:   toShareUser.ID,
 		SharedFileID: file.ID,
+		Permission:   permission,
+		ExpiresAt:    expiresAt,
 	}
-	db := lib.GetDatabase()
-	db.Create(sharedContract)
 
-	params := templates.SuccessPage{
-		Title: "File shared successfully",
-		Description: fmt.Sprintf(
-			"This file has been shared to %s, now the can be accessed by that user.", r.Form["username"][0]),
-		Authenticated: true,
-	}
+	if !file.ShareableFile {
+		if master == "" {
+			return errInvalidInput
+		}
 
-	if err := templates.Success(w, params); err != nil {
-		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
-		return
+		if sharedContract.WrappedKey, err = sealForRecipient(file, byUser, toShareUser, master); err != nil {
+			return err
+		}
 	}
+
+	return models.CreateFileShare(sharedContract)
 }
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nireo/upfi/models"
+)
+
+func TestFindAccessibleFileRequiresID(t *testing.T) {
+	if _, _, err := findAccessibleFile(&models.User{}, "", models.PermissionView); err != errInvalidInput {
+		t.Errorf("expected invalid input for an empty id, got: %v", err)
+	}
+}
+
+func TestSharePermissionsAreEnforced(t *testing.T) {
+	owner := newTestUser(t)
+	recipient := newTestUser(t)
+	file := newTestFile(t, owner, "shared.txt", "shared contents")
+
+	if _, _, err := findAccessibleFile(recipient, file.UUID, models.PermissionView); err != errNoAccess {
+		t.Errorf("the file is not shared yet, got: %v", err)
+	}
+
+	if err := models.CreateFileShare(&models.FileShare{SharedByID: owner.ID, SharedToID: recipient.ID,
+		SharedFileID: file.ID, Permission: models.PermissionView}); err != nil {
+		t.Fatal(err)
+	}
+
+	found, foundOwner, err := findAccessibleFile(recipient, file.UUID, models.PermissionView)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.ID != file.ID || foundOwner.ID != owner.ID {
+		t.Errorf("wrong file or owner returned. file=%d, owner=%d", found.ID, foundOwner.ID)
+	}
+
+	// Viewing the file doesn't allow downloading or editing it.
+	if _, _, err := findAccessibleFile(recipient, file.UUID, models.PermissionDownload); err != errNoAccess {
+		t.Errorf("the view permission should not allow downloading, got: %v", err)
+	}
+
+	if _, err := updateFile(recipient, file.UUID, "renamed.txt", "", nil); err != errNoAccess {
+		t.Errorf("the view permission should not allow editing, got: %v", err)
+	}
+
+	// The share cannot be used after it has expired.
+	past := time.Now().Add(-time.Minute)
+	if err := models.CreateFileShare(&models.FileShare{SharedByID: owner.ID, SharedToID: recipient.ID,
+		SharedFileID: file.ID, Permission: models.PermissionEdit, ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := findAccessibleFile(recipient, file.UUID, models.PermissionView); err != errNoAccess {
+		t.Errorf("the expired share should not give access, got: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+)
+
+// maxFolderNameLength is the longest name a folder can have.
+const maxFolderNameLength = 64
+
+// CreateFolder creates a new folder from the files page. The folder is created inside the folder given
+// in the 'parent' field, or at the top level if it's empty.
+func CreateFolder(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	parent := r.FormValue("parent")
+	if _, err := createFolder(user, r.FormValue("name"), parent); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, folderPath(parent), http.StatusSeeOther)
+}
+
+// RenameFolder changes the name of one of the user's folders.
+func RenameFolder(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := renameFolder(user, r.FormValue("folder"), r.FormValue("name"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, parentPath(folder), http.StatusSeeOther)
+}
+
+// MoveFolder moves one of the user's folders inside the folder given in the 'parent' field, or to the top
+// level if it's empty.
+func MoveFolder(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	parent := r.FormValue("parent")
+	if err := moveFolder(user, r.FormValue("folder"), parent); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, folderPath(parent), http.StatusSeeOther)
+}
+
+// DeleteFolder removes one of the user's folders along with all of the folders and files inside it.
+func DeleteFolder(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := findOwnedFolder(user, r.FormValue("folder"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := folder.Delete(); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, parentPath(folder), http.StatusSeeOther)
+}
+
+// MoveFile moves one of the user's files inside the folder given in the 'folder' field, or to the top
+// level if it's empty.
+func MoveFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	folderID := r.FormValue("folder")
+	if err := moveFile(user, r.FormValue("file"), folderID); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, folderPath(folderID), http.StatusSeeOther)
+}
+
+// folderPath returns the path of the files page which shows the folder's contents.
+func folderPath(folderID string) string {
+	if folderID == "" {
+		return "/files"
+	}
+
+	return "/files?folder=" + folderID
+}
+
+// parentPath returns the path of the files page which shows the folder containing the given folder.
+func parentPath(folder *models.Folder) string {
+	path, err := folder.Path()
+	if err != nil || len(path) < 2 {
+		return "/files"
+	}
+
+	return folderPath(path[len(path)-2].UUID)
+}
+
+// findFolder finds one of the user's folders. An empty id means the top level, for which nil is returned.
+func findFolder(user *models.User, folderID string) (*models.Folder, error) {
+	if folderID == "" {
+		return nil, nil
+	}
+
+	return models.FindUserFolder(user.ID, folderID)
+}
+
+// findOwnedFolder finds one of the user's folders. Unlike findFolder, the id is required, so that an
+// empty id is never taken as the top level or matched against any folder.
+func findOwnedFolder(user *models.User, folderID string) (*models.Folder, error) {
+	if folderID == "" {
+		return nil, errInvalidInput
+	}
+
+	return models.FindUserFolder(user.ID, folderID)
+}
+
+// validFolderName checks the name of a folder and returns it without the surrounding whitespace.
+func validFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxFolderNameLength {
+		return "", errInvalidInput
+	}
+
+	return name, nil
+}
+
+// checkFolderName checks that the parent folder doesn't already contain a folder with the name.
+func checkFolderName(user *models.User, parent *models.Folder, name string) error {
+	taken, err := user.FolderNameTaken(parent, name)
+	if err != nil {
+		return err
+	}
+
+	if taken {
+		return errConflict
+	}
+
+	return nil
+}
+
+// folderError turns the errors about invalid folder structures into invalid input errors.
+func folderError(err error) error {
+	if errors.Is(err, models.ErrFolderCycle) || errors.Is(err, models.ErrFolderTooDeep) {
+		return errInvalidInput
+	}
+
+	return err
+}
+
+// createFolder creates a folder with the name inside the parent folder.
+func createFolder(user *models.User, name, parentID string) (*models.Folder, error) {
+	name, err := validFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := findFolder(user, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkFolderName(user, parent, name); err != nil {
+		return nil, err
+	}
+
+	folder, err := user.CreateFolder(name, parent)
+	return folder, folderError(err)
+}
+
+// renameFolder changes the name of one of the user's folders.
+func renameFolder(user *models.User, folderID, name string) (*models.Folder, error) {
+	name, err := validFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := findOwnedFolder(user, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == folder.Name {
+		return folder, nil
+	}
+
+	parent, err := folderParent(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkFolderName(user, parent, name); err != nil {
+		return nil, err
+	}
+
+	return folder, folder.Rename(name)
+}
+
+// folderParent returns the folder which contains the given folder, or nil for the top level.
+func folderParent(folder *models.Folder) (*models.Folder, error) {
+	path, err := folder.Path()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(path) < 2 {
+		return nil, nil
+	}
+
+	return &path[len(path)-2], nil
+}
+
+// moveFolder moves one of the user's folders inside another folder.
+func moveFolder(user *models.User, folderID, parentID string) error {
+	folder, err := findOwnedFolder(user, folderID)
+	if err != nil {
+		return err
+	}
+
+	parent, err := findFolder(user, parentID)
+	if err != nil {
+		return err
+	}
+
+	// The folder is already inside the parent.
+	if (parent == nil && folder.ParentID == nil) ||
+		(parent != nil && folder.ParentID != nil && *folder.ParentID == parent.ID) {
+		return nil
+	}
+
+	if err := checkFolderName(user, parent, folder.Name); err != nil {
+		return err
+	}
+
+	return folderError(folder.Move(parent))
+}
+
+// moveFile moves one of the user's files inside a folder.
+func moveFile(user *models.User, fileID, folderID string) error {
+	file, err := findOwnedFile(user, fileID)
+	if err != nil {
+		return err
+	}
+
+	folder, err := findFolder(user, folderID)
+	if err != nil {
+		return err
+	}
+
+	return file.MoveTo(folder)
+}
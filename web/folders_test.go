@@ -0,0 +1,117 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"gorm.io/gorm"
+)
+
+func TestEmptyFolderIDsAreRejected(t *testing.T) {
+	user := &models.User{}
+
+	if _, err := findOwnedFolder(user, ""); err != errInvalidInput {
+		t.Errorf("findOwnedFolder: expected invalid input, got: %v", err)
+	}
+
+	if _, err := renameFolder(user, "", "name"); err != errInvalidInput {
+		t.Errorf("renameFolder: expected invalid input, got: %v", err)
+	}
+
+	if err := moveFolder(user, "", ""); err != errInvalidInput {
+		t.Errorf("moveFolder: expected invalid input, got: %v", err)
+	}
+
+	if err := moveFile(user, "", ""); err != errInvalidInput {
+		t.Errorf("moveFile: expected invalid input, got: %v", err)
+	}
+}
+
+func TestValidFolderName(t *testing.T) {
+	if name, err := validFolderName("  photos "); err != nil || name != "photos" {
+		t.Errorf("wrong name. want=%q, got=%q, err: %v", "photos", name, err)
+	}
+
+	for _, name := range []string{"", "   ", strings.Repeat("a", maxFolderNameLength+1)} {
+		if _, err := validFolderName(name); err != errInvalidInput {
+			t.Errorf("expected invalid input for %q, got: %v", name, err)
+		}
+	}
+}
+
+func TestFolderTree(t *testing.T) {
+	user := newTestUser(t)
+
+	parent, err := createFolder(user, "parent", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := createFolder(user, "child", parent.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createFolder(user, "child", parent.UUID); err != errConflict {
+		t.Errorf("expected a conflict for a duplicate name, got: %v", err)
+	}
+
+	// A folder cannot be moved inside its own subfolder.
+	if err := moveFolder(user, parent.UUID, child.UUID); err != errInvalidInput {
+		t.Errorf("expected invalid input when moving a folder inside itself, got: %v", err)
+	}
+
+	// The other users cannot use the folder.
+	other := newTestUser(t)
+	if _, err := renameFolder(other, parent.UUID, "renamed"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("another user should not find the folder, got: %v", err)
+	}
+
+	file := newTestFile(t, user, "inside.txt", "contents")
+	if err := moveFile(user, file.UUID, child.UUID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the parent moves the files in the subfolders into the trash.
+	if err := parent.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := models.FindUserFolder(user.ID, child.UUID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("the subfolder should have been removed, got: %v", err)
+	}
+
+	var trashed models.File
+	if err := lib.GetDatabase().Unscoped().Where("uuid = ?", file.UUID).First(&trashed).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if !trashed.Trashed {
+		t.Error("the file inside the removed folder should be in the trash")
+	}
+}
+
+func TestDeleteFolderWithoutID(t *testing.T) {
+	user := newTestUser(t)
+
+	folder, err := createFolder(user, "kept", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/folders/delete", strings.NewReader(url.Values{"folder": {""}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rec := serve(t, user, r); rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code. want=400, got=%d", rec.Code)
+	}
+
+	if _, err := models.FindUserFolder(user.ID, folder.UUID); err != nil {
+		t.Errorf("the folder should not have been removed, err: %v", err)
+	}
+}
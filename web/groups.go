@@ -0,0 +1,407 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/templates"
+)
+
+// maxGroupNameLength is the longest name a group can have.
+const maxGroupNameLength = 64
+
+// ServeGroups serves the user a page listing the groups they belong to, from which new groups can be
+// created.
+func ServeGroups(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	groups, err := user.FindGroups()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	templates.Groups(w, templates.GroupsParams{
+		Title:         "groups",
+		Authenticated: true,
+		Groups:        groups,
+	})
+}
+
+// CreateGroup creates a new group with the name given in the 'name' field. The user becomes the owner
+// of the group.
+func CreateGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > maxGroupNameLength {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	group, err := models.CreateGroup(user, name)
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, groupPath(group), http.StatusSeeOther)
+}
+
+// ServeGroup serves a page with the members of a group and the files and folders shared with it. The
+// owners and the admins can manage the members on the page.
+func ServeGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	group, role, err := findMemberGroup(user, r.FormValue("group"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	members, err := group.FindMembers()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	files, err := group.FindSharedFiles()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	folders, err := group.FindSharedFolders()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	templates.Group(w, templates.GroupParams{
+		Title:         group.Name,
+		Authenticated: true,
+		Group:         group,
+		UserID:        user.ID,
+		Owner:         role == models.RoleOwner,
+		CanManage:     canManageMembers(role),
+		Members:       members,
+		Files:         files,
+		Folders:       folders,
+	})
+}
+
+// AddGroupMember adds the user given in the 'username' field to the group with the role given in the
+// 'role' field. The owners and the admins can add members, but only the owners can add other owners and
+// admins.
+func AddGroupMember(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, role, err := findMemberGroup(user, r.FormValue("group"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	newRole := r.FormValue("role")
+	if newRole == "" {
+		newRole = models.RoleMember
+	}
+
+	if !models.ValidRole(newRole) {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	if !canManageMembers(role) || (newRole != models.RoleMember && role != models.RoleOwner) {
+		ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
+		return
+	}
+
+	member, err := findMember(r.FormValue("username"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := group.AddMember(member, newRole); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, groupPath(group), http.StatusSeeOther)
+}
+
+// SetGroupRole changes the role of the member given in the 'username' field to the role given in the
+// 'role' field. Only the owners can change the roles.
+func SetGroupRole(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, role, err := findMemberGroup(user, r.FormValue("group"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if role != models.RoleOwner {
+		ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
+		return
+	}
+
+	newRole := r.FormValue("role")
+	if !models.ValidRole(newRole) {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	member, err := findMember(r.FormValue("username"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := group.SetRole(member.ID, newRole); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, groupPath(group), http.StatusSeeOther)
+}
+
+// RemoveGroupMember removes the member given in the 'username' field from the group. Every member can
+// leave the group, the admins can remove the members and the owners can remove anyone. The removed
+// member loses the access to the files shared with the group right away.
+func RemoveGroupMember(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, role, err := findMemberGroup(user, r.FormValue("group"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	member, err := findMember(r.FormValue("username"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if member.ID != user.ID && role != models.RoleOwner {
+		memberRole, err := group.Role(member.ID)
+		if err != nil {
+			ErrorPageHandler(w, r, errorContent(err))
+			return
+		}
+
+		if role != models.RoleAdmin || memberRole != models.RoleMember {
+			ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
+			return
+		}
+	}
+
+	if err := group.RemoveMember(member.ID); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	// The user who left the group cannot see its page anymore.
+	if member.ID == user.ID {
+		http.Redirect(w, r, "/groups", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, groupPath(group), http.StatusSeeOther)
+}
+
+// DeleteGroup removes the group along with its memberships and shares. Only the owners can delete the
+// group.
+func DeleteGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, role, err := findMemberGroup(user, r.FormValue("group"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if role != models.RoleOwner {
+		ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
+		return
+	}
+
+	if err := group.Delete(); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+// ShareWithGroup shares the user's file given in the 'file' field, or the user's folder given in the
+// 'folder' field, with a group the user belongs to. The form can contain the 'permission' and the number
+// of hours until the share expires in 'expires'. Sharing the same file or folder again replaces the
+// earlier share.
+func ShareWithGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, _, err := findMemberGroup(user, r.FormValue("group"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	permission := r.FormValue("permission")
+	if permission == "" {
+		permission = models.PermissionDownload
+	}
+
+	if !models.ValidPermission(permission) {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	expiresAt, err := parseExpiry(r.FormValue("expires"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if fileID := r.FormValue("file"); fileID != "" {
+		var file *models.File
+		if file, err = findOwnedFile(user, fileID); err == nil {
+			err = group.ShareFile(user, file, permission, expiresAt)
+		}
+	} else {
+		var folder *models.Folder
+		if folder, err = findOwnedFolder(user, r.FormValue("folder")); err == nil {
+			err = group.ShareFolder(user, folder, permission, expiresAt)
+		}
+	}
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, groupPath(group), http.StatusSeeOther)
+}
+
+// UnshareFromGroup removes the share of the file given in the 'file' field, or the folder given in the
+// 'folder' field, from the group. The owner of the file or the folder and the owners and the admins of
+// the group can remove the share.
+func UnshareFromGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	group, role, err := findMemberGroup(user, r.FormValue("group"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := unshareFromGroup(user, group, role, r.FormValue("file"), r.FormValue("folder")); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, groupPath(group), http.StatusSeeOther)
+}
+
+// unshareFromGroup removes the share of the file, or the folder if the file is not given, from the group.
+// The user needs to own the file or the folder, or be an owner or an admin of the group.
+func unshareFromGroup(user *models.User, group *models.Group, role, fileID, folderID string) error {
+	if fileID != "" {
+		file, err := models.FindOneFile(&models.File{UUID: fileID})
+		if err != nil {
+			return err
+		}
+
+		if file.UserID != user.ID && !canManageMembers(role) {
+			return errNoAccess
+		}
+
+		return group.UnshareFile(file.ID)
+	}
+
+	if folderID == "" {
+		return errInvalidInput
+	}
+
+	folder, err := models.FindFolder(folderID)
+	if err != nil {
+		return err
+	}
+
+	if folder.UserID != user.ID && !canManageMembers(role) {
+		return errNoAccess
+	}
+
+	return group.UnshareFolder(folder.ID)
+}
+
+// findMemberGroup finds the group with the given uuid along with the user's role in it. The groups the
+// user doesn't belong to are reported as not found.
+func findMemberGroup(user *models.User, groupID string) (*models.Group, string, error) {
+	group, err := models.FindGroup(groupID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	role, err := group.Role(user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return group, role, nil
+}
+
+// findMember finds the user with the given username, who is added to a group or whose membership is
+// changed. An empty username is rejected, since it would match any user.
+func findMember(username string) (*models.User, error) {
+	if username == "" {
+		return nil, errInvalidInput
+	}
+
+	return models.FindOneUser(&models.User{Username: username})
+}
+
+// canManageMembers checks if the role can add and remove the members of a group.
+func canManageMembers(role string) bool {
+	return role == models.RoleOwner || role == models.RoleAdmin
+}
+
+// groupPath returns the path of the group's page.
+func groupPath(group *models.Group) string {
+	return "/group?group=" + group.UUID
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/nireo/upfi/models"
+)
+
+func TestEmptyGroupInputIsRejected(t *testing.T) {
+	if _, err := findMember(""); err != errInvalidInput {
+		t.Errorf("findMember: expected invalid input, got: %v", err)
+	}
+
+	if err := unshareFromGroup(&models.User{}, &models.Group{}, models.RoleOwner, "", ""); err != errInvalidInput {
+		t.Errorf("unshareFromGroup: expected invalid input, got: %v", err)
+	}
+}
+
+func TestCanManageMembers(t *testing.T) {
+	tests := map[string]bool{
+		models.RoleOwner:  true,
+		models.RoleAdmin:  true,
+		models.RoleMember: false,
+		"":                false,
+	}
+
+	for role, want := range tests {
+		if got := canManageMembers(role); got != want {
+			t.Errorf("canManageMembers(%q): want=%t, got=%t", role, want, got)
+		}
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	owner := newTestUser(t)
+	member := newTestUser(t)
+	outsider := newTestUser(t)
+
+	group, err := models.CreateGroup(owner, "members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := group.Delete(); err != nil {
+			t.Error("could not remove the test group, err: ", err)
+		}
+	})
+
+	// An empty username must not match any user.
+	rec := postForm(t, owner, "/groups/members", url.Values{"group": {group.UUID}, "username": {""}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code for an empty username. want=400, got=%d", rec.Code)
+	}
+
+	rec = postForm(t, owner, "/groups/members", url.Values{"group": {group.UUID}, "username": {member.Username}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("could not add the member. status=%d", rec.Code)
+	}
+
+	if role, err := group.Role(member.ID); err != nil || role != models.RoleMember {
+		t.Errorf("wrong role for the new member. role=%q, err: %v", role, err)
+	}
+
+	// The plain members cannot add other users.
+	rec = postForm(t, member, "/groups/members", url.Values{"group": {group.UUID}, "username": {outsider.Username}})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("a member should not add users. want=403, got=%d", rec.Code)
+	}
+
+	// The users outside of the group don't see it.
+	if _, _, err := findMemberGroup(outsider, group.UUID); err == nil {
+		t.Error("the outsider should not find the group")
+	}
+
+	// The last owner cannot leave the group.
+	rec = postForm(t, owner, "/groups/members/remove", url.Values{"group": {group.UUID}, "username": {owner.Username}})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("the last owner should not leave. want=409, got=%d", rec.Code)
+	}
+}
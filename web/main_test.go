@@ -0,0 +1,150 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/middleware"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
+)
+
+// hasDatabase tells if the test database has been configured. The tests which need the database are
+// skipped without it.
+var hasDatabase bool
+
+// TestMain connects to the test database, if it's configured in the environment or in the .env file at
+// the root of the repository. The file contents are kept in memory, so that the tests don't leave files
+// on the disk.
+func TestMain(m *testing.M) {
+	godotenv.Load("../.env")
+
+	if os.Getenv("db_host") != "" {
+		if err := models.ConnectToDatabase(&models.DatabaseConfig{
+			User: os.Getenv("db_username"),
+			Port: os.Getenv("db_port"),
+			Host: os.Getenv("db_host"),
+			Name: os.Getenv("db_name"),
+		}); err != nil {
+			log.Fatal(err)
+		}
+		hasDatabase = true
+	}
+
+	storage.SetBackend(storage.NewMemory())
+
+	// Sign the test tokens with a fixed key.
+	if err := lib.SetSigningKeys([]lib.SigningKey{{ID: "test", Secret: []byte(strings.Repeat("k", lib.MinSecretLength))}}); err != nil {
+		log.Fatal(err)
+	}
+
+	middleware.SetHTTPLogging(false)
+	os.Exit(m.Run())
+}
+
+// requireDatabase skips the test if the test database has not been configured.
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	if !hasDatabase {
+		t.Skip("the test database is not configured")
+	}
+}
+
+// testMaster is the master password of the test users.
+const testMaster = "master password"
+
+// newTestUser creates a user with a unique username, which is removed after the test.
+func newTestUser(t *testing.T) *models.User {
+	t.Helper()
+	requireDatabase(t)
+
+	password, err := lib.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master, err := lib.HashPassword(testMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &models.User{
+		Username:             "test-" + lib.GenerateUUID()[:8],
+		Password:             password,
+		FileEncryptionMaster: master,
+		UUID:                 lib.GenerateUUID(),
+	}
+	if err := lib.GetDatabase().Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := user.Delete(); err != nil {
+			t.Error("could not remove the test user, err: ", err)
+		}
+	})
+
+	kek, err := user.MasterKey(testMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := user.PrivateKey(kek); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// newTestFile stores an unencrypted file with the given contents for the user.
+func newTestFile(t *testing.T, user *models.User, filename, contents string) *models.File {
+	t.Helper()
+
+	file, err := newFileEntry(user, filename, "", "text/plain; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storeFile(user, file, strings.NewReader(contents), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// serve sends the request to the router as the given user. Requests without a user are not
+// authenticated.
+func serve(t *testing.T, user *models.User, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	if user != nil {
+		rec := httptest.NewRecorder()
+		if err := startSession(rec, r, user); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, cookie := range rec.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewRouter().ServeHTTP(rec, r)
+	return rec
+}
+
+// postForm posts the form to the path as the given user.
+func postForm(t *testing.T, user *models.User, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(t, user, r)
+}
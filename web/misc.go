@@ -1,13 +1,58 @@
 package web
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
 	"github.com/nireo/upfi/templates"
+	"gorm.io/gorm"
 )
 
+var (
+	// errNoAccess is returned when the user tries to access something they don't have access to.
+	errNoAccess = errors.New("no access")
+
+	// errInvalidInput is returned when the user's input is missing or invalid.
+	errInvalidInput = errors.New("invalid input")
+
+	// errConflict is returned when the user tries to create something that already exists.
+	errConflict = errors.New("already exists")
+)
+
+// errorContent returns the error content matching the error, which is then shown to the user either as
+// an error page or as a json error.
+func errorContent(err error) lib.ErrorPageContent {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, storage.ErrNotFound):
+		return lib.NotFoundErrorPage
+	case errors.Is(err, errNoAccess), errors.Is(err, errWrongMaster), errors.Is(err, errWrongLinkPassword):
+		return lib.ForbiddenErrorPage
+	case errors.Is(err, errInvalidInput), errors.Is(err, models.ErrInvalidQuery), errors.Is(err, models.ErrInvalidTag):
+		return lib.BadRequestErrorPage
+	case errors.Is(err, errConflict):
+		return lib.ConflictErrorPage
+	case errors.Is(err, errTooLarge):
+		return lib.TooLargeErrorPage
+	case errors.Is(err, models.ErrQuotaExceeded):
+		return lib.QuotaExceededErrorPage
+	case errors.Is(err, models.ErrLinkExpired), errors.Is(err, models.ErrLinkExhausted):
+		return lib.LinkUnavailableErrorPage
+	case errors.Is(err, models.ErrNoPublicKey):
+		return lib.NoPublicKeyErrorPage
+	case errors.Is(err, models.ErrLastOwner), errors.Is(err, models.ErrAlreadyMember),
+		errors.Is(err, models.ErrEncryptedGroupShare):
+		return *lib.CreateDetailedErrorContent(err, "Cannot change the group", http.StatusConflict)
+	case errors.Is(err, models.ErrTagExists):
+		return *lib.CreateDetailedErrorContent(err, "Cannot rename the tag", http.StatusConflict)
+	default:
+		return lib.InternalServerErrorPage
+	}
+}
+
 // ErrorPageHandler takes in a request context and a error type which is then used in a template to
 // dynamically display an error page.
 func ErrorPageHandler(w http.ResponseWriter, r *http.Request,
@@ -34,3 +79,20 @@ func ServeHomePage(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 		Authenticated: lib.IsAuth(r),
 	})
 }
+
+// formUser finds the user who sent the request and parses the form, which can be either url encoded or
+// multipart. If either fails, an error page is shown.
+func formUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return nil, false
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return nil, false
+	}
+
+	return user, true
+}
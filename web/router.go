@@ -8,9 +8,11 @@ import (
 	"github.com/gorilla/csrf"
 	"github.com/julienschmidt/httprouter"
 	"github.com/nireo/upfi/middleware"
+	"github.com/nireo/upfi/models"
 )
 
-func StartServer(port string) {
+// NewRouter creates the router with all of the routes.
+func NewRouter() *httprouter.Router {
 	router := httprouter.New()
 
 	// misc
@@ -20,7 +22,8 @@ func StartServer(port string) {
 	router.GET("/login", middleware.SecureHeaders(ServeLoginPage))
 	router.GET("/register", middleware.SecureHeaders(ServeRegisterPage))
 	router.POST("/login", middleware.SecureHeaders(Login))
-	router.POST("/register", middleware.SecureHeaders(ServeRegisterPage))
+	router.POST("/register", middleware.SecureHeaders(Register))
+	router.POST("/logout", middleware.CheckToken(Logout))
 
 	// files
 	router.GET("/file", middleware.CheckToken(GetSingleFile))
@@ -28,21 +31,102 @@ func StartServer(port string) {
 	router.POST("/upload", middleware.CheckToken(UploadFile))
 	router.GET("/files", middleware.CheckToken(GetUserFiles))
 	router.PATCH("/file", middleware.CheckToken(UpdateFile))
+	router.POST("/file", middleware.CheckToken(UpdateFile))
 	router.POST("/download", middleware.CheckToken(DownloadFile))
 	router.DELETE("/shared", middleware.CheckToken(DeleteSharedContract))
 	router.GET("/shared_by", middleware.CheckToken(GetSharedByUser))
 	router.GET("/shared_to", middleware.CheckToken(GetSharedToUser))
 	router.GET("/share", middleware.CheckToken(ServeCreateSharedPage))
 	router.POST("/share", middleware.CheckToken(CreateSharedFile))
+	router.POST("/files/move", middleware.CheckToken(MoveFile))
+
+	// trash
+	router.GET("/trash", middleware.CheckToken(ServeTrash))
+	router.POST("/trash/restore", middleware.CheckToken(RestoreFile))
+	router.POST("/trash/delete", middleware.CheckToken(DeleteTrashedFile))
+	router.POST("/trash/empty", middleware.CheckToken(EmptyTrash))
+
+	// share links
+	router.POST("/links", middleware.CheckToken(CreateShareLink))
+	router.POST("/links/delete", middleware.CheckToken(DeleteShareLink))
+	router.GET("/s/:token", middleware.SecureHeaders(ServeShareLink))
+	router.POST("/s/:token", middleware.SecureHeaders(DownloadShareLink))
+
+	// versions
+	router.POST("/versions", middleware.CheckToken(UploadVersion))
+	router.POST("/versions/download", middleware.CheckToken(DownloadVersion))
+	router.POST("/versions/restore", middleware.CheckToken(RestoreVersion))
+	router.POST("/versions/delete", middleware.CheckToken(DeleteVersion))
+
+	// folders
+	router.POST("/folders", middleware.CheckToken(CreateFolder))
+	router.POST("/folders/rename", middleware.CheckToken(RenameFolder))
+	router.POST("/folders/move", middleware.CheckToken(MoveFolder))
+	router.POST("/folders/delete", middleware.CheckToken(DeleteFolder))
+
+	// groups
+	router.GET("/groups", middleware.CheckToken(ServeGroups))
+	router.POST("/groups", middleware.CheckToken(CreateGroup))
+	router.GET("/group", middleware.CheckToken(ServeGroup))
+	router.POST("/groups/members", middleware.CheckToken(AddGroupMember))
+	router.POST("/groups/members/remove", middleware.CheckToken(RemoveGroupMember))
+	router.POST("/groups/role", middleware.CheckToken(SetGroupRole))
+	router.POST("/groups/delete", middleware.CheckToken(DeleteGroup))
+	router.POST("/groups/share", middleware.CheckToken(ShareWithGroup))
+	router.POST("/groups/unshare", middleware.CheckToken(UnshareFromGroup))
+
+	// tags
+	router.GET("/tags", middleware.CheckToken(ServeTags))
+	router.POST("/tags/rename", middleware.CheckToken(RenameTag))
+	router.POST("/tags/merge", middleware.CheckToken(MergeTags))
 
 	// user
 	router.DELETE("/remove", middleware.CheckToken(DeleteUser))
 	router.PATCH("/password", middleware.CheckToken(UpdatePassword))
+	router.POST("/master", middleware.CheckToken(ChangeMaster))
 	router.GET("/settings", middleware.CheckToken(ServeSettingsPage))
 	router.POST("/settings", middleware.CheckToken(HandleSettingChange))
+	router.POST("/tokens", middleware.CheckToken(CreateAPIToken))
+	router.POST("/tokens/revoke", middleware.CheckToken(RevokeAPIToken))
+	router.POST("/sessions/revoke", middleware.CheckToken(RevokeSession))
+	router.POST("/sessions/revoke_all", middleware.CheckToken(SignOutEverywhere))
+	router.POST("/settings/versions", middleware.CheckToken(UpdateVersionRetention))
+
+	// admin
+	router.GET("/admin/users", middleware.CheckToken(ServeAdminUsers))
+	router.POST("/admin/quota", middleware.CheckToken(SetUserQuota))
+
+	// json api
+	router.GET("/api/v1/files", middleware.CheckAPIToken(models.ScopeRead, APIListFiles))
+	router.POST("/api/v1/files", middleware.CheckAPIToken(models.ScopeWrite, APIUploadFile))
+	router.GET("/api/v1/files/:file", middleware.CheckAPIToken(models.ScopeRead, APIGetFile))
+	router.PATCH("/api/v1/files/:file", middleware.CheckAPIToken(models.ScopeWrite, APIUpdateFile))
+	router.DELETE("/api/v1/files/:file", middleware.CheckAPIToken(models.ScopeWrite, APIDeleteFile))
+	router.GET("/api/v1/files/:file/download", middleware.CheckAPIToken(models.ScopeRead, APIDownloadFile))
+	router.POST("/api/v1/files/:file/download", middleware.CheckAPIToken(models.ScopeRead, APIDownloadFile))
+	router.POST("/api/v1/files/:file/shares", middleware.CheckAPIToken(models.ScopeShare, APIShareFile))
+	router.GET("/api/v1/shared/:type", middleware.CheckAPIToken(models.ScopeRead, APIListShared))
+	router.DELETE("/api/v1/shared/:type/:file", middleware.CheckAPIToken(models.ScopeShare, APIDeleteShare))
+	router.OPTIONS("/api/v1/uploads", TusOptions)
+	router.POST("/api/v1/uploads", middleware.CheckAPIToken(models.ScopeWrite, TusCreate))
+	router.HEAD("/api/v1/uploads/:upload", middleware.CheckAPIToken(models.ScopeWrite, TusHead))
+	router.PATCH("/api/v1/uploads/:upload", middleware.CheckAPIToken(models.ScopeWrite, TusPatch))
+	router.DELETE("/api/v1/uploads/:upload", middleware.CheckAPIToken(models.ScopeWrite, TusDelete))
+	router.GET("/api/v1/account", middleware.CheckAPIToken(models.ScopeRead, APIGetAccount))
+	router.PATCH("/api/v1/account", middleware.CheckAPIToken(models.ScopeWrite, APIUpdateAccount))
+	router.DELETE("/api/v1/account", middleware.CheckAPIToken(models.ScopeWrite, APIDeleteAccount))
+	router.PUT("/api/v1/account/password", middleware.CheckAPIToken(models.ScopeWrite, APIUpdatePassword))
+	router.PUT("/api/v1/account/master", middleware.CheckAPIToken(models.ScopeWrite, APIChangeMaster))
 
+	return router
+}
+
+// StartServer starts serving the routes on the given port.
+func StartServer(port string) {
+	router := NewRouter()
 	csrfSecret := os.Getenv("csrfkey")
 
 	CSRF := csrf.Protect([]byte(csrfSecret), nil)
-	log.Fatal(http.ListenAndServe(":"+port, CSRF(router)))
+	handler := middleware.SkipCSRFForAPITokens(CSRF(router))
+	log.Fatal(http.ListenAndServe(":"+port, middleware.LimitBodySize(maxUploadSize+maxFormOverhead, handler)))
 }
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/templates"
+	"gorm.io/gorm"
+)
+
+// errWrongLinkPassword is returned when the password of a share link is wrong.
+var errWrongLinkPassword = errors.New("wrong share link password")
+
+// CreateShareLink creates a public share link to an unencrypted file owned by the user. The form can
+// contain a 'password', the number of hours until the link expires in 'expires' and the maximum number
+// of downloads in 'max_downloads'.
+func CreateShareLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := findOwnedFile(user, fileIDParam(r, ps))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	// The server cannot decrypt the encrypted files without the master password, so they cannot be
+	// downloaded by anyone else.
+	if !file.ShareableFile {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	// The link never expires, if the number of hours is not given.
+	expiresAt, err := parseExpiry(r.FormValue("expires"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	var maxDownloads int
+	if value := r.FormValue("max_downloads"); value != "" {
+		if maxDownloads, err = strconv.Atoi(value); err != nil || maxDownloads < 0 {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+			return
+		}
+	}
+
+	if _, err := models.CreateShareLink(file, r.FormValue("password"), expiresAt, maxDownloads); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, filePath(file), http.StatusSeeOther)
+}
+
+// DeleteShareLink removes a share link of a file owned by the user. The link cannot be used after this.
+func DeleteShareLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	file, err := findOwnedFile(user, fileIDParam(r, ps))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	token := r.URL.Query().Get("link")
+	if token == "" {
+		ErrorPageHandler(w, r, errorContent(errInvalidInput))
+		return
+	}
+
+	if err := file.DeleteShareLink(token); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, filePath(file), http.StatusSeeOther)
+}
+
+// findLinkedFile finds the share link with the token in the route and the file it links to. Links which
+// have expired or reached their download limit return an error.
+func findLinkedFile(ps httprouter.Params) (*models.ShareLink, *models.File, error) {
+	link, err := models.FindShareLink(ps.ByName("token"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := link.Available(); err != nil {
+		return nil, nil, err
+	}
+
+	// The files in the trash are not found, so their links stop working until they are restored.
+	file, err := models.FindOneFile(&models.File{Model: gorm.Model{ID: link.FileID}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return link, file, nil
+}
+
+// ServeShareLink serves anyone who has a share link a page, from which the linked file can be
+// downloaded. The page asks for the password of the link, if it has one.
+func ServeShareLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	link, file, err := findLinkedFile(ps)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	templates.ShareLink(w, templates.ShareLinkParams{
+		Title:         file.Filename,
+		Authenticated: lib.IsAuth(r),
+		File:          *file,
+		Token:         link.Token,
+		NeedsPassword: link.HasPassword(),
+	})
+}
+
+// DownloadShareLink lets anyone who has a share link download the linked file. The password of the link
+// is given in the 'password' field. Every request counts towards the download limit.
+func DownloadShareLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	link, file, err := findLinkedFile(ps)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if link.HasPassword() {
+		if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+			return
+		}
+
+		if !link.CheckPassword(r.FormValue("password")) {
+			ErrorPageHandler(w, r, errorContent(errWrongLinkPassword))
+			return
+		}
+	}
+
+	owner, err := models.FindOneUser(&models.User{Model: gorm.Model{ID: file.UserID}})
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	// Every request is counted before anything is sent, ranged ones included, since the ranges could
+	// otherwise be used to download the whole file past the limit.
+	if err := link.CountDownload(); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := sendFile(w, r, file, owner, ""); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+}
+
+// parseExpiry parses the number of hours until a share or a share link expires, and returns the
+// expiration time. Nil is returned if the number of hours is not given, in which case it never expires.
+func parseExpiry(hours string) (*time.Time, error) {
+	if hours == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(hours)
+	if err != nil || n <= 0 {
+		return nil, errInvalidInput
+	}
+
+	expiration := time.Now().Add(time.Duration(n) * time.Hour)
+	return &expiration, nil
+}
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/upfi/models"
+)
+
+func TestParseExpiry(t *testing.T) {
+	if expiry, err := parseExpiry(""); err != nil || expiry != nil {
+		t.Errorf("an empty value should never expire. expiry=%v, err: %v", expiry, err)
+	}
+
+	before := time.Now()
+	expiry, err := parseExpiry("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := before.Add(2 * time.Hour); expiry.Before(want) || expiry.After(want.Add(time.Minute)) {
+		t.Errorf("wrong expiration time. want=%v, got=%v", want, expiry)
+	}
+
+	for _, hours := range []string{"0", "-1", "soon"} {
+		if _, err := parseExpiry(hours); err != errInvalidInput {
+			t.Errorf("expected invalid input for %q, got: %v", hours, err)
+		}
+	}
+}
+
+// downloadLink downloads the first bytes of the linked file with a range request.
+func downloadLink(t *testing.T, token, password string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/s/"+token, strings.NewReader(url.Values{"password": {password}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Range", "bytes=0-3")
+	return serve(t, nil, r)
+}
+
+func TestShareLinkRangesCountTowardsTheLimit(t *testing.T) {
+	user := newTestUser(t)
+	file := newTestFile(t, user, "linked.txt", "linked contents")
+
+	link, err := models.CreateShareLink(file, "secret", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong password doesn't use up the downloads.
+	if rec := downloadLink(t, link.Token, "wrong"); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code for a wrong password. want=403, got=%d", rec.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := downloadLink(t, link.Token, "secret")
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != "link" {
+			t.Fatalf("wrong response for download %d. status=%d, body=%q", i+1, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := downloadLink(t, link.Token, "secret"); rec.Code != http.StatusGone {
+		t.Errorf("the ranged downloads should have used up the link. want=410, got=%d", rec.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/templates"
+)
+
+// ServeTags serves the user a page listing their tags along with the number of files they have. The tags
+// can be renamed and merged on the page.
+func ServeTags(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	tags, err := user.FindTags()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	templates.Tags(w, templates.TagsParams{
+		Title:         "tags",
+		Authenticated: true,
+		Tags:          tags,
+	})
+}
+
+// RenameTag renames the user's tag given in the 'tag' field to the name given in the 'name' field, on all
+// of the user's files. The name cannot be the name of another tag, those tags need to be merged instead.
+func RenameTag(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	tag := r.FormValue("tag")
+	if tag == "" {
+		ErrorPageHandler(w, r, errorContent(models.ErrInvalidTag))
+		return
+	}
+
+	if err := user.RenameTag(tag, r.FormValue("name")); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusSeeOther)
+}
+
+// MergeTags moves the files of the user's tag given in the 'tag' field to the tag given in the 'into'
+// field, and removes the merged tag.
+func MergeTags(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	tag, into := r.FormValue("tag"), r.FormValue("into")
+	if tag == "" || into == "" {
+		ErrorPageHandler(w, r, errorContent(models.ErrInvalidTag))
+		return
+	}
+
+	if err := user.MergeTags(tag, into); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusSeeOther)
+}
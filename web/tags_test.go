@@ -0,0 +1,62 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/models"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags(" work,, notes ,work")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(tags, ",") != "work,notes" {
+		t.Errorf("wrong tags: %v", tags)
+	}
+
+	if tags, err := parseTags(""); err != nil || len(tags) != 0 {
+		t.Errorf("an empty value should have no tags. tags=%v, err: %v", tags, err)
+	}
+
+	if _, err := parseTags(strings.Repeat("a", models.MaxTagLength+1)); err != models.ErrInvalidTag {
+		t.Errorf("expected an invalid tag, got: %v", err)
+	}
+}
+
+func TestEmptyTagsAreRejected(t *testing.T) {
+	user := newTestUser(t)
+	file := newTestFile(t, user, "tagged.txt", "tagged")
+	if err := file.SetTags([]string{"work"}); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		path string
+		form url.Values
+	}{
+		{"/tags/rename", url.Values{"tag": {""}, "name": {"office"}}},
+		{"/tags/rename", url.Values{"tag": {"work"}, "name": {""}}},
+		{"/tags/merge", url.Values{"tag": {""}, "into": {"work"}}},
+		{"/tags/merge", url.Values{"tag": {"work"}, "into": {""}}},
+	}
+
+	for _, request := range requests {
+		if rec := postForm(t, user, request.path, request.form); rec.Code != http.StatusBadRequest {
+			t.Errorf("wrong status code for %s %v. want=400, got=%d", request.path, request.form, rec.Code)
+		}
+	}
+
+	tags, err := file.FindTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(tags, ",") != "work" {
+		t.Errorf("the tags should not have changed, got: %v", tags)
+	}
+}
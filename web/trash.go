@@ -0,0 +1,107 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/templates"
+)
+
+// ServeTrash serves the user a page listing the files in their trash, from which the files can be
+// restored or removed for good.
+func ServeTrash(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	files, err := user.FindTrash()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	rows := make([]templates.TrashRow, len(files))
+	for i := range files {
+		rows[i] = templates.TrashRow{
+			File:      files[i],
+			TrashedAt: files[i].DeletedAt.Time,
+			PurgeAt:   files[i].PurgeAt(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	templates.Trash(w, templates.TrashParams{
+		Title:         "trash",
+		Authenticated: true,
+		Files:         rows,
+		TrashDays:     models.TrashDays,
+	})
+}
+
+// findTrashedFile finds the user who made the request and the file in their trash given in the 'file'
+// query.
+func findTrashedFile(r *http.Request) (*models.User, *models.File, error) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := user.FindTrashedFile(r.URL.Query().Get("file"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, file, nil
+}
+
+// RestoreFile moves a file back from the trash.
+func RestoreFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_, file, err := findTrashedFile(r)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := file.Restore(); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// DeleteTrashedFile removes a file in the trash for good.
+func DeleteTrashedFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, file, err := findTrashedFile(r)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := file.Delete(user.UUID); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// EmptyTrash removes all of the files in the user's trash for good.
+func EmptyTrash(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	if err := user.EmptyTrash(); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
@@ -0,0 +1,526 @@
+package web
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
+)
+
+// The resumable uploads implement the core of the tus 1.0 protocol (https://tus.io/protocols/resumable-upload.html)
+// along with the creation and termination extensions. An upload is created with a POST request, which
+// returns the location of the upload. The data is then sent with PATCH requests starting from the
+// offset, which can be checked with a HEAD request after a connection has failed.
+//
+// The filename, the description and the folder are given in the Upload-Metadata header. If the file should be
+// encrypted, the master password is sent in the Upfi-Master header with every request, since the
+// staged data is encrypted as well.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+
+	// masterHeader is the header in which the master password of an encrypted upload is sent.
+	masterHeader = "Upfi-Master"
+)
+
+// uploadLock is the lock of a single upload along with the number of requests holding or waiting for it.
+type uploadLock struct {
+	sync.Mutex
+	requests int
+}
+
+// uploadLocks makes sure that a single upload receives data from only one request at a time. The locks
+// are removed once no request needs them.
+var (
+	uploadLocksMu sync.Mutex
+	uploadLocks   = make(map[string]*uploadLock)
+)
+
+// lockUpload locks the upload with the given id, and returns the function which releases the lock.
+func lockUpload(uploadID string) func() {
+	uploadLocksMu.Lock()
+	lock, ok := uploadLocks[uploadID]
+	if !ok {
+		lock = &uploadLock{}
+		uploadLocks[uploadID] = lock
+	}
+	lock.requests++
+	uploadLocksMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		uploadLocksMu.Lock()
+		lock.requests--
+		if lock.requests == 0 {
+			delete(uploadLocks, uploadID)
+		}
+		uploadLocksMu.Unlock()
+	}
+}
+
+// errOffsetMismatch is returned when the offset of a PATCH request doesn't match the upload's offset.
+var errOffsetMismatch = errors.New("the offset doesn't match the upload's offset")
+
+// TusOptions tells the client which version and extensions of the tus protocol are supported.
+func TusOptions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusCreate creates a new upload. The size of the file is given in the Upload-Length header.
+func TusCreate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		APIErrorHandler(w, errInvalidInput)
+		return
+	}
+
+	if length > maxUploadSize {
+		APIErrorHandler(w, errTooLarge)
+		return
+	}
+
+	// The quota is checked again when the upload is completed, since other files might have been
+	// uploaded in the meantime.
+	if remaining := user.RemainingQuota(); remaining >= 0 && length > remaining {
+		APIErrorHandler(w, models.ErrQuotaExceeded)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	// tus clients commonly use either 'filename' or 'name'.
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+
+	if filename == "" || len(metadata["description"]) >= 256 {
+		APIErrorHandler(w, errInvalidInput)
+		return
+	}
+
+	folder, err := findFolder(user, metadata["folder"])
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	upload := &models.Upload{
+		UUID:        lib.GenerateUUID(),
+		UserID:      user.ID,
+		Filename:    filename,
+		Description: metadata["description"],
+		Length:      length,
+	}
+
+	if folder != nil {
+		upload.FolderID = &folder.ID
+	}
+
+	// The data of an encrypted upload is encrypted with a data key already while it's being staged.
+	if master := r.Header.Get(masterHeader); master != "" {
+		kek, err := unlockMaster(user, master)
+		if err != nil {
+			APIErrorHandler(w, err)
+			return
+		}
+
+		dataKey, err := crypt.GenerateDataKey()
+		if err != nil {
+			APIErrorHandler(w, err)
+			return
+		}
+
+		upload.WrappedKey, err = crypt.WrapKey(kek, dataKey)
+		if err != nil {
+			APIErrorHandler(w, err)
+			return
+		}
+	}
+
+	if err := lib.GetDatabase().Create(upload).Error; err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Location", "/api/v1/uploads/"+upload.UUID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// TusHead returns the offset of the upload, from which the client can continue sending the data.
+func TusHead(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	user, err := apiUser(r)
+	if err != nil {
+		w.WriteHeader(errorContent(err).StatusCode)
+		return
+	}
+
+	upload, err := models.FindUserUpload(user.ID, ps.ByName("upload"))
+	if err != nil {
+		w.WriteHeader(errorContent(err).StatusCode)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// TusPatch receives data of the upload starting from the offset in the Upload-Offset header. The data is
+// staged as a new part of the upload. Once all of the data has been received, the parts are combined
+// into a file.
+func TusPatch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		lib.WriteErrorJSON(w, *lib.CreateSimpleErrorContent(http.StatusUnsupportedMediaType))
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		APIErrorHandler(w, errInvalidInput)
+		return
+	}
+
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	uploadID := ps.ByName("upload")
+	defer lockUpload(uploadID)()
+
+	upload, err := models.FindUserUpload(user.ID, uploadID)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if offset != upload.Offset {
+		lib.WriteErrorJSON(w, *lib.CreateDetailedErrorContent(errOffsetMismatch,
+			http.StatusText(http.StatusConflict), http.StatusConflict))
+		return
+	}
+
+	var kek, dataKey []byte
+	if upload.Encrypted() {
+		kek, dataKey, err = uploadKeys(user, upload, r.Header.Get(masterHeader))
+		if err != nil {
+			APIErrorHandler(w, err)
+			return
+		}
+	}
+
+	offset, parts := upload.Offset, upload.Parts
+	if err := stagePart(user, upload, r.Body, dataKey); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		file, err := completeUpload(user, upload, kek, dataKey)
+		if err != nil {
+			// The last part is removed, so that the client sends it again and the completion is retried,
+			// instead of the upload being stuck at its full length.
+			if err := unstageParts(user, upload, offset, parts); err != nil {
+				log.Println(err)
+			}
+
+			APIErrorHandler(w, err)
+			return
+		}
+
+		w.Header().Set("Upfi-File", file.UUID)
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusDelete terminates an upload and removes the data staged so far.
+func TusDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	user, err := apiUser(r)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	uploadID := ps.ByName("upload")
+	defer lockUpload(uploadID)()
+
+	upload, err := models.FindUserUpload(user.ID, uploadID)
+	if err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	if err := upload.Remove(user.UUID); err != nil {
+		APIErrorHandler(w, err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTusVersion checks that the client uses a supported version of the protocol.
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+// parseTusMetadata parses the Upload-Metadata header, which contains comma separated key and value pairs.
+// The values are base64 encoded.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, errInvalidInput
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errInvalidInput
+		}
+	}
+
+	return metadata, nil
+}
+
+// uploadKeys unwraps the data key of an encrypted upload using the master password.
+func uploadKeys(user *models.User, upload *models.Upload, master string) ([]byte, []byte, error) {
+	if master == "" {
+		return nil, nil, errWrongMaster
+	}
+
+	kek, err := user.MasterKey(master)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataKey, err := crypt.UnwrapKey(kek, upload.WrappedKey)
+	if err != nil {
+		return nil, nil, errWrongMaster
+	}
+
+	return kek, dataKey, nil
+}
+
+// stagePart stores the data of a PATCH request as the next part of the upload and moves the offset
+// forward. If the request fails halfway, the part is not stored, and the client continues from the
+// earlier offset.
+func stagePart(user *models.User, upload *models.Upload, body io.Reader, dataKey []byte) error {
+	counter := &countingReader{r: io.LimitReader(body, upload.Length-upload.Offset)}
+
+	var content io.Reader = counter
+	if dataKey != nil {
+		encrypted := crypt.EncryptReaderWithKey(counter, dataKey)
+		defer encrypted.Close()
+		content = encrypted
+	}
+
+	key := upload.PartKey(user.UUID, upload.Parts)
+	if _, err := storage.GetBackend().Put(key, content); err != nil {
+		return err
+	}
+
+	// An empty request doesn't need a part.
+	if counter.n == 0 {
+		return storage.GetBackend().Delete(key)
+	}
+
+	upload.Offset += counter.n
+	upload.Parts++
+
+	err := lib.GetDatabase().Model(upload).Updates(map[string]interface{}{
+		"offset": upload.Offset,
+		"parts":  upload.Parts,
+	}).Error
+	if err != nil {
+		storage.GetBackend().Delete(key)
+		return err
+	}
+
+	return nil
+}
+
+// completeUpload combines the staged parts into a file and removes the upload. Encrypted uploads are
+// stored as encrypted files.
+func completeUpload(user *models.User, upload *models.Upload, kek, dataKey []byte) (*models.File, error) {
+	parts := &partsReader{user: user, upload: upload, dataKey: dataKey}
+	defer parts.Close()
+	content := bufio.NewReader(parts)
+
+	// Read the mimetype from the start of the file.
+	fileHeader, err := content.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	newFileEntry, err := newFileEntry(user, upload.Filename, upload.Description, http.DetectContentType(fileHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	// The folder might have been removed while the upload was in progress, in which case the file is
+	// created at the top level.
+	if upload.FolderID != nil {
+		if err := lib.GetDatabase().First(&models.Folder{}, *upload.FolderID).Error; err == nil {
+			newFileEntry.FolderID = upload.FolderID
+		}
+	}
+
+	if err := storeFile(user, newFileEntry, content, kek); err != nil {
+		return nil, err
+	}
+
+	// The file has been created, so the upload is not retried. The stale uploads are removed later, if
+	// this fails.
+	if err := upload.Remove(user.UUID); err != nil {
+		log.Println(err)
+	}
+
+	return newFileEntry, nil
+}
+
+// unstageParts removes the parts staged after the given number of parts, and moves the offset of the
+// upload back to the given offset.
+func unstageParts(user *models.User, upload *models.Upload, offset int64, parts int) error {
+	for part := parts; part < upload.Parts; part++ {
+		if err := storage.GetBackend().Delete(upload.PartKey(user.UUID, part)); err != nil {
+			return err
+		}
+	}
+
+	upload.Offset, upload.Parts = offset, parts
+	return lib.GetDatabase().Model(upload).Updates(map[string]interface{}{
+		"offset": upload.Offset,
+		"parts":  upload.Parts,
+	}).Error
+}
+
+// partsReader reads the staged parts of an upload one after another. Only one part is open at a time.
+type partsReader struct {
+	user    *models.User
+	upload  *models.Upload
+	dataKey []byte
+
+	part    int
+	current io.Reader
+	closer  io.Closer
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.current == nil {
+			if pr.part == pr.upload.Parts {
+				return 0, io.EOF
+			}
+
+			if err := pr.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := pr.current.Read(p)
+		if err == io.EOF {
+			pr.closer.Close()
+			pr.current = nil
+			pr.part++
+			err = nil
+		}
+
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// Close closes the part which is currently open.
+func (pr *partsReader) Close() error {
+	if pr.current == nil {
+		return nil
+	}
+
+	pr.current = nil
+	return pr.closer.Close()
+}
+
+func (pr *partsReader) open() error {
+	blob, err := storage.GetBackend().Get(pr.upload.PartKey(pr.user.UUID, pr.part))
+	if err != nil {
+		return err
+	}
+
+	pr.current, pr.closer = blob, blob
+	if pr.dataKey != nil {
+		pr.current, err = crypt.NewKeyReader(blob, pr.dataKey)
+		if err != nil {
+			blob.Close()
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
+)
+
+// tusRequest sends a tus request as the user. The data of the PATCH requests is sent from the offset.
+func tusRequest(t *testing.T, user *models.User, method, path string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, body)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		r.Header.Set("Content-Type", "application/offset+octet-stream")
+	}
+
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+
+	return serve(t, user, r)
+}
+
+// createTusUpload creates an upload of the given length, and returns its location.
+func createTusUpload(t *testing.T, user *models.User, filename string, length string, headers map[string]string) string {
+	t.Helper()
+
+	all := map[string]string{
+		"Upload-Length":   length,
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	}
+	for name, value := range headers {
+		all[name] = value
+	}
+
+	rec := tusRequest(t, user, http.MethodPost, "/api/v1/uploads", nil, all)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("could not create the upload. status=%d, body=%s", rec.Code, rec.Body)
+	}
+
+	return rec.Header().Get("Location")
+}
+
+// patchTusUpload sends the data of the upload from the offset.
+func patchTusUpload(t *testing.T, user *models.User, location, offset, data string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	all := map[string]string{"Upload-Offset": offset}
+	for name, value := range headers {
+		all[name] = value
+	}
+
+	return tusRequest(t, user, http.MethodPatch, location, strings.NewReader(data), all)
+}
+
+// uploadOffset returns the offset of the upload from a HEAD request, or the status code if the request
+// fails.
+func uploadOffset(t *testing.T, user *models.User, location string) (string, int) {
+	t.Helper()
+
+	rec := tusRequest(t, user, http.MethodHead, location, nil, nil)
+	return rec.Header().Get("Upload-Offset"), rec.Code
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	header := "filename " + base64.StdEncoding.EncodeToString([]byte("notes.txt")) + ",is_confidential,  "
+
+	metadata, err := parseTusMetadata(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata["filename"] != "notes.txt" {
+		t.Errorf("wrong filename: %q", metadata["filename"])
+	}
+
+	if value, ok := metadata["is_confidential"]; !ok || value != "" {
+		t.Errorf("a key without a value should be empty, got: %q", value)
+	}
+
+	for _, invalid := range []string{"filename not-base64!", "filename a b"} {
+		if _, err := parseTusMetadata(invalid); err != errInvalidInput {
+			t.Errorf("expected invalid input for %q, got: %v", invalid, err)
+		}
+	}
+}
+
+func TestTusVersionIsRequired(t *testing.T) {
+	rec := httptest.NewRecorder()
+	TusCreate(rec, httptest.NewRequest(http.MethodPost, "/api/v1/uploads", nil), nil)
+
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("wrong response without a version. status=%d, version=%q", rec.Code, rec.Header().Get("Tus-Version"))
+	}
+}
+
+func TestTusUpload(t *testing.T) {
+	user := newTestUser(t)
+	location := createTusUpload(t, user, "notes.txt", "10", nil)
+
+	if offset, status := uploadOffset(t, user, location); status != http.StatusOK || offset != "0" {
+		t.Fatalf("wrong offset of a new upload. status=%d, offset=%q", status, offset)
+	}
+
+	rec := patchTusUpload(t, user, location, "0", "hello", nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("could not send the first part. status=%d, offset=%q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// The data needs to continue from the offset of the upload.
+	if rec := patchTusUpload(t, user, location, "0", "hello", nil); rec.Code != http.StatusConflict {
+		t.Errorf("wrong status code for a wrong offset. want=409, got=%d", rec.Code)
+	}
+
+	// The other users don't see the upload.
+	if _, status := uploadOffset(t, newTestUser(t), location); status != http.StatusNotFound {
+		t.Errorf("another user should not find the upload. status=%d", status)
+	}
+
+	if offset, _ := uploadOffset(t, user, location); offset != "5" {
+		t.Errorf("wrong offset after the first part. want=5, got=%q", offset)
+	}
+
+	rec = patchTusUpload(t, user, location, "5", "world", nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upfi-File") == "" {
+		t.Fatalf("could not complete the upload. status=%d, body=%s", rec.Code, rec.Body)
+	}
+
+	file, err := models.FindOneFile(&models.File{UUID: rec.Header().Get("Upfi-File")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("helloworld"))
+	if file.Filename != "notes.txt" || file.Size != 10 || file.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("wrong file created. filename=%q, size=%d, hash=%q", file.Filename, file.Size, file.Hash)
+	}
+
+	// The upload is removed along with its parts once the file has been created.
+	if _, status := uploadOffset(t, user, location); status != http.StatusNotFound {
+		t.Errorf("the completed upload should have been removed. status=%d", status)
+	}
+
+	parts, err := storage.GetBackend().List(user.UUID + "/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parts) != 0 {
+		t.Errorf("the parts should have been removed, %d left", len(parts))
+	}
+}
+
+func TestTusEncryptedUpload(t *testing.T) {
+	user := newTestUser(t)
+	master := map[string]string{masterHeader: testMaster}
+	location := createTusUpload(t, user, "secret.txt", "6", master)
+
+	// The data of an encrypted upload cannot be sent without the master password.
+	if rec := patchTusUpload(t, user, location, "0", "sec", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("wrong status code without the master password. want=403, got=%d", rec.Code)
+	}
+
+	if rec := patchTusUpload(t, user, location, "0", "sec", master); rec.Code != http.StatusNoContent {
+		t.Fatalf("could not send the first part. status=%d, body=%s", rec.Code, rec.Body)
+	}
+
+	rec := patchTusUpload(t, user, location, "3", "ret", master)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("could not complete the upload. status=%d, body=%s", rec.Code, rec.Body)
+	}
+
+	fileID := rec.Header().Get("Upfi-File")
+	file, err := models.FindOneFile(&models.File{UUID: fileID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file.ShareableFile || file.Size != 6 {
+		t.Errorf("the file should be encrypted. shareable=%t, size=%d", file.ShareableFile, file.Size)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/files/"+fileID+"/download", nil)
+	r.Header.Set(masterHeader, testMaster)
+	if rec := serve(t, user, r); rec.Code != http.StatusOK || rec.Body.String() != "secret" {
+		t.Errorf("wrong download. status=%d, body=%q", rec.Code, rec.Body)
+	}
+}
+
+func TestTusFailedCompletionIsRetried(t *testing.T) {
+	user := newTestUser(t)
+	location := createTusUpload(t, user, "large.txt", "10", nil)
+
+	if rec := patchTusUpload(t, user, location, "0", "hello", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("could not send the first part. status=%d", rec.Code)
+	}
+
+	// The quota is checked again when the upload is completed.
+	if err := lib.GetDatabase().Model(user).Update("quota_bytes", 5).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := patchTusUpload(t, user, location, "5", "world", nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("wrong status code over the quota. want=413, got=%d", rec.Code)
+	}
+
+	// The last part is removed, so that it can be sent again.
+	if offset, status := uploadOffset(t, user, location); status != http.StatusOK || offset != "5" {
+		t.Errorf("the offset should have been moved back. status=%d, offset=%q", status, offset)
+	}
+
+	if err := lib.GetDatabase().Model(user).Update("quota_bytes", 0).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := patchTusUpload(t, user, location, "5", "world", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("the completion should succeed on retry. status=%d, body=%s", rec.Code, rec.Body)
+	}
+}
+
+func TestTusDelete(t *testing.T) {
+	user := newTestUser(t)
+	location := createTusUpload(t, user, "removed.txt", "10", nil)
+
+	if rec := patchTusUpload(t, user, location, "0", "hello", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("could not send the first part. status=%d", rec.Code)
+	}
+
+	if rec := tusRequest(t, user, http.MethodDelete, location, nil, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("could not terminate the upload. status=%d", rec.Code)
+	}
+
+	if _, status := uploadOffset(t, user, location); status != http.StatusNotFound {
+		t.Errorf("the terminated upload should not be found. status=%d", status)
+	}
+
+	parts, err := storage.GetBackend().List(user.UUID + "/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parts) != 0 {
+		t.Errorf("the parts should have been removed, %d left", len(parts))
+	}
+}
@@ -0,0 +1,182 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/nireo/upfi/models"
+)
+
+const (
+	// maxFieldSize is the largest text field accepted in an upload form.
+	maxFieldSize = 4 << 10
+
+	// maxFormOverhead is the space left for the text fields and the multipart boundaries on top of the
+	// file itself.
+	maxFormOverhead = 1 << 20
+)
+
+// maxUploadSize is the largest file which can be uploaded. It can be changed with the 'max_upload_size'
+// environment variable.
+var maxUploadSize int64 = 10 << 30 // 10 gb
+
+// errTooLarge is returned when the uploaded file is larger than maxUploadSize.
+var errTooLarge = errors.New("the file is larger than the maximum upload size")
+
+// LoadUploadLimit reads the maximum upload size in bytes from the 'max_upload_size' environment
+// variable. If it's not set, the default size is used.
+func LoadUploadLimit() error {
+	value := os.Getenv("max_upload_size")
+	if value == "" {
+		return nil
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("invalid max_upload_size: %q", value)
+	}
+
+	maxUploadSize = size
+	return nil
+}
+
+// receiveFile reads an upload form and stores the file. The form is read as a stream, so the file goes
+// through the mimetype detection, hashing and encryption into the storage backend without being
+// buffered. Since the file is encrypted while it's being received, the 'master', 'description' and
+// 'folder' fields need to come before the file in the form.
+func receiveFile(r *http.Request, user *models.User) (*models.File, error) {
+	file, trailing, err := receiveUpload(r, func(content io.Reader, filename string, fields map[string]string) (*models.File, error) {
+		return createFile(user, content, filename, fields["master"], fields["description"], fields["folder"])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The fields after the file would be ignored, which could store a file meant to be encrypted as
+	// plaintext. Such uploads are removed.
+	if trailing {
+		file.Delete(user.UUID)
+		return nil, errInvalidInput
+	}
+
+	return file, nil
+}
+
+// storeFunc stores the contents of an uploaded file, which has the given filename. The fields contain
+// the text fields which came before the file in the form.
+type storeFunc func(content io.Reader, filename string, fields map[string]string) (*models.File, error)
+
+// receiveUpload reads an upload form as a stream and gives the file to store along with the text fields
+// before it. If the form has more parts after the file, trailing is true. The size of the body is limited
+// before the csrf middleware in StartServer.
+func receiveUpload(r *http.Request, store storeFunc) (file *models.File, trailing bool, err error) {
+	// The csrf middleware parses the whole form if the token isn't given in the X-CSRF-Token header,
+	// which the upload forms send, so only the other clients end up here.
+	if r.MultipartForm != nil {
+		headers := r.MultipartForm.File["file"]
+		if len(headers) == 0 {
+			return nil, false, errInvalidInput
+		}
+
+		content, err := headers[0].Open()
+		if err != nil {
+			return nil, false, err
+		}
+		defer content.Close()
+
+		fields := make(map[string]string)
+		for name, values := range r.MultipartForm.Value {
+			if len(values) > 0 {
+				fields[name] = values[0]
+			}
+		}
+
+		file, err := store(content, headers[0].Filename, fields)
+		return file, false, err
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, false, errInvalidInput
+	}
+
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			// The form didn't contain a file.
+			return nil, false, errInvalidInput
+		}
+		if err != nil {
+			return nil, false, errInvalidInput
+		}
+
+		if part.FormName() != "file" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFieldSize+1))
+			part.Close()
+			if err != nil || len(value) > maxFieldSize {
+				return nil, false, errInvalidInput
+			}
+
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		file, err := store(part, part.FileName(), fields)
+		part.Close()
+		if err != nil {
+			return nil, false, err
+		}
+
+		next, err := reader.NextPart()
+		if next != nil {
+			next.Close()
+		}
+
+		return file, err != io.EOF, nil
+	}
+}
+
+// limitReader returns the given error if more than the limit of bytes are read through it.
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+// newUploadLimit returns a reader which allows reading the maximum upload size or the user's remaining
+// quota from the content, whichever is smaller.
+func newUploadLimit(user *models.User, content io.Reader) io.Reader {
+	if remaining := user.RemainingQuota(); remaining >= 0 && remaining < maxUploadSize {
+		return &limitReader{r: content, remaining: remaining, err: models.ErrQuotaExceeded}
+	}
+
+	return &limitReader{r: content, remaining: maxUploadSize, err: errTooLarge}
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return n, lr.err
+	}
+
+	return n, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/nireo/upfi/models"
+)
+
+func TestUploadLimit(t *testing.T) {
+	quota := int64(10)
+	user := &models.User{QuotaBytes: &quota, UsedBytes: 4}
+
+	// The user has 6 bytes left.
+	if _, err := ioutil.ReadAll(newUploadLimit(user, strings.NewReader("123456"))); err != nil {
+		t.Errorf("the upload should fit into the quota, err: %v", err)
+	}
+
+	if _, err := ioutil.ReadAll(newUploadLimit(user, strings.NewReader("1234567"))); err != models.ErrQuotaExceeded {
+		t.Errorf("expected the quota to be exceeded, got: %v", err)
+	}
+
+	// Without a quota only the maximum upload size applies.
+	limit := maxUploadSize
+	defer func() { maxUploadSize = limit }()
+	maxUploadSize = 8
+
+	user = &models.User{}
+	if _, err := io.Copy(ioutil.Discard, newUploadLimit(user, strings.NewReader("123456789"))); err != errTooLarge {
+		t.Errorf("expected the upload to be too large, got: %v", err)
+	}
+}
+
+func TestParseFileSize(t *testing.T) {
+	tests := map[string]int64{
+		"100":    100,
+		"1.5 kB": 1500,
+		"500 MB": 500 * 1000 * 1000,
+		"2GB":    2 * 1000 * 1000 * 1000,
+		"0":      0,
+	}
+
+	for value, want := range tests {
+		if got, err := parseFileSize(value); err != nil || got != want {
+			t.Errorf("wrong size for %q. want=%d, got=%d, err: %v", value, want, got, err)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "-5", "5 XB", "5 MiB"} {
+		if _, err := parseFileSize(value); err != errInvalidInput {
+			t.Errorf("expected invalid input for %q, got: %v", value, err)
+		}
+	}
+}
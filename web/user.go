@@ -1,21 +1,22 @@
 package web
 
 import (
+	"errors"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nireo/upfi/lib"
 	"github.com/nireo/upfi/models"
 	"github.com/nireo/upfi/templates"
+	"gorm.io/gorm"
 )
 
 // ServeSettingsPage serves the user a settings page, in which they can configure their account settings.
 // Also does checking if the user is logged in. After all the checking serve a html template, which is used
 // to display current user configuration.
 func ServeSettingsPage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	w.Header().Set("Content-Type", "text/html")
 	username := r.Header.Get("username")
 
 	// Find the user from the database, so that we can display the user's current settings.
@@ -25,13 +26,66 @@ func ServeSettingsPage(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 		return
 	}
 
+	renderSettings(w, r, user, "")
+}
+
+// renderSettings renders the settings page of the user. A newly created api token is shown on the page
+// only once, right after it has been created.
+func renderSettings(w http.ResponseWriter, r *http.Request, user *models.User, newToken string) {
+	tokens, err := user.FindAPITokens()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	// If a master password change was interrupted, the user is asked to finish it.
+	_, pendingErr := models.FindMasterKeyChange(user.ID)
+	if pendingErr != nil && !errors.Is(pendingErr, gorm.ErrRecordNotFound) {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	sessions, err := user.FindSessions()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	usage, err := user.UsageByMIME()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	versionUsage, err := user.VersionUsage()
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	rows := make([]templates.UsageRow, len(usage))
+	for i, mime := range usage {
+		rows[i] = templates.UsageRow{MIME: mime.MIME, Files: mime.Files, Size: formatFileSize(mime.Bytes)}
+	}
+
 	params := templates.SettingsParams{
-		User:          user,
-		Authenticated: true,
-		Title:         "settings",
+		User:                user,
+		Authenticated:       true,
+		Title:               "settings",
+		MasterChangePending: pendingErr == nil,
+		Tokens:              tokens,
+		Scopes:              models.Scopes,
+		NewToken:            newToken,
+		Sessions:            sessions,
+		CurrentSession:      r.Header.Get("session"),
+		Used:                formatFileSize(user.UsedBytes),
+		Quota:               formatQuota(user.Quota()),
+		Usage:               rows,
+		VersionsUsed:        formatFileSize(versionUsage),
 	}
 
 	// Serve the settings page with the given parameters.
+	w.Header().Set("Content-Type", "text/html")
 	templates.Settings(w, params)
 }
 
@@ -41,7 +95,6 @@ func HandleSettingChange(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 	// The auth token middleware appends the user's username in to the request header, if the
 	// execution is successful.
 	username := r.Header.Get("username")
-	db := lib.GetDatabase()
 
 	// Load the user using the username, so that we can change the settings, and then later
 	// save to changes to the database.
@@ -65,28 +118,17 @@ func HandleSettingChange(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 	}
 
 	// Check if the user has decided to update their username
-	newUsername := r.Form["username"][0]
-	if !lib.IsUsernameValid(newUsername) {
-		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
-		return
-	}
-
-	// Check that there are no conflicts with an existing user
-	if err := db.Where(&models.User{Username: newUsername}).Error; err == nil {
-		ErrorPageHandler(w, r, lib.ConflictErrorPage)
+	if err := changeUsername(user, r.Form["username"][0]); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
 		return
 	}
 
-	// Update the new username and save the changes to the database
-	user.Username = newUsername
-	db.Save(&user)
-
 	// Send user status codes which indicate that the request was successful
 	http.Redirect(w, r, "/settings", http.StatusMovedPermanently)
 }
 
 // DeleteUser handles a total account deletion that includes deleting all information about the user and his/her files.
-// Also deletes the user's token so that user can't make requests with a invalid username that doesn't exist.
+// Also deletes the user's sessions so that user can't make requests with a invalid username that doesn't exist.
 func DeleteUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// The auth token middleware appends the user's username in to the request header, if the
 	// execution is successful.
@@ -94,31 +136,21 @@ func DeleteUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
 	// Find the user since we need the user struct to delete the user from the database, also we need the
 	// user's uuid to delete all of his/her files.
-	db := lib.GetDatabase()
 	user, err := models.FindOneUser(&models.User{Username: username})
 	if err != nil {
 		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
 		return
 	}
 
-	// Remove the hole directory we created at registeration.
-	if err := os.Remove(lib.AddRootToPath("files/") + user.UUID); err != nil {
+	// Remove all of the user's files from the storage and then the user entry from the database.
+	if err := user.Delete(); err != nil {
 		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 		return
 	}
 
-	// After all the other things have been deleted, we delete the user entry from the database.
-	db.Delete(&user)
-
-	// Remove the user's authentication cookie
-	c := &http.Cookie{
-		Name:    "token",
-		Value:   "",
-		Expires: time.Unix(0, 0),
-
-		HttpOnly: true,
-	}
-	http.SetCookie(w, c)
+	// Remove the user's authentication cookie. The sessions have been removed with the user, so the
+	// token cannot be used anymore either.
+	clearTokenCookie(w)
 	// Redirect the user to the home page, so they don't get stuck in authorized pages.
 	http.Redirect(w, r, "/", http.StatusMovedPermanently)
 }
@@ -130,7 +162,6 @@ func UpdatePassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 	// execution is successful.
 	username := r.Header.Get("username")
 
-	db := lib.GetDatabase()
 	// Load the user model since we need it to check the validity of the current password and to
 	// update the user model with the new hashed password
 	user, err := models.FindOneUser(&models.User{Username: username})
@@ -153,32 +184,240 @@ func UpdatePassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 	}
 
 	// Take the current and new password from the request and do some checking on them.
-	currentPassword := r.Form["password"][0]
-	newPassword := r.Form["newPassword"][0]
+	if err := changePassword(user, r.Form["password"][0], r.Form["newPassword"][0], r.Header.Get("session")); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	// Redirect the user back to the /settings page, where the request originally came from.
+	http.Redirect(w, r, "/settings", http.StatusMovedPermanently)
+}
+
+// ChangeMaster changes the user's file encryption master password given the current and the new master
+// password. The data keys of all of the encrypted files are wrapped with the new master password, and
+// the new master password is taken into use only after all of the files are done. If the change gets
+// interrupted, submitting the same passwords again resumes it.
+func ChangeMaster(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// The auth token middleware appends the user's username in to the request header, if the
+	// execution is successful.
+	username := r.Header.Get("username")
+
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	err = r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	if len(r.Form["master"]) == 0 || len(r.Form["newMaster"]) == 0 {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	if err := changeMaster(user, r.Form["master"][0], r.Form["newMaster"][0]); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
 
+	// Redirect the user back to the /settings page, where the request originally came from.
+	http.Redirect(w, r, "/settings", http.StatusMovedPermanently)
+}
+
+// changeUsername changes the user's username, if the new username is valid and not taken.
+func changeUsername(user *models.User, newUsername string) error {
+	if !lib.IsUsernameValid(newUsername) {
+		return errInvalidInput
+	}
+
+	// Check that there are no conflicts with an existing user
+	if _, err := models.FindOneUser(&models.User{Username: newUsername}); err == nil {
+		return errConflict
+	}
+
+	// Update the new username and save the changes to the database
+	user.Username = newUsername
+	return lib.GetDatabase().Save(user).Error
+}
+
+// changePassword changes the user's login password, if the current password is correct. All of the
+// user's other sessions than the current one are revoked, so that anyone who knew the old password is
+// signed out.
+func changePassword(user *models.User, currentPassword, newPassword, currentSession string) error {
 	// We don't need to check the validity of the currentPassword since this password has already
 	// been checked when the user registered.
 	if !lib.IsPasswordValid(newPassword) {
-		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
-		return
+		return errInvalidInput
 	}
 
 	// Check that the current password in the form matches the one on the user model.
 	if !lib.CheckPasswordHash(currentPassword, user.Password) {
-		ErrorPageHandler(w, r, lib.ForbiddenErrorPage)
-		return
+		return errNoAccess
 	}
 
 	// Since all the checking is valid, we hash the new password and the update the password fields on the
 	// database entry.
 	newHashedPassword, err := lib.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	user.Password = newHashedPassword
+
+	if err := lib.GetDatabase().Save(user).Error; err != nil {
+		return err
+	}
+
+	return user.RevokeSessions(currentSession)
+}
+
+// changeMaster changes the user's master password and re-wraps the data keys of the encrypted files.
+func changeMaster(user *models.User, master, newMaster string) error {
+	if !lib.IsPasswordValid(newMaster) {
+		return errInvalidInput
+	}
+
+	// The current master password is needed to unwrap the data keys, so check it before doing anything.
+	if !lib.CheckPasswordHash(master, user.FileEncryptionMaster) {
+		return errWrongMaster
+	}
+
+	job, err := models.StartMasterKeyChange(user, newMaster)
+	if err != nil {
+		return err
+	}
+
+	return rekeyFiles(user, job, master, newMaster)
+}
+
+// CreateAPIToken creates a personal api token from the form on the settings page. The form contains the
+// token's name, the scopes and optionally the number of days until the token expires. The token is
+// shown on the settings page once.
+func CreateAPIToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	username := r.Header.Get("username")
+
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" || len(name) > 64 || len(r.Form["scopes"]) == 0 {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	for _, scope := range r.Form["scopes"] {
+		if !models.ValidScope(scope) {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+			return
+		}
+	}
+
+	// The token never expires, if the number of days is not given.
+	var expiresAt *time.Time
+	if days := r.FormValue("expires"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+			return
+		}
+
+		expiration := time.Now().AddDate(0, 0, n)
+		expiresAt = &expiration
+	}
+
+	token, _, err := models.CreateAPIToken(user, name, r.Form["scopes"], expiresAt)
 	if err != nil {
 		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
 		return
 	}
-	user.Password = newHashedPassword
-	db.Save(&user)
 
-	// Redirect the user back to the /settings page, where the request originally came from.
-	http.Redirect(w, r, "/settings", http.StatusMovedPermanently)
+	renderSettings(w, r, user, token)
+}
+
+// RevokeAPIToken removes one of the user's api tokens, after which it cannot be used anymore.
+func RevokeAPIToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	username := r.Header.Get("username")
+
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	if err := user.RevokeAPIToken(uint(id)); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// RevokeSession signs out one of the user's sessions from the settings page.
+func RevokeSession(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	username := r.Header.Get("username")
+
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+		return
+	}
+
+	if err := user.RevokeUserSession(uint(id)); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// SignOutEverywhere revokes all of the user's sessions, including the current one.
+func SignOutEverywhere(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	username := r.Header.Get("username")
+
+	user, err := models.FindOneUser(&models.User{Username: username})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	if err := user.RevokeSessions(""); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	clearTokenCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
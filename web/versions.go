@@ -0,0 +1,263 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/upfi/crypt"
+	"github.com/nireo/upfi/lib"
+	"github.com/nireo/upfi/models"
+	"github.com/nireo/upfi/storage"
+)
+
+// UploadVersion replaces the contents of a file owned by the user, or shared to the user with the upload
+// permission, with a new version. The earlier contents are kept as a version, which can be downloaded
+// and restored later by the owner. Encrypted files need the master password in the 'master' field before
+// the file in the form.
+func UploadVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		ErrorPageHandler(w, r, lib.NotFoundErrorPage)
+		return
+	}
+
+	file, owner, err := findAccessibleFile(user, fileIDParam(r, ps), models.PermissionUpload)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	// The fields after the file don't matter, since the file stays encrypted or unencrypted.
+	if _, _, err := receiveUpload(r, func(content io.Reader, _ string, fields map[string]string) (*models.File, error) {
+		dataKey, err := versionDataKey(user, owner, file, fields["master"])
+		if err != nil {
+			return nil, err
+		}
+
+		return file, storeVersion(owner, file, content, dataKey)
+	}); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	http.Redirect(w, r, filePath(file), http.StatusSeeOther)
+}
+
+// versionDataKey returns the data key with which a new version of an encrypted file is encrypted, or nil
+// for unencrypted files. The owner unwraps the key with their master password. A recipient unwraps the
+// key sealed to them with their own master password, unless the share was created before the key pairs
+// existed, in which case the owner's master password is needed.
+func versionDataKey(user, owner *models.User, file *models.File, master string) ([]byte, error) {
+	if file.ShareableFile {
+		return nil, nil
+	}
+
+	if master == "" {
+		return nil, errInvalidInput
+	}
+
+	if user.ID != owner.ID {
+		share, err := models.FindFileShare(file.ID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(share.WrappedKey) != 0 {
+			return sharedDataKey(share, user, master)
+		}
+	}
+
+	kek, err := owner.MasterKey(master)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files encrypted with the master password itself get a data key first.
+	if err := upgradeEncryption(file, owner.UUID, kek, master); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := file.DataKey(kek)
+	if err != nil {
+		if errors.Is(err, crypt.ErrInvalidCiphertext) {
+			return nil, errWrongMaster
+		}
+		return nil, err
+	}
+
+	return dataKey, nil
+}
+
+// storeVersion stores the content as the new version of the file, which is owned by the user. The
+// contents of an encrypted file are encrypted with the file's data key. After the new version has been
+// stored, the versions the user doesn't want to keep are removed.
+func storeVersion(user *models.User, file *models.File, content io.Reader, dataKey []byte) error {
+	buffered := bufio.NewReader(newUploadLimit(user, content))
+	fileHeader, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	mime := http.DetectContentType(fileHeader)
+
+	hash := newContentHash(dataKey)
+	counter := &countingReader{r: io.TeeReader(buffered, hash)}
+
+	// Every version has its own key, so the earlier contents stay where they are.
+	key := user.UUID + "/" + lib.GenerateUUID() + file.Extension
+	if dataKey != nil {
+		err = storeWithDataKey(key, counter, dataKey)
+	} else {
+		_, err = storage.GetBackend().Put(key, counter)
+	}
+	if err != nil {
+		storage.GetBackend().Delete(key)
+		return err
+	}
+
+	if err := file.AddVersion(user.UUID, &models.Content{
+		Key:       key,
+		Size:      counter.n,
+		SizeHuman: formatFileSize(counter.n),
+		Hash:      encodeContentHash(hash.Sum(nil), dataKey),
+		MIME:      mime,
+	}); err != nil {
+		storage.GetBackend().Delete(key)
+		return err
+	}
+
+	return file.PruneVersions(user)
+}
+
+// filePath returns the path of the file's page.
+func filePath(file *models.File) string {
+	return "/file?file=" + file.UUID
+}
+
+// findOwnedVersion finds the file owned by the user and its version, which are given in the 'file' and
+// 'version' queries. Both of the ids are required.
+func findOwnedVersion(r *http.Request, ps httprouter.Params) (*models.User, *models.File, *models.FileVersion, error) {
+	versionID := r.URL.Query().Get("version")
+	if versionID == "" {
+		return nil, nil, nil, errInvalidInput
+	}
+
+	user, err := models.FindOneUser(&models.User{Username: r.Header.Get("username")})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	file, err := findOwnedFile(user, fileIDParam(r, ps))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	version, err := file.FindVersion(versionID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return user, file, version, nil
+}
+
+// DownloadVersion lets the owner of a file download one of its earlier versions. The versions of an
+// encrypted file need the master password in the 'master' field.
+func DownloadVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, file, version, err := findOwnedVersion(r, ps)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	var master string
+	if !file.ShareableFile {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			ErrorPageHandler(w, r, lib.BadRequestErrorPage)
+			return
+		}
+		master = r.FormValue("master")
+	}
+
+	if err := sendFile(w, r, version.AsFile(file), user, master); err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+}
+
+// RestoreVersion makes an earlier version the current contents of the file. The current contents are
+// kept as a version.
+func RestoreVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, file, version, err := findOwnedVersion(r, ps)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := file.RestoreVersion(user.UUID, version); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, filePath(file), http.StatusSeeOther)
+}
+
+// DeleteVersion removes an earlier version of the file for good.
+func DeleteVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	_, file, version, err := findOwnedVersion(r, ps)
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := file.DeleteVersion(version); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, filePath(file), http.StatusSeeOther)
+}
+
+// UpdateVersionRetention changes how many earlier versions of each file the user keeps, and for how many
+// days. An empty field means that the versions are not limited by it.
+func UpdateVersionRetention(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	keep, err := parseRetention(r.FormValue("keep"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	days, err := parseRetention(r.FormValue("days"))
+	if err != nil {
+		ErrorPageHandler(w, r, errorContent(err))
+		return
+	}
+
+	if err := user.SetVersionRetention(keep, days); err != nil {
+		ErrorPageHandler(w, r, lib.InternalServerErrorPage)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// parseRetention parses a retention limit, which is either empty or a non-negative number.
+func parseRetention(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, errInvalidInput
+	}
+
+	return limit, nil
+}
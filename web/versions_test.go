@@ -0,0 +1,31 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := map[string]int{"": 0, "0": 0, "5": 5}
+	for value, want := range tests {
+		if got, err := parseRetention(value); err != nil || got != want {
+			t.Errorf("wrong retention for %q. want=%d, got=%d, err: %v", value, want, got, err)
+		}
+	}
+
+	for _, value := range []string{"-1", "five", "1.5"} {
+		if _, err := parseRetention(value); err != errInvalidInput {
+			t.Errorf("expected invalid input for %q, got: %v", value, err)
+		}
+	}
+}
+
+func TestFindOwnedVersionRequiresID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/versions/delete?file=id&version=", nil)
+	if _, _, _, err := findOwnedVersion(r, httprouter.Params{}); err != errInvalidInput {
+		t.Errorf("expected invalid input for an empty version id, got: %v", err)
+	}
+}